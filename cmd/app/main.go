@@ -32,7 +32,7 @@ func main() {
 	//Initialize router
 	r := router.New()
 
-	repo := repo.New(db)
+	repo := repo.New(db).WithLongitudeConvention(cfg.Geo.LongitudeConvention)
 
 	service := service.New(repo)
 
@@ -45,6 +45,11 @@ func main() {
 
 	// Register routes
 	r.GET("/", pageCtrl.GetMainPage)
+	r.GET("/api/cities", pageCtrl.GetCities)
+	r.GET("/api/cities/:scheme/:code", pageCtrl.GetCitiesByCode)
+	r.GET("/api/code-mismatches", pageCtrl.GetCodeMismatches)
+	r.GET("/api/population/:id", pageCtrl.GetPopulationHistory)
+	r.GET("/api/growth", pageCtrl.GetGrowthRates)
 
 	// Start server with both router and static handler
 	http.Handle("/", r)
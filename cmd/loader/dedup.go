@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"settlements/internal/repo"
+	"settlements/internal/service/dedup"
+
+	"gorm.io/gorm"
+)
+
+// runDedup lists likely duplicate settlements of a loaded dataset and,
+// with -apply, merges them. Merges are not remembered: loading the same
+// file into the dataset again brings the merged settlements back, so such
+// loads should use -dedup or be followed by another dedup -apply.
+func runDedup(args []string) {
+	fs := flag.NewFlagSet("dedup", flag.ExitOnError)
+	datasetRef := fs.String("dataset", "", "Dataset to deduplicate, by label or ID (default: the latest)")
+	similarity := fs.Float64("similarity", dedup.DefaultMinSimilarity, "Lowest similarity of normalised names (0..1) for settlements to be duplicates")
+	distance := fs.Float64("distance-km", dedup.DefaultMaxDistanceKm, "Largest distance between duplicates, in kilometres")
+	apply := fs.Bool("apply", false, "Merge the duplicates, summing population and children into the most populous one (a later load of the same file brings them back; load with -dedup to merge again)")
+	format := fs.String("format", "table", "Output format: \"table\" or \"json\"")
+	out := fs.String("out", "-", "Write the candidates to this file (\"-\" for stdout)")
+	fs.Parse(args)
+
+	opts := dedupOptions(*similarity, *distance)
+	if *format != "table" && *format != "json" {
+		log.Fatalf("Invalid flags: unknown format %q", *format)
+	}
+
+	// Listing the duplicates only reads, so it does not migrate
+	cfg := loadConfig()
+	var db *gorm.DB
+	if *apply {
+		db = connect(cfg)
+	} else {
+		db = connectChecked(cfg)
+	}
+	dataset, err := findDataset(context.Background(), repo.New(db), *datasetRef)
+	if err != nil {
+		log.Fatalf("Failed to find dataset: %v", err)
+	}
+
+	store := dedup.NewStore(db)
+	cities, err := store.Cities(dataset.ID)
+	if err != nil {
+		log.Fatalf("Failed to read dataset %q: %v", dataset.Label, err)
+	}
+	report := dedup.Find(cities, opts)
+
+	w := os.Stdout
+	if *out != "-" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Failed to create output file: %v", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if *format == "json" {
+		err = report.WriteJSON(w)
+	} else {
+		err = report.WriteTable(w)
+	}
+	if err != nil {
+		log.Fatalf("Failed to write candidates: %v", err)
+	}
+
+	if !*apply || len(report.Groups) == 0 {
+		return
+	}
+	dropped, err := store.Apply(report.Groups)
+	if err != nil {
+		log.Fatalf("Failed to merge duplicates: %v", err)
+	}
+	log.Printf("Merged %d duplicates of dataset %q into %d settlements", dropped, dataset.Label, len(report.Groups))
+}
+
+// dedupOptions validates the dedup flag values.
+func dedupOptions(similarity, distanceKm float64) dedup.Options {
+	if similarity <= 0 || similarity > 1 {
+		log.Fatalf("Invalid flags: the name similarity must be in (0, 1]")
+	}
+	if distanceKm < 0 {
+		log.Fatalf("Invalid flags: the duplicate distance must not be negative")
+	}
+	return dedup.Options{MinSimilarity: similarity, MaxDistanceKm: distanceKm}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"settlements/internal/dto"
+	"settlements/internal/repo"
+	"settlements/internal/service/data_loader"
+	"settlements/internal/service/dataset_diff"
+
+	"gorm.io/gorm"
+)
+
+// runDiff compares two datasets, or a dataset file with a loaded dataset.
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	from := fs.String("from", "", "Loaded dataset to compare from, by label or ID")
+	fromFile := fs.String("from-file", "", "Dataset file to compare from instead of a loaded dataset")
+	to := fs.String("to", "", "Loaded dataset to compare to, by label or ID (default: the latest)")
+	toFile := fs.String("to-file", "", "Dataset file to compare to instead of a loaded dataset")
+	profileName := fs.String("profile", data_loader.DefaultProfileName, "Column mapping profile for dataset files")
+	tolerance := fs.Float64("tolerance-km", dataset_diff.DefaultToleranceKm, "Report settlements that moved further than this, in kilometres")
+	format := fs.String("format", "table", "Output format: \"table\" or \"json\"")
+	out := fs.String("out", "-", "Write the diff to this file (\"-\" for stdout)")
+	rules := fs.String("rules", "", "Override rule actions for dataset files as reason=action pairs")
+	longitude := fs.String("longitude-convention", "", "Compare longitudes in this convention (default: LONGITUDE_CONVENTION, else signed)")
+	inputFormat := fs.String("input-format", "auto", "Format of dataset files: \"csv\", \"geojson\", \"ndjson\", \"xlsx\" or \"auto\" to pick it by extension")
+	sheet := fs.String("sheet", "", "Worksheet of XLSX dataset files (default: the first sheet)")
+	headerOffset := fs.Int("header-offset", 0, "Number of rows above the header of XLSX dataset files")
+	encodingName := fs.String("encoding", "auto", "Encoding of dataset files: \"auto\", \"utf-8\", \"cp1251\" or \"koi8-r\"")
+	delimiter := fs.String("delimiter", "auto", "Field delimiter of dataset files: a single character, \"tab\" or \"auto\"")
+	fs.Parse(args)
+
+	if (*from == "") == (*fromFile == "") {
+		log.Fatalf("Invalid flags: exactly one of -from and -from-file is required")
+	}
+	if *to != "" && *toFile != "" {
+		log.Fatalf("Invalid flags: -to and -to-file are mutually exclusive")
+	}
+	if *format != "table" && *format != "json" {
+		log.Fatalf("Invalid flags: unknown format %q", *format)
+	}
+
+	profile, err := data_loader.LoadProfile(*profileName)
+	if err != nil {
+		log.Fatalf("Invalid flags: %v", err)
+	}
+	ruleSet, err := data_loader.ConfigureRules(data_loader.DefaultRules(), *rules)
+	if err != nil {
+		log.Fatalf("Invalid flags: %v", err)
+	}
+	sourceFormat, err := data_loader.ParseFormat(*inputFormat)
+	if err != nil {
+		log.Fatalf("Invalid flags: %v", err)
+	}
+	enc, err := data_loader.ParseEncoding(*encodingName)
+	if err != nil {
+		log.Fatalf("Invalid flags: %v", err)
+	}
+	comma, err := data_loader.ParseDelimiter(*delimiter)
+	if err != nil {
+		log.Fatalf("Invalid flags: %v", err)
+	}
+	cfg := loadConfig()
+	convention := longitudeConvention(cfg, *longitude)
+	readOpts := data_loader.Options{
+		Profile:      profile,
+		Rules:        ruleSet,
+		Longitude:    convention,
+		Format:       sourceFormat,
+		Encoding:     enc,
+		Delimiter:    comma,
+		Sheet:        *sheet,
+		HeaderOffset: *headerOffset,
+	}
+
+	// A diff only reads, so it does not migrate. Files are read with the
+	// type dictionary of the database, as a load would.
+	db := connectChecked(cfg)
+	types, err := data_loader.NewTypeDictionary(db).Names()
+	if err != nil {
+		log.Fatalf("Failed to read the type dictionary: %v", err)
+	}
+
+	fromLabel, fromCities := diffSide(db, *from, *fromFile, readOpts, types)
+	toLabel, toCities := diffSide(db, *to, *toFile, readOpts, types)
+
+	report := dataset_diff.Compare(
+		dataset_diff.FromCities(fromCities),
+		dataset_diff.FromCities(toCities),
+		dataset_diff.Options{FromLabel: fromLabel, ToLabel: toLabel, ToleranceKm: *tolerance},
+	)
+
+	w := os.Stdout
+	if *out != "-" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Failed to create output file: %v", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if *format == "json" {
+		err = report.WriteJSON(w)
+	} else {
+		err = report.WriteTable(w)
+	}
+	if err != nil {
+		log.Fatalf("Failed to write diff: %v", err)
+	}
+}
+
+// diffSide loads one side of a diff, from a file when path is set,
+// otherwise from the loaded dataset ref (label or ID, empty for the latest).
+// Files expand settlement types with types.
+func diffSide(db *gorm.DB, ref, path string, opts data_loader.Options, types map[string]string) (string, []dto.CityDTO) {
+	if path != "" {
+		src, err := data_loader.OpenSource(path)
+		if err != nil {
+			log.Fatalf("Failed to open %s: %v", path, err)
+		}
+		defer src.Close()
+
+		if opts.Format == data_loader.FormatAuto {
+			opts.Format = data_loader.DetectFormat(path)
+		}
+		cities, report, err := data_loader.ReadCities(src, opts, types)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", path, err)
+		}
+		log.Printf("Read %s: %s", path, report.Summary())
+		return path, cities
+	}
+
+	cityRepo := repo.New(db).WithLongitudeConvention(opts.Longitude)
+	dataset, err := findDataset(context.Background(), cityRepo, ref)
+	if err != nil {
+		log.Fatalf("Failed to find dataset: %v", err)
+	}
+
+	cities, err := cityRepo.WithDataset(dataset.ID).All(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to read dataset %s: %v", dataset.Label, err)
+	}
+	return dataset.Label, *cities
+}
+
+// findDataset resolves a dataset by label or ID. An empty ref selects the latest dataset.
+func findDataset(ctx context.Context, cityRepo repo.CityRepository, ref string) (*dto.DatasetDTO, error) {
+	list, err := cityRepo.Datasets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	datasets := *list
+	if len(datasets) == 0 {
+		return nil, fmt.Errorf("no datasets loaded")
+	}
+	if ref == "" {
+		return &datasets[0], nil
+	}
+
+	id, idErr := strconv.ParseUint(ref, 10, 64)
+	for i, d := range datasets {
+		if d.Label == ref || (idErr == nil && uint64(d.ID) == id) {
+			return &datasets[i], nil
+		}
+	}
+	return nil, fmt.Errorf("dataset %q not found", ref)
+}
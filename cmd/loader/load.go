@@ -0,0 +1,247 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"settlements/internal/service/data_loader"
+	"settlements/internal/service/dedup"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// runLoad loads a dataset file into the database.
+func runLoad(args []string) {
+	fs := flag.NewFlagSet("load", flag.ExitOnError)
+	filePath := fs.String("file", "datasets/dataset.csv", "Path to the dataset file: CSV, GeoJSON, NDJSON or XLSX (gzip allowed, \"-\" for stdin)")
+	sourceFormat := fs.String("format", "auto", "Format of the file: \"csv\", \"geojson\", \"ndjson\", \"xlsx\" or \"auto\" to pick it by extension")
+	sheet := fs.String("sheet", "", "Worksheet of an XLSX file to read (default: the first sheet)")
+	headerOffset := fs.Int("header-offset", 0, "Number of rows above the header of an XLSX file, such as table titles")
+	mode := fs.String("mode", string(data_loader.ModeRow), "Load mode: \"row\" inserts cities one by one, \"bulk\" writes them in batches with COPY")
+	batchSize := fs.Int("batch-size", data_loader.DefaultOptions().BatchSize, "Number of cities per COPY batch in bulk mode")
+	label := fs.String("label", "", "Dataset version to load into (default: the file name without extension)")
+	replace := fs.Bool("replace", false, "Delete the cities of the dataset the file no longer holds, with their population history (the others keep the observations of other years)")
+	maxErrorRate := fs.Float64("rollback-on-error-rate", 0, "Roll the load back when the share of failed rows exceeds this value (0..1, 0 disables)")
+	reportPath := fs.String("report", "", "Write the import report to this file (\"-\" for stdout)")
+	reportFormat := fs.String("report-format", "", "Import report format: \"json\" or \"csv\" (default: from the file extension, else json)")
+	maxFailed := fs.Int("max-failed", -1, "Exit with a non-zero code when more rows failed (-1 disables)")
+	maxSkipped := fs.Int("max-skipped", -1, "Exit with a non-zero code when more rows were skipped (-1 disables)")
+	profileName := fs.String("profile", data_loader.DefaultProfileName, "Column mapping profile: a built-in name ("+strings.Join(data_loader.ProfileNames(), ", ")+") or a path to a JSON profile")
+	rules := fs.String("rules", "", "Override rule actions as reason=action pairs, e.g. \"latitude_out_of_range=fail,unknown_type=warn\" (actions: warn, skip, fail; unknown_type=warn adds unknown abbreviations as types)")
+	longitude := fs.String("longitude-convention", "", "Store longitudes as \"signed\" [-180,180), \"positive\" [0,360) or \"center=<meridian>\"; 180 is stored as -180 when signed (default: LONGITUDE_CONVENTION, else signed)")
+	encodingName := fs.String("encoding", "auto", "Encoding of the file: \"auto\", \"utf-8\", \"cp1251\" or \"koi8-r\" (a UTF-8 BOM is always recognised)")
+	delimiter := fs.String("delimiter", "auto", "Field delimiter: a single character, \"tab\" or \"auto\" to detect it from the header")
+	workers := fs.Int("workers", 1, "Number of goroutines parsing, validating and persisting rows")
+	dedupe := fs.Bool("dedup", false, "Merge likely duplicate settlements within every district after the load")
+	dedupSimilarity := fs.Float64("dedup-similarity", dedup.DefaultMinSimilarity, "Lowest similarity of normalised names (0..1) for -dedup")
+	dedupDistance := fs.Float64("dedup-distance-km", dedup.DefaultMaxDistanceKm, "Largest distance between duplicates for -dedup, in kilometres")
+	year := fs.Int("year", 0, "Census year of the file: record the population of every city as its observation of that year (0 records none)")
+	dryRun := fs.Bool("dry-run", false, "Parse, validate and resolve the file against the database without writing, and print what the load would do")
+	fs.Parse(args)
+
+	loadMode, err := data_loader.ParseMode(*mode)
+	if err != nil {
+		log.Fatalf("Invalid flags: %v", err)
+	}
+	if *maxErrorRate < 0 || *maxErrorRate > 1 {
+		log.Fatalf("Invalid flags: -rollback-on-error-rate must be between 0 and 1")
+	}
+	if *headerOffset < 0 {
+		log.Fatalf("Invalid flags: -header-offset must not be negative")
+	}
+	if *workers < 1 {
+		log.Fatalf("Invalid flags: -workers must be at least 1")
+	}
+	if *year < 0 {
+		log.Fatalf("Invalid flags: -year must not be negative")
+	}
+	format, err := resolveReportFormat(*reportFormat, *reportPath)
+	if err != nil {
+		log.Fatalf("Invalid flags: %v", err)
+	}
+	profile, err := data_loader.LoadProfile(*profileName)
+	if err != nil {
+		log.Fatalf("Invalid flags: %v", err)
+	}
+	ruleSet, err := data_loader.ConfigureRules(data_loader.DefaultRules(), *rules)
+	if err != nil {
+		log.Fatalf("Invalid flags: %v", err)
+	}
+	inputFormat, err := data_loader.ParseFormat(*sourceFormat)
+	if err != nil {
+		log.Fatalf("Invalid flags: %v", err)
+	}
+	enc, err := data_loader.ParseEncoding(*encodingName)
+	if err != nil {
+		log.Fatalf("Invalid flags: %v", err)
+	}
+	comma, err := data_loader.ParseDelimiter(*delimiter)
+	if err != nil {
+		log.Fatalf("Invalid flags: %v", err)
+	}
+
+	var dedupOpts *dedup.Options
+	if *dedupe {
+		opts := dedupOptions(*dedupSimilarity, *dedupDistance)
+		dedupOpts = &opts
+	}
+
+	cfg := loadConfig()
+	convention := longitudeConvention(cfg, *longitude)
+	// A dry run must not write, so it does not migrate the schema either
+	var db *gorm.DB
+	if *dryRun {
+		db = connectChecked(cfg)
+	} else {
+		db = connect(cfg)
+	}
+
+	// Create data loader service
+	loader := data_loader.NewWithOptions(db, data_loader.Options{
+		Mode:         loadMode,
+		BatchSize:    *batchSize,
+		Label:        *label,
+		Replace:      *replace,
+		MaxErrorRate: *maxErrorRate,
+		Profile:      profile,
+		Rules:        ruleSet,
+		Longitude:    convention,
+		Workers:      *workers,
+		Format:       inputFormat,
+		Sheet:        *sheet,
+		HeaderOffset: *headerOffset,
+		Encoding:     enc,
+		Delimiter:    comma,
+		Dedup:        dedupOpts,
+		Year:         *year,
+		DryRun:       *dryRun,
+	})
+
+	// Load data
+	log.Printf("Loading data from %s in %s mode with the %s profile, %s longitudes, %d workers...", *filePath, loadMode, profile.Name, convention, *workers)
+	report, loadErr := loader.LoadCityData(*filePath)
+
+	if report != nil {
+		switch {
+		case report.Delimiter != "":
+			log.Printf("Read the file as %s in %s with %q delimiters", report.Format, report.Encoding, report.Delimiter)
+		case report.Encoding != "":
+			log.Printf("Read the file as %s in %s", report.Format, report.Encoding)
+		default:
+			log.Printf("Read the file as %s", report.Format)
+		}
+		log.Printf("Import report for dataset %q: %s", report.Dataset, report.Summary())
+		if report.DryRun {
+			printPreview(os.Stdout, report)
+		}
+		if report.Dedup != nil {
+			if err := report.Dedup.WriteTable(os.Stdout); err != nil {
+				log.Printf("Failed to print duplicates: %v", err)
+			}
+		}
+		if *reportPath != "" {
+			if err := writeReport(report, *reportPath, format); err != nil {
+				log.Printf("Failed to write report: %v", err)
+			}
+		}
+	}
+
+	if loadErr != nil {
+		log.Printf("Failed to load data: %v", loadErr)
+		os.Exit(exitLoadFailed)
+	}
+
+	err = report.Check(data_loader.Thresholds{MaxFailed: *maxFailed, MaxSkipped: *maxSkipped})
+	if err != nil {
+		log.Printf("Import thresholds exceeded: %v", err)
+		os.Exit(exitThresholdExceeded)
+	}
+
+	if *dryRun {
+		log.Println("Dry run finished, nothing was written")
+		return
+	}
+	log.Println("Data loaded successfully!")
+}
+
+// maxPreviewIssues is the number of rejected rows a dry run prints.
+const maxPreviewIssues = 20
+
+// printPreview prints what a dry run found the load would do.
+func printPreview(w io.Writer, report *data_loader.ImportReport) {
+	preview := report.Preview
+
+	dataset := "existing"
+	if preview.NewDataset {
+		dataset = "new"
+	}
+	fmt.Fprintf(w, "Dataset %q (%s)\n", report.Dataset, dataset)
+	if preview.Replaced > 0 {
+		fmt.Fprintf(w, "Cities deleted as no longer in the file: %d\n", preview.Replaced)
+	}
+	fmt.Fprintf(w, "Cities: %d created, %d updated, %d unchanged, %d rows merged into federal cities\n",
+		report.Created, report.Updated, report.Unchanged, report.Merged)
+	fmt.Fprintf(w, "Rows rejected: %d skipped, %d failed, %d loaded with warnings\n",
+		report.Skipped, report.Failed, report.Warnings)
+
+	printNames(w, "New districts", preview.NewDistricts)
+	printNames(w, "New types", preview.NewTypes)
+	printNames(w, "New admin units", preview.NewAdminUnits)
+
+	shown := 0
+	for _, issue := range report.Issues {
+		if issue.Status == data_loader.StatusWarning {
+			continue
+		}
+		if shown == maxPreviewIssues {
+			fmt.Fprintf(w, "  ... see -report for the rest\n")
+			break
+		}
+		fmt.Fprintf(w, "  line %d: %s (%s): %s\n", issue.Line, issue.Status, issue.Reason, issue.Message)
+		shown++
+	}
+}
+
+func printNames(w io.Writer, title string, names []string) {
+	fmt.Fprintf(w, "%s: %d\n", title, len(names))
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\n", name)
+	}
+}
+
+// resolveReportFormat picks the report format from the flag or the file extension.
+func resolveReportFormat(format, path string) (string, error) {
+	if format == "" {
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			return "csv", nil
+		}
+		return "json", nil
+	}
+
+	switch format {
+	case "json", "csv":
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown report format %q", format)
+}
+
+func writeReport(report *data_loader.ImportReport, path, format string) error {
+	out := os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if format == "csv" {
+		return report.WriteCSV(out)
+	}
+	return report.WriteJSON(out)
+}
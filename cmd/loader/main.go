@@ -1,38 +1,105 @@
 package main
 
 import (
-	"flag"
 	"log"
+	"os"
 	"settlements/internal/config"
 	"settlements/internal/db"
-	"settlements/internal/service/data_loader"
+	"settlements/internal/db/migrations"
+	"settlements/internal/geo"
+
+	"gorm.io/gorm"
+)
+
+// Exit codes of the loader.
+const (
+	exitLoadFailed        = 1
+	exitThresholdExceeded = 2
 )
 
+// Usage:
+//
+//	loader [load] [flags]   load a dataset file (the default command)
+//	loader diff [flags]     compare two datasets or a file with a dataset
+//	loader types <command>  list, add, alias or merge settlement types
+//	loader dedup [flags]    find and merge likely duplicate settlements
+//	loader migrate          migrate the database schema
 func main() {
-	filePath := flag.String("file", "datasets/dataset.csv", "Path to the dataset CSV file")
-	flag.Parse()
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "load":
+			runLoad(args[1:])
+			return
+		case "diff":
+			runDiff(args[1:])
+			return
+		case "types":
+			runTypes(args[1:])
+			return
+		case "dedup":
+			runDedup(args[1:])
+			return
+		case "migrate":
+			connect(loadConfig())
+			log.Println("Database migrated successfully!")
+			return
+		}
+	}
+
+	runLoad(args)
+}
 
-	// Load configuration
+// loadConfig loads the configuration from the environment.
+func loadConfig() *config.Config {
 	cfg, err := config.Load()
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	return cfg
+}
+
+// longitudeConvention returns the convention named by the flag value,
+// or the configured one when the value is empty.
+func longitudeConvention(cfg *config.Config, value string) geo.LongitudeConvention {
+	if value == "" {
+		return cfg.Geo.LongitudeConvention
+	}
+
+	convention, err := geo.ParseLongitudeConvention(value)
+	if err != nil {
+		log.Fatalf("Invalid flags: %v", err)
+	}
+	return convention
+}
 
+// connect connects to the database and migrates it.
+func connect(cfg *config.Config) *gorm.DB {
 	// Connect to database
 	db, err := db.Connect(&cfg.Database)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
-	// Create data loader service
-	loader := data_loader.New(db)
+	if err := migrations.Migrate(db); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	return db
+}
 
-	// Load data
-	log.Printf("Loading data from %s...", *filePath)
-	err = loader.LoadCityData(*filePath)
+// connectChecked connects to the database without migrating it, for commands
+// that must not write. It exits when the schema is behind the one Migrate
+// creates.
+func connectChecked(cfg *config.Config) *gorm.DB {
+	db, err := db.Connect(&cfg.Database)
 	if err != nil {
-		log.Fatalf("Failed to load data: %v", err)
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	if err := migrations.Check(db); err != nil {
+		log.Fatalf("Failed to check database: %v", err)
 	}
 
-	log.Println("Data loaded successfully!")
+	return db
 }
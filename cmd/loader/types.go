@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"settlements/internal/service/data_loader"
+)
+
+// runTypes manages the settlement type dictionary:
+//
+//	loader types list
+//	loader types add <abbreviation> <name>
+//	loader types alias <alias> <abbreviation or name>
+//	loader types merge <from> <into>
+func runTypes(args []string) {
+	fs := flag.NewFlagSet("types", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: loader types list | add <abbreviation> <name> | alias <alias> <abbreviation or name> | merge <from> <into>")
+	}
+	fs.Parse(args)
+
+	command, params := "list", []string{}
+	if fs.NArg() > 0 {
+		command, params = fs.Arg(0), fs.Args()[1:]
+	}
+
+	wantParams := map[string]int{"list": 0, "add": 2, "alias": 2, "merge": 2}
+	if n, ok := wantParams[command]; !ok || len(params) != n {
+		fs.Usage()
+		os.Exit(exitLoadFailed)
+	}
+
+	// Listing only reads: it neither migrates nor seeds the dictionary
+	cfg := loadConfig()
+	var dictionary *data_loader.TypeDictionary
+	if command == "list" {
+		dictionary = data_loader.NewTypeDictionary(connectChecked(cfg))
+	} else {
+		dictionary = data_loader.NewTypeDictionary(connect(cfg))
+		if err := dictionary.Seed(); err != nil {
+			log.Fatalf("Failed to seed type dictionary: %v", err)
+		}
+	}
+
+	switch command {
+	case "list":
+		types, err := dictionary.List()
+		if err != nil {
+			log.Fatalf("Failed to list types: %v", err)
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tABBREVIATIONS\tCITIES")
+		for _, t := range types {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%d\n", t.ID, t.Name, strings.Join(t.Abbreviations, ", "), t.Cities)
+		}
+		tw.Flush()
+
+	case "add":
+		if err := dictionary.Add(params[0], params[1]); err != nil {
+			log.Fatalf("Failed to add type: %v", err)
+		}
+		log.Printf("Abbreviation %q added to type %q", params[0], params[1])
+
+	case "alias":
+		if err := dictionary.Alias(params[0], params[1]); err != nil {
+			log.Fatalf("Failed to add alias: %v", err)
+		}
+		log.Printf("Alias %q added to type %q", params[0], params[1])
+
+	case "merge":
+		res, err := dictionary.Merge(params[0], params[1])
+		if err != nil {
+			log.Fatalf("Failed to merge types: %v", err)
+		}
+		log.Printf("Type %q merged into %q: %d cities moved, %d duplicates merged and deleted", params[0], params[1], res.Moved, res.Merged)
+	}
+}
@@ -6,12 +6,15 @@ import (
 	"os"
 	"strconv"
 
+	"settlements/internal/geo"
+
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
 	Server   ServerConfig
 	Database DatabaseConfig
+	Geo      GeoConfig
 }
 
 type ServerConfig struct {
@@ -26,6 +29,11 @@ type DatabaseConfig struct {
 	Name     string
 }
 
+type GeoConfig struct {
+	// LongitudeConvention is used both when loading and when querying longitudes
+	LongitudeConvention geo.LongitudeConvention
+}
+
 // Load reads configuration from environment variables
 func Load() (*Config, error) {
 	if err := godotenv.Load(); err != nil {
@@ -37,6 +45,11 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("invalid DB_PORT: %w", err)
 	}
 
+	longitudeConvention, err := geo.ParseLongitudeConvention(getEnv("LONGITUDE_CONVENTION", "signed"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LONGITUDE_CONVENTION: %w", err)
+	}
+
 	cfg := &Config{
 		Server: ServerConfig{
 			Port: getEnv("PORT", "3000"),
@@ -48,6 +61,9 @@ func Load() (*Config, error) {
 			Password: getEnv("DB_PASSWORD", "postgres"),
 			Name:     getEnv("DB_NAME", "database"),
 		},
+		Geo: GeoConfig{
+			LongitudeConvention: longitudeConvention,
+		},
 	}
 
 	return cfg, nil
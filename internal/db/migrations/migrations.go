@@ -1,12 +1,296 @@
 package migrations
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"settlements/internal/geo"
 	"settlements/internal/models"
 
 	"gorm.io/gorm"
 )
 
+// legacyDatasetLabel is the dataset cities loaded before versioning are moved to.
+const legacyDatasetLabel = "legacy"
+
+// ErrSchemaOutOfDate is returned by Check when the database needs Migrate.
+var ErrSchemaOutOfDate = errors.New("schema out of date, run a load or migrate first")
+
+// schemaModels are the models whose tables Migrate creates, as Check expects them.
+var schemaModels = []any{
+	&models.SchemaMigration{}, &models.Dataset{}, &models.Type{}, &models.TypeAbbreviation{},
+	&models.District{}, &models.AdminUnit{}, &models.City{}, &models.PopulationObservation{},
+}
+
+// oneOffMigrations are the data migrations Migrate records as applied.
+var oneOffMigrations = []string{legacyLongitudesMigration}
+
 func Migrate(db *gorm.DB) error {
-	err := db.AutoMigrate(&models.Type{}, &models.District{}, &models.City{})
-	return err
+	if err := prepareCityNaturalKey(db); err != nil {
+		return err
+	}
+
+	if err := db.AutoMigrate(&models.SchemaMigration{}, &models.Dataset{}); err != nil {
+		return err
+	}
+
+	if err := prepareCityDataset(db); err != nil {
+		return err
+	}
+
+	if err := db.AutoMigrate(&models.Type{}, &models.TypeAbbreviation{}, &models.District{}, &models.AdminUnit{}, &models.City{}, &models.PopulationObservation{}); err != nil {
+		return err
+	}
+
+	if err := runOnce(db, legacyLongitudesMigration, fixLegacyLongitudes); err != nil {
+		return err
+	}
+
+	return prepareAdminUnits(db)
+}
+
+// Check reports whether the database has the schema Migrate leaves behind,
+// without changing it. It returns an error wrapping ErrSchemaOutOfDate when
+// a table, column, index or one-off migration is missing.
+func Check(db *gorm.DB) error {
+	m := db.Migrator()
+	for _, model := range schemaModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return fmt.Errorf("failed to parse model: %w", err)
+		}
+		table := stmt.Schema.Table
+
+		if !m.HasTable(model) {
+			return fmt.Errorf("%w: table %s is missing", ErrSchemaOutOfDate, table)
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !m.HasColumn(model, field.DBName) {
+				return fmt.Errorf("%w: column %s.%s is missing", ErrSchemaOutOfDate, table, field.DBName)
+			}
+		}
+	}
+
+	for _, index := range []string{"idx_admin_units_root_name", "idx_admin_units_child_name"} {
+		if !m.HasIndex(&models.AdminUnit{}, index) {
+			return fmt.Errorf("%w: index %s is missing", ErrSchemaOutOfDate, index)
+		}
+	}
+
+	var applied []string
+	if err := db.Model(&models.SchemaMigration{}).Where("name IN ?", oneOffMigrations).Pluck("name", &applied).Error; err != nil {
+		return fmt.Errorf("failed to check migrations: %w", err)
+	}
+	for _, name := range oneOffMigrations {
+		if !slices.Contains(applied, name) {
+			return fmt.Errorf("%w: migration %s has not run", ErrSchemaOutOfDate, name)
+		}
+	}
+	return nil
+}
+
+// prepareCityNaturalKey backfills the coordinate keys of cities loaded before
+// the natural key existed and removes the duplicates repeated loads created,
+// so the unique index can be built. It keeps the oldest row of each key.
+// Only exact copies are removed: when the rows of a key disagree on the
+// population, the migration stops and lists the keys to be resolved by hand.
+func prepareCityNaturalKey(db *gorm.DB) error {
+	m := db.Migrator()
+	if !m.HasTable(&models.City{}) || m.HasColumn(&models.City{}, "LatitudeKey") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			"ALTER TABLE cities ADD COLUMN latitude_key bigint NOT NULL DEFAULT 0",
+			"ALTER TABLE cities ADD COLUMN longitude_key bigint NOT NULL DEFAULT 0",
+			fmt.Sprintf("UPDATE cities SET latitude_key = round(latitude * %[1]g), longitude_key = round(longitude * %[1]g)",
+				models.CoordinateKeyScale),
+		}
+
+		for _, stmt := range statements {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return fmt.Errorf("failed to prepare city natural key: %w", err)
+			}
+		}
+
+		if err := checkNaturalKeyConflicts(tx); err != nil {
+			return err
+		}
+
+		res := tx.Exec(`DELETE FROM cities a USING cities b
+			WHERE a.id > b.id
+				AND a.district_id = b.district_id
+				AND a.name = b.name
+				AND a.type_id = b.type_id
+				AND a.latitude_key = b.latitude_key
+				AND a.longitude_key = b.longitude_key
+				AND a.population = b.population
+				AND a.childrens = b.childrens`)
+		if res.Error != nil {
+			return fmt.Errorf("failed to prepare city natural key: %w", res.Error)
+		}
+		if res.RowsAffected > 0 {
+			log.Printf("Removed %d duplicate cities left by repeated loads", res.RowsAffected)
+		}
+		return nil
+	})
+}
+
+// maxReportedConflicts is the number of conflicting keys an error lists.
+const maxReportedConflicts = 20
+
+// naturalKeyConflict is a natural key held by cities with different numbers.
+type naturalKeyConflict struct {
+	Name       string
+	DistrictID uint
+	TypeID     uint
+	Latitude   float64
+	Longitude  float64
+	Cities     int
+}
+
+// checkNaturalKeyConflicts fails when cities sharing a natural key disagree
+// on their population or childrens, as removing either row would lose data.
+func checkNaturalKeyConflicts(tx *gorm.DB) error {
+	var conflicts []naturalKeyConflict
+	err := tx.Raw(`SELECT name, district_id, type_id, min(latitude) AS latitude, min(longitude) AS longitude, count(*) AS cities
+		FROM cities
+		GROUP BY name, district_id, type_id, latitude_key, longitude_key
+		HAVING count(DISTINCT population) > 1 OR count(DISTINCT childrens) > 1
+		ORDER BY name, district_id, type_id`).Scan(&conflicts).Error
+	if err != nil {
+		return fmt.Errorf("failed to find duplicate cities: %w", err)
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	shown := conflicts[:min(len(conflicts), maxReportedConflicts)]
+	keys := make([]string, 0, len(shown)+1)
+	for _, c := range shown {
+		keys = append(keys, fmt.Sprintf("%q (district %d, type %d, %g %g): %d cities",
+			c.Name, c.DistrictID, c.TypeID, c.Latitude, c.Longitude, c.Cities))
+	}
+	if len(conflicts) > len(shown) {
+		keys = append(keys, fmt.Sprintf("and %d more", len(conflicts)-len(shown)))
+	}
+	return fmt.Errorf("failed to prepare city natural key: %d keys are held by cities with different population or childrens, merge or delete them first: %s",
+		len(conflicts), strings.Join(keys, "; "))
+}
+
+// prepareCityDataset moves cities loaded before datasets existed into a
+// legacy dataset and replaces the natural key index with one scoped by dataset.
+func prepareCityDataset(db *gorm.DB) error {
+	m := db.Migrator()
+	if !m.HasTable(&models.City{}) || m.HasColumn(&models.City{}, "DatasetID") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		legacy := models.Dataset{Label: legacyDatasetLabel, SourceFile: "", LoadedAt: time.Now()}
+		if err := tx.Where("label = ?", legacy.Label).FirstOrCreate(&legacy).Error; err != nil {
+			return fmt.Errorf("failed to create legacy dataset: %w", err)
+		}
+
+		statements := []string{
+			fmt.Sprintf("ALTER TABLE cities ADD COLUMN dataset_id bigint NOT NULL DEFAULT %d", legacy.ID),
+			"ALTER TABLE cities ALTER COLUMN dataset_id DROP DEFAULT",
+			"DROP INDEX IF EXISTS idx_cities_natural_key",
+		}
+
+		for _, stmt := range statements {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return fmt.Errorf("failed to move cities to the legacy dataset: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// legacyLongitudesMigration names the one-off repair of legacy longitudes.
+const legacyLongitudesMigration = "fix_legacy_longitudes"
+
+// runOnce runs a one-off data migration in a transaction and records it, or
+// does nothing when it has been recorded before.
+func runOnce(db *gorm.DB, name string, migrate func(tx *gorm.DB) error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var applied int64
+		if err := tx.Model(&models.SchemaMigration{}).Where("name = ?", name).Count(&applied).Error; err != nil {
+			return fmt.Errorf("failed to check migration %s: %w", name, err)
+		}
+		if applied > 0 {
+			return nil
+		}
+
+		if err := migrate(tx); err != nil {
+			return err
+		}
+		if err := tx.Create(&models.SchemaMigration{Name: name, AppliedAt: time.Now()}).Error; err != nil {
+			return fmt.Errorf("failed to record migration %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// fixLegacyLongitudes repairs datasets loaded before longitude conventions
+// existed. The old loader stored a negative longitude as 180 - longitude,
+// so every stored value above 180 is turned back into the original one.
+// The datasets are then marked as written in the signed convention.
+// It runs once, through runOnce.
+func fixLegacyLongitudes(tx *gorm.DB) error {
+	res := tx.Exec(fmt.Sprintf(`UPDATE cities SET longitude = 180 - longitude, longitude_key = round(%s * %g)
+		WHERE longitude > 180
+			AND dataset_id IN (SELECT id FROM datasets WHERE longitude_convention = '')`,
+		geo.Signed.SQL("(180 - longitude)"), models.CoordinateKeyScale))
+	if res.Error != nil {
+		return fmt.Errorf("failed to fix legacy longitudes: %w", res.Error)
+	}
+	cities := res.RowsAffected
+
+	res = tx.Exec(fmt.Sprintf("UPDATE datasets SET longitude_convention = '%s' WHERE longitude_convention = ''",
+		geo.Signed))
+	if res.Error != nil {
+		return fmt.Errorf("failed to fix legacy longitudes: %w", res.Error)
+	}
+
+	log.Printf("Fixed the longitudes of %d cities in %d legacy datasets", cities, res.RowsAffected)
+	return nil
+}
+
+// prepareAdminUnits creates the unique indexes of the administrative
+// hierarchy and links cities loaded before it existed to the subject of
+// their district. Subjects have no parent, so their names get an index of
+// their own: NULL parents would never conflict in a shared one.
+func prepareAdminUnits(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_admin_units_root_name
+			ON admin_units (level, name) WHERE parent_id IS NULL`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_admin_units_child_name
+			ON admin_units (parent_id, level, name) WHERE parent_id IS NOT NULL`,
+			fmt.Sprintf(`INSERT INTO admin_units (level, name)
+			SELECT DISTINCT '%[1]s', districts.name FROM cities JOIN districts ON districts.id = cities.district_id
+			WHERE cities.admin_unit_id IS NULL
+			ON CONFLICT (level, name) WHERE parent_id IS NULL DO NOTHING`, models.AdminLevelSubject),
+			fmt.Sprintf(`UPDATE cities SET admin_unit_id = admin_units.id
+			FROM districts, admin_units
+			WHERE cities.admin_unit_id IS NULL
+				AND districts.id = cities.district_id
+				AND admin_units.parent_id IS NULL
+				AND admin_units.level = '%[1]s'
+				AND admin_units.name = districts.name`, models.AdminLevelSubject),
+		}
+
+		for _, stmt := range statements {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return fmt.Errorf("failed to prepare admin units: %w", err)
+			}
+		}
+		return nil
+	})
 }
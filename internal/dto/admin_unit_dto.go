@@ -0,0 +1,13 @@
+package dto
+
+// AdminUnitDTO is an administrative unit with the totals of the cities in it
+// and in the units below it.
+type AdminUnitDTO struct {
+	ID         uint
+	ParentID   *uint
+	Level      string
+	Name       string
+	Cities     int64
+	Population int64
+	Childrens  int64
+}
@@ -0,0 +1,19 @@
+package dto
+
+// TypeStatsDTO sums up the cities of one settlement type.
+type TypeStatsDTO struct {
+	Type          string
+	Cities        int64
+	AvgPopulation float64
+	AvgChildrens  float64
+	MinPopulation int
+	MaxPopulation int
+}
+
+// DistrictTotalDTO is the number of cities of a district and their totals.
+type DistrictTotalDTO struct {
+	District   string
+	Cities     int64
+	Population int64
+	Childrens  int64
+}
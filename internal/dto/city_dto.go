@@ -9,4 +9,15 @@ type CityDTO struct {
 	Childrens  int
 	Latitude   float64
 	Longitude  float64
+	// OKTMO and OKATO are the classifier codes of the city, empty when unknown.
+	OKTMO string
+	OKATO string
+}
+
+// CityPageDTO is a page of cities with the number of cities on all pages.
+// NextCursor continues with the next page, empty on the last one.
+type CityPageDTO struct {
+	Cities     []CityDTO
+	Total      int64
+	NextCursor string
 }
@@ -0,0 +1,12 @@
+package dto
+
+// CodeMismatchDTO is a city whose classifier code belongs to another
+// federal subject than the code of its district.
+type CodeMismatchDTO struct {
+	CityID       uint
+	City         string
+	District     string
+	Scheme       string
+	CityCode     string
+	DistrictCode string
+}
@@ -0,0 +1,10 @@
+package dto
+
+import "time"
+
+type DatasetDTO struct {
+	ID         uint
+	Label      string
+	SourceFile string
+	LoadedAt   time.Time
+}
@@ -0,0 +1,39 @@
+package dto
+
+// HistogramBucketDTO is one of equally wide ranges [Start, End) of a numeric
+// city column with the number of cities in it and their totals. The last
+// bucket also holds the cities at End, the largest value.
+type HistogramBucketDTO struct {
+	Bucket     int
+	Start      float64
+	End        float64
+	Cities     int64
+	Population int64
+	Childrens  int64
+}
+
+// HistogramBucket returns the bucket of value when [lo, hi] is split into a
+// number of equally wide buckets, given by buckets. Buckets are numbered as
+// PostgreSQL width_bucket numbers them, but from zero. The largest value falls into the last bucket, and every value into
+// the first when the range is empty.
+func HistogramBucket(value, lo, hi float64, buckets int) int {
+	if hi <= lo {
+		return 0
+	}
+
+	bucket := int((value - lo) / (hi - lo) * float64(buckets))
+	return max(0, min(bucket, buckets-1))
+}
+
+// HistogramBuckets returns buckets empty buckets over [lo, hi], numbered
+// from zero, to be filled with the cities of each.
+func HistogramBuckets(lo, hi float64, buckets int) []HistogramBucketDTO {
+	step := (hi - lo) / float64(buckets)
+
+	res := make([]HistogramBucketDTO, buckets)
+	for i := range res {
+		res[i] = HistogramBucketDTO{Bucket: i, Start: lo + float64(i)*step, End: lo + float64(i+1)*step}
+	}
+	res[buckets-1].End = hi
+	return res
+}
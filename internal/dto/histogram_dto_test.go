@@ -0,0 +1,37 @@
+package dto
+
+import "testing"
+
+func TestHistogramBucket(t *testing.T) {
+	tests := []struct {
+		value, lo, hi float64
+		want          int
+	}{
+		{30, 30, 40, 0},
+		{34.9, 30, 40, 4},
+		{35, 30, 40, 5},
+		{40, 30, 40, 9},
+		{25, 30, 40, 0},
+		{30, 30, 30, 0},
+	}
+
+	for _, tt := range tests {
+		if got := HistogramBucket(tt.value, tt.lo, tt.hi, 10); got != tt.want {
+			t.Errorf("HistogramBucket(%g, %g, %g, 10) = %d, want %d", tt.value, tt.lo, tt.hi, got, tt.want)
+		}
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	buckets := HistogramBuckets(30, 40, 4)
+
+	if len(buckets) != 4 {
+		t.Fatalf("Expected 4 buckets, got %d", len(buckets))
+	}
+	if buckets[1].Bucket != 1 || buckets[1].Start != 32.5 || buckets[1].End != 35 {
+		t.Errorf("Unexpected second bucket %+v", buckets[1])
+	}
+	if buckets[3].End != 40 {
+		t.Errorf("Expected the last bucket to end at 40, got %g", buckets[3].End)
+	}
+}
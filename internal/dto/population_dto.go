@@ -0,0 +1,57 @@
+package dto
+
+import "math"
+
+// PopulationObservationDTO is the population of a city counted in one year.
+type PopulationObservationDTO struct {
+	CityID     uint
+	Year       int
+	Population int
+	Childrens  int
+	Source     string
+}
+
+// GrowthRateDTO is the change in population of a city between two census
+// years. Rate is the relative change over the whole period and AnnualRate
+// the compound rate per year, both as fractions: 0.05 is 5% growth.
+type GrowthRateDTO struct {
+	CityID         uint
+	Name           string
+	Type           string
+	District       string
+	FromYear       int
+	ToYear         int
+	FromPopulation int
+	ToPopulation   int
+	Rate           float64
+	AnnualRate     float64
+}
+
+// DistrictGrowthRateDTO is the change in population of the cities of a
+// district observed in both census years, with rates as in GrowthRateDTO.
+type DistrictGrowthRateDTO struct {
+	District       string
+	Cities         int64
+	FromYear       int
+	ToYear         int
+	FromPopulation int64
+	ToPopulation   int64
+	Rate           float64
+	AnnualRate     float64
+}
+
+// GrowthRates returns the relative change from population from to to and
+// the compound rate per year over years. A period of zero years has no
+// annual rate; a start of zero has no rate at all.
+func GrowthRates(from, to int64, years int) (rate, annual float64) {
+	if from <= 0 {
+		return 0, 0
+	}
+
+	ratio := float64(to) / float64(from)
+	rate = ratio - 1
+	if years != 0 {
+		annual = math.Pow(ratio, 1/float64(years)) - 1
+	}
+	return rate, annual
+}
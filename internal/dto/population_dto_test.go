@@ -0,0 +1,30 @@
+package dto
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGrowthRates(t *testing.T) {
+	rate, annual := GrowthRates(1000, 1210, 2)
+	if math.Abs(rate-0.21) > 1e-9 {
+		t.Errorf("Expected rate 0.21, got %f", rate)
+	}
+	if math.Abs(annual-0.1) > 1e-9 {
+		t.Errorf("Expected annual rate 0.1, got %f", annual)
+	}
+
+	rate, annual = GrowthRates(1000, 810, 2)
+	if math.Abs(rate+0.19) > 1e-9 || math.Abs(annual+0.1) > 1e-9 {
+		t.Errorf("Expected a decline of 0.19 and 0.1 a year, got %f/%f", rate, annual)
+	}
+}
+
+func TestGrowthRatesDegenerate(t *testing.T) {
+	if rate, annual := GrowthRates(0, 100, 10); rate != 0 || annual != 0 {
+		t.Errorf("Expected no rates from zero population, got %f/%f", rate, annual)
+	}
+	if rate, annual := GrowthRates(100, 150, 0); rate != 0.5 || annual != 0 {
+		t.Errorf("Expected no annual rate over zero years, got %f/%f", rate, annual)
+	}
+}
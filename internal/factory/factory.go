@@ -19,7 +19,7 @@ import (
 type ApplicationFactory struct {
 	config *config.Config
 	db     *gorm.DB
-	repo   *repo.CityRepo
+	repo   repo.CityRepository
 }
 
 // NewApplicationFactory creates a new ApplicationFactory with loaded configuration
@@ -40,6 +40,23 @@ func NewApplicationFactory(cfg *config.Config) (*ApplicationFactory, error) {
 	}, nil
 }
 
+// NewApplicationFactoryWithRepository creates an ApplicationFactory that serves
+// the given repository instead of connecting to the database, such as an
+// in-memory repository for tests and demos
+func NewApplicationFactoryWithRepository(cfg *config.Config, repository repo.CityRepository) (*ApplicationFactory, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	if repository == nil {
+		return nil, fmt.Errorf("repository cannot be nil")
+	}
+
+	return &ApplicationFactory{
+		config: cfg,
+		repo:   repository,
+	}, nil
+}
+
 // CreateRouter creates and returns a new Router instance
 // Satisfies the router creation contract
 func (f *ApplicationFactory) CreateRouter() *router.Router {
@@ -48,7 +65,7 @@ func (f *ApplicationFactory) CreateRouter() *router.Router {
 
 // CreateRepository creates and returns a new CityRepository instance
 // Lazy initialization pattern: repository is created once and cached
-func (f *ApplicationFactory) CreateRepository() (*repo.CityRepo, error) {
+func (f *ApplicationFactory) CreateRepository() (repo.CityRepository, error) {
 	if f.repo != nil {
 		return f.repo, nil
 	}
@@ -57,7 +74,7 @@ func (f *ApplicationFactory) CreateRepository() (*repo.CityRepo, error) {
 		return nil, fmt.Errorf("database connection not initialized")
 	}
 
-	f.repo = repo.New(f.db)
+	f.repo = repo.New(f.db).WithLongitudeConvention(f.config.Geo.LongitudeConvention)
 	return f.repo, nil
 }
 
@@ -124,6 +141,11 @@ func (b *ApplicationBootstrapper) InitializeApplication() (*ApplicationContext,
 
 	// Register routes
 	router.GET("/", controller.GetMainPage)
+	router.GET("/api/cities", controller.GetCities)
+	router.GET("/api/cities/:scheme/:code", controller.GetCitiesByCode)
+	router.GET("/api/code-mismatches", controller.GetCodeMismatches)
+	router.GET("/api/population/:id", controller.GetPopulationHistory)
+	router.GET("/api/growth", controller.GetGrowthRates)
 	log.Println("Routes registered")
 
 	return &ApplicationContext{
@@ -0,0 +1,25 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDistanceKm(t *testing.T) {
+	tests := []struct {
+		name                   string
+		lat1, lon1, lat2, lon2 float64
+		expected               float64
+	}{
+		{"same point", 55.7558, 37.6173, 55.7558, 37.6173, 0},
+		{"Moscow - Saint Petersburg", 55.7558, 37.6173, 59.9386, 30.3141, 634},
+		{"across the antimeridian", 65, 179.9, 65, -179.9, 9.4},
+	}
+
+	for _, test := range tests {
+		got := DistanceKm(test.lat1, test.lon1, test.lat2, test.lon2)
+		if math.Abs(got-test.expected) > 1 {
+			t.Errorf("%s: expected about %.1f km, got %.1f km", test.name, test.expected, got)
+		}
+	}
+}
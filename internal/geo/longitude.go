@@ -0,0 +1,76 @@
+package geo
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// LongitudeConvention is a way of writing longitudes: every longitude is
+// mapped into the 360° range [Center-180, Center+180). Picking the centre
+// decides where a map is cut, so regions crossing the antimeridian, such as
+// Chukotka, stay contiguous with a centre away from it.
+// The zero value is the usual [-180, 180) convention.
+type LongitudeConvention struct {
+	Center float64
+}
+
+var (
+	// Signed writes longitudes in [-180, 180), so 180 becomes -180.
+	Signed = LongitudeConvention{Center: 0}
+	// Positive writes longitudes in [0, 360).
+	Positive = LongitudeConvention{Center: 180}
+)
+
+// centerPrefix introduces a custom central meridian in a convention name.
+const centerPrefix = "center="
+
+// ParseLongitudeConvention converts a configuration value into a convention:
+// "signed" for [-180, 180), "positive" for [0, 360) or "center=<meridian>"
+// for the range centred on that meridian. An empty value is Signed.
+func ParseLongitudeConvention(s string) (LongitudeConvention, error) {
+	switch s {
+	case "", "signed":
+		return Signed, nil
+	case "positive":
+		return Positive, nil
+	}
+
+	if v, ok := strings.CutPrefix(s, centerPrefix); ok {
+		center, err := strconv.ParseFloat(v, 64)
+		if err == nil && center >= -180 && center <= 360 {
+			return LongitudeConvention{Center: center}, nil
+		}
+	}
+	return LongitudeConvention{}, fmt.Errorf(
+		"unknown longitude convention %q (want \"signed\", \"positive\" or \"center=<meridian>\")", s)
+}
+
+// String returns the name ParseLongitudeConvention accepts for c.
+func (c LongitudeConvention) String() string {
+	switch c {
+	case Signed:
+		return "signed"
+	case Positive:
+		return "positive"
+	}
+	return centerPrefix + strconv.FormatFloat(c.Center, 'g', -1, 64)
+}
+
+// Min returns the lowest longitude of the convention.
+func (c LongitudeConvention) Min() float64 {
+	return c.Center - 180
+}
+
+// Normalize maps a longitude written in any convention into c.
+func (c LongitudeConvention) Normalize(longitude float64) float64 {
+	return longitude - 360*math.Floor((longitude-c.Min())/360)
+}
+
+// SQL returns a PostgreSQL expression that normalizes column the same way
+// Normalize does, so queries can filter and order on normalized longitudes.
+func (c LongitudeConvention) SQL(column string) string {
+	min := strconv.FormatFloat(c.Min(), 'g', -1, 64)
+	return fmt.Sprintf("(%[1]s - 360 * floor((%[1]s - (%[2]s)) / 360))", column, min)
+}
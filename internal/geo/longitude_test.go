@@ -0,0 +1,92 @@
+package geo
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestLongitudeConventionNormalize(t *testing.T) {
+	tests := []struct {
+		convention LongitudeConvention
+		longitude  float64
+		expected   float64
+	}{
+		{Signed, 37.6, 37.6},
+		{Signed, -169.5, -169.5},
+		{Signed, 190.5, -169.5},
+		{Signed, 180, -180},
+		{Positive, -169.5, 190.5},
+		{Positive, 37.6, 37.6},
+		{Positive, 360, 0},
+		{LongitudeConvention{Center: 100}, -169.5, 190.5},
+		{LongitudeConvention{Center: 100}, -100, 260},
+		{LongitudeConvention{Center: 100}, 290, -70},
+	}
+
+	for _, test := range tests {
+		got := test.convention.Normalize(test.longitude)
+		if math.Abs(got-test.expected) > 1e-9 {
+			t.Errorf("%s: Normalize(%g): expected %g, got %g", test.convention, test.longitude, test.expected, got)
+		}
+	}
+}
+
+func TestNormalizeIsIdempotent(t *testing.T) {
+	for _, c := range []LongitudeConvention{Signed, Positive, {Center: 100}} {
+		for lon := -540.0; lon <= 540; lon += 17.5 {
+			once := c.Normalize(lon)
+			if once < c.Min() || once >= c.Min()+360 {
+				t.Errorf("%s: Normalize(%g) = %g is out of range", c, lon, once)
+			}
+			if twice := c.Normalize(once); twice != once {
+				t.Errorf("%s: Normalize is not idempotent for %g: %g then %g", c, lon, once, twice)
+			}
+		}
+	}
+}
+
+func TestParseLongitudeConvention(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected LongitudeConvention
+	}{
+		{"", Signed},
+		{"signed", Signed},
+		{"positive", Positive},
+		{"center=100", LongitudeConvention{Center: 100}},
+		{"center=-30.5", LongitudeConvention{Center: -30.5}},
+	}
+
+	for _, test := range tests {
+		got, err := ParseLongitudeConvention(test.value)
+		if err != nil {
+			t.Errorf("%q: expected no error, got %v", test.value, err)
+		} else if got != test.expected {
+			t.Errorf("%q: expected %v, got %v", test.value, test.expected, got)
+		}
+	}
+
+	for _, value := range []string{"east", "center=", "center=abc", "center=400"} {
+		if _, err := ParseLongitudeConvention(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
+
+func TestLongitudeConventionString(t *testing.T) {
+	for _, c := range []LongitudeConvention{Signed, Positive, {Center: 100}, {Center: -30.5}} {
+		parsed, err := ParseLongitudeConvention(c.String())
+		if err != nil || parsed != c {
+			t.Errorf("Expected %q to parse back to %v, got %v (%v)", c.String(), c, parsed, err)
+		}
+	}
+}
+
+func TestLongitudeConventionSQL(t *testing.T) {
+	got := LongitudeConvention{Center: 100}.SQL("cities.longitude")
+
+	if !strings.Contains(got, "floor((cities.longitude - (-80)) / 360)") {
+		t.Errorf("Unexpected SQL expression: %s", got)
+	}
+}
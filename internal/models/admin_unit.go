@@ -0,0 +1,25 @@
+package models
+
+// AdminUnitLevel is the level of an administrative unit in the hierarchy.
+type AdminUnitLevel string
+
+const (
+	// AdminLevelSubject is a federal subject, the root of the hierarchy.
+	AdminLevelSubject AdminUnitLevel = "subject"
+	// AdminLevelMunicipality is a municipal district or okrug of a subject.
+	AdminLevelMunicipality AdminUnitLevel = "municipality"
+)
+
+// AdminUnit is a node of the administrative hierarchy
+// subject -> municipal district/okrug -> settlement. Subjects have no parent;
+// settlements are the cities linked to the lowest unit they belong to.
+// Names are unique among the units of one level with the same parent.
+type AdminUnit struct {
+	ID       uint           `gorm:"primaryKey"`
+	ParentID *uint          `gorm:"index"`
+	Parent   *AdminUnit     `gorm:"constraint:OnDelete:CASCADE"`
+	Level    AdminUnitLevel `gorm:"type:text;not null;index"`
+	Name     string         `gorm:"type:text;not null"`
+	Children []AdminUnit    `gorm:"foreignKey:ParentID"`
+	Citys    []City
+}
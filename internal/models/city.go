@@ -1,14 +1,64 @@
 package models
 
+import (
+	"math"
+
+	"settlements/internal/geo"
+
+	"gorm.io/gorm"
+)
+
+// CoordinateKeyScale is the precision coordinates are rounded to in the
+// natural key of a city: four decimal places, roughly 11 metres.
+const CoordinateKeyScale = 1e4
+
 type City struct {
 	ID         uint   `gorm:"primaryKey"`
-	Name       string `gorm:"type:text;not null"`
-	TypeID     uint
-	DistrictID uint
+	DatasetID  uint   `gorm:"not null;uniqueIndex:idx_cities_dataset_natural_key,priority:1"`
+	Name       string `gorm:"type:text;not null;uniqueIndex:idx_cities_dataset_natural_key,priority:3"`
+	TypeID     uint   `gorm:"uniqueIndex:idx_cities_dataset_natural_key,priority:4"`
+	DistrictID uint   `gorm:"uniqueIndex:idx_cities_dataset_natural_key,priority:2"`
+	Dataset    Dataset
 	Type       Type
 	District   District
 	Population int     `gorm:"type:int;not null"`
 	Childrens  int     `gorm:"type:int;not null"`
 	Latitude   float64 `gorm:"not null"`
 	Longitude  float64 `gorm:"not null"`
+	// LatitudeKey and LongitudeKey are the rounded coordinates that complete
+	// the natural key (dataset, district, name, type, coordinates) of a city.
+	LatitudeKey  int64 `gorm:"not null;default:0;uniqueIndex:idx_cities_dataset_natural_key,priority:5"`
+	LongitudeKey int64 `gorm:"not null;default:0;uniqueIndex:idx_cities_dataset_natural_key,priority:6"`
+	// AdminUnitID links the city to the lowest administrative unit it belongs
+	// to: its municipality or, when that is unknown, its subject.
+	AdminUnitID *uint `gorm:"index"`
+	AdminUnit   *AdminUnit
+	// OKTMO and OKATO are the classifier codes of the settlement, empty when
+	// the source does not have them.
+	OKTMO string `gorm:"type:varchar(11);not null;default:'';index:idx_cities_oktmo,where:oktmo <> ''"`
+	OKATO string `gorm:"type:varchar(11);not null;default:'';index:idx_cities_okato,where:okato <> ''"`
+}
+
+// CoordinateKey rounds a coordinate to the precision of the natural key.
+func CoordinateKey(v float64) int64 {
+	return int64(math.Round(v * CoordinateKeyScale))
+}
+
+// LongitudeKey rounds a longitude to the precision of the natural key.
+// The key is taken in the signed convention, so the same place has the same
+// key whichever convention its longitude is stored in.
+func LongitudeKey(v float64) int64 {
+	return CoordinateKey(geo.Signed.Normalize(v))
+}
+
+// UpdateCoordinateKeys recomputes the coordinate keys from the coordinates.
+func (c *City) UpdateCoordinateKeys() {
+	c.LatitudeKey = CoordinateKey(c.Latitude)
+	c.LongitudeKey = LongitudeKey(c.Longitude)
+}
+
+// BeforeSave keeps the coordinate keys in sync with the coordinates.
+func (c *City) BeforeSave(tx *gorm.DB) error {
+	c.UpdateCoordinateKeys()
+	return nil
 }
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CodeScheme is a Russian classifier of territories.
+type CodeScheme string
+
+const (
+	// SchemeOKTMO is the classifier of municipal territories (ОКТМО).
+	SchemeOKTMO CodeScheme = "oktmo"
+	// SchemeOKATO is the classifier of administrative-territorial division (ОКАТО).
+	SchemeOKATO CodeScheme = "okato"
+)
+
+// ParseCodeScheme converts a scheme name, in any case, into a CodeScheme.
+func ParseCodeScheme(s string) (CodeScheme, error) {
+	switch scheme := CodeScheme(strings.ToLower(strings.TrimSpace(s))); scheme {
+	case SchemeOKTMO, SchemeOKATO:
+		return scheme, nil
+	}
+	return "", fmt.Errorf("unknown code scheme %q (want %q or %q)", s, SchemeOKTMO, SchemeOKATO)
+}
+
+// SubjectCodeLen is the length of the leading part of OKTMO and OKATO codes
+// that identifies the federal subject.
+const SubjectCodeLen = 2
+
+// NormalizeCode removes the spaces and dashes codes are often grouped with,
+// as in "28 701 000".
+func NormalizeCode(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\u00a0' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(s))
+}
+
+// Valid reports whether code, normalized, is a well-formed code of the
+// scheme. OKTMO codes have 8 digits, or 11 for settlements; OKATO codes
+// have 2, 5, 8 or 11 digits depending on the level.
+func (s CodeScheme) Valid(code string) bool {
+	if !isDigits(code) {
+		return false
+	}
+
+	switch s {
+	case SchemeOKTMO:
+		return len(code) == 8 || len(code) == 11
+	case SchemeOKATO:
+		return len(code) == 2 || len(code) == 5 || len(code) == 8 || len(code) == 11
+	}
+	return false
+}
+
+// SubjectCode returns the part of a code that identifies the federal
+// subject, or "" when the code is too short to have one.
+func SubjectCode(code string) string {
+	if len(code) < SubjectCodeLen {
+		return ""
+	}
+	return code[:SubjectCodeLen]
+}
+
+// SameSubject reports whether two codes of one scheme belong to the same
+// federal subject. Empty codes are taken to match anything.
+func SameSubject(a, b string) bool {
+	if a == "" || b == "" {
+		return true
+	}
+	return SubjectCode(a) == SubjectCode(b)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
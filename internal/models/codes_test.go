@@ -0,0 +1,66 @@
+package models
+
+import "testing"
+
+func TestNormalizeCode(t *testing.T) {
+	tests := map[string]string{
+		"28701000":       "28701000",
+		" 28 701 000 ":   "28701000",
+		"28-701-000-101": "28701000101",
+		"28 701 000":     "28701000",
+		"":               "",
+	}
+
+	for in, want := range tests {
+		if got := NormalizeCode(in); got != want {
+			t.Errorf("NormalizeCode(%q): expected %q, got %q", in, want, got)
+		}
+	}
+}
+
+func TestCodeSchemeValid(t *testing.T) {
+	tests := []struct {
+		scheme CodeScheme
+		code   string
+		valid  bool
+	}{
+		{SchemeOKTMO, "28701000", true},
+		{SchemeOKTMO, "28701000101", true},
+		{SchemeOKTMO, "28", false},
+		{SchemeOKTMO, "2870100", false},
+		{SchemeOKTMO, "2870100a", false},
+		{SchemeOKATO, "28", true},
+		{SchemeOKATO, "28401", true},
+		{SchemeOKATO, "28401000000", true},
+		{SchemeOKATO, "284010", false},
+		{SchemeOKATO, "", false},
+		{CodeScheme("oktmo2"), "28701000", false},
+	}
+
+	for _, test := range tests {
+		if got := test.scheme.Valid(test.code); got != test.valid {
+			t.Errorf("%s %q: expected valid=%v, got %v", test.scheme, test.code, test.valid, got)
+		}
+	}
+}
+
+func TestSameSubject(t *testing.T) {
+	if !SameSubject("28701000", "28000000") {
+		t.Error("Expected codes with the same prefix to match")
+	}
+	if SameSubject("45301000", "28000000") {
+		t.Error("Expected codes of different subjects not to match")
+	}
+	if !SameSubject("", "28000000") {
+		t.Error("Expected an unknown code to match anything")
+	}
+}
+
+func TestParseCodeScheme(t *testing.T) {
+	if s, err := ParseCodeScheme(" OKTMO "); err != nil || s != SchemeOKTMO {
+		t.Errorf("Expected oktmo, got %q, %v", s, err)
+	}
+	if _, err := ParseCodeScheme("kladr"); err == nil {
+		t.Error("Expected an error for an unknown scheme")
+	}
+}
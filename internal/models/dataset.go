@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Dataset is one loaded version of the settlements data, e.g. a census year.
+// Every city belongs to exactly one dataset, so several versions can be kept side by side.
+type Dataset struct {
+	ID         uint      `gorm:"primaryKey"`
+	Label      string    `gorm:"type:text;not null;uniqueIndex"`
+	SourceFile string    `gorm:"type:text;not null"`
+	LoadedAt   time.Time `gorm:"not null;index"`
+	// LongitudeConvention names the geo.LongitudeConvention the loader wrote
+	// the longitudes of the dataset in.
+	LongitudeConvention string `gorm:"type:text;not null;default:''"`
+	Citys               []City
+}
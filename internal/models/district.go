@@ -4,4 +4,8 @@ type District struct {
 	ID   uint   `gorm:"primaryKey"`
 	Name string `gorm:"type:text;not null"`
 	Citys []City
+	// OKTMO and OKATO are the classifier codes of the federal subject, empty
+	// when unknown. Codes of its cities start with the same two digits.
+	OKTMO string `gorm:"type:varchar(11);not null;default:'';index:idx_districts_oktmo,where:oktmo <> ''"`
+	OKATO string `gorm:"type:varchar(11);not null;default:'';index:idx_districts_okato,where:okato <> ''"`
 }
@@ -213,3 +213,44 @@ func TestCityPopulationEdgeCases(t *testing.T) {
 		}
 	}
 }
+
+func TestCoordinateKey(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected int64
+	}{
+		{55.7558, 557558},
+		{55.75584, 557558},
+		{55.75586, 557559},
+		{-0.00004, 0},
+		{-179.99996, -1800000},
+	}
+
+	for _, test := range tests {
+		if got := CoordinateKey(test.value); got != test.expected {
+			t.Errorf("CoordinateKey(%f): expected %d, got %d", test.value, test.expected, got)
+		}
+	}
+}
+
+func TestCityUpdateCoordinateKeys(t *testing.T) {
+	city := City{Latitude: 55.7558, Longitude: 37.6173}
+	city.UpdateCoordinateKeys()
+
+	if city.LatitudeKey != 557558 || city.LongitudeKey != 376173 {
+		t.Errorf("Expected keys 557558/376173, got %d/%d", city.LatitudeKey, city.LongitudeKey)
+	}
+}
+
+func TestLongitudeKeyIgnoresConvention(t *testing.T) {
+	if LongitudeKey(-169.5) != LongitudeKey(190.5) {
+		t.Errorf("Expected the same key for -169.5 and 190.5, got %d and %d", LongitudeKey(-169.5), LongitudeKey(190.5))
+	}
+
+	city := City{Latitude: 66, Longitude: 190.5}
+	city.UpdateCoordinateKeys()
+
+	if city.LongitudeKey != -1695000 {
+		t.Errorf("Expected longitude key -1695000, got %d", city.LongitudeKey)
+	}
+}
@@ -0,0 +1,15 @@
+package models
+
+// PopulationObservation is the population of a city counted in one year,
+// typically by a census. Observations of several years make up the
+// population series of the city; a city has at most one per year.
+type PopulationObservation struct {
+	ID         uint `gorm:"primaryKey"`
+	CityID     uint `gorm:"not null;uniqueIndex:idx_population_observations_city_year,priority:1"`
+	City       City `gorm:"constraint:OnDelete:CASCADE"`
+	Year       int  `gorm:"not null;uniqueIndex:idx_population_observations_city_year,priority:2;index"`
+	Population int  `gorm:"type:int;not null"`
+	Childrens  int  `gorm:"type:int;not null"`
+	// Source names where the numbers come from, such as the loaded file.
+	Source string `gorm:"type:text;not null;default:''"`
+}
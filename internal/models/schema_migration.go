@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// SchemaMigration records a one-off data migration that has been applied,
+// so it is not run again by later migrations.
+type SchemaMigration struct {
+	Name      string    `gorm:"type:text;primaryKey"`
+	AppliedAt time.Time `gorm:"not null"`
+}
@@ -1,7 +1,8 @@
 package models
 
 type Type struct {
-	ID    uint   `gorm:"primaryKey"`
-	Name  string `gorm:"type:text;not null"`
-	Citys []City
+	ID            uint   `gorm:"primaryKey"`
+	Name          string `gorm:"type:text;not null"`
+	Citys         []City
+	Abbreviations []TypeAbbreviation
 }
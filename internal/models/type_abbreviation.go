@@ -0,0 +1,10 @@
+package models
+
+// TypeAbbreviation is an abbreviation sources use for a settlement type, e.g. "пгт".
+// A type may have several abbreviations; an abbreviation names exactly one type.
+type TypeAbbreviation struct {
+	ID           uint   `gorm:"primaryKey"`
+	Abbreviation string `gorm:"type:text;not null;uniqueIndex"`
+	TypeID       uint   `gorm:"not null;index"`
+	Type         Type
+}
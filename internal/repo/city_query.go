@@ -0,0 +1,286 @@
+package repo
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"settlements/internal/dto"
+)
+
+const (
+	// DefaultCityLimit is the page size of a CityQuery without a limit.
+	DefaultCityLimit = 50
+	// MaxCityLimit is the largest page a CityQuery can ask for.
+	MaxCityLimit = 1000
+)
+
+// CityQuery selects a page of cities. Filters left at their zero value
+// match every city; the others must all match. Pages are taken either by
+// Offset or, more cheaply on deep pages, by the Cursor of the previous page.
+type CityQuery struct {
+	// District and Type match the name of the district or settlement type exactly.
+	District string
+	Type     string
+	// Population and Childrens are in the census year of the repository.
+	Population IntRange
+	Childrens  IntRange
+	BBox       *BoundingBox
+	// NamePrefix matches the start of city names, in any case.
+	NamePrefix string
+
+	// Sort orders the cities, by ID after the given fields. Names are
+	// compared by code point in every repository, not in the collation of
+	// the database, so "ёлкино" sorts after "яблоново".
+	Sort []CitySort
+	// Limit is the page size, DefaultCityLimit when zero.
+	Limit  int
+	Offset int
+	// Cursor is the NextCursor of the previous page of the same query.
+	Cursor string
+}
+
+// IntRange is an inclusive range. A nil bound leaves that side open.
+type IntRange struct {
+	Min *int
+	Max *int
+}
+
+// Contains reports whether v is in the range.
+func (r IntRange) Contains(v int) bool {
+	return (r.Min == nil || v >= *r.Min) && (r.Max == nil || v <= *r.Max)
+}
+
+// BoundingBox is an inclusive area, with longitudes in the convention of the
+// repository. A box with MinLongitude greater than MaxLongitude crosses the
+// seam of the convention: it holds the longitudes from MinLongitude up and
+// from MaxLongitude down.
+type BoundingBox struct {
+	MinLatitude  float64
+	MinLongitude float64
+	MaxLatitude  float64
+	MaxLongitude float64
+}
+
+// Contains reports whether the point is in the box.
+func (b BoundingBox) Contains(latitude, longitude float64) bool {
+	if latitude < b.MinLatitude || latitude > b.MaxLatitude {
+		return false
+	}
+	if b.MinLongitude <= b.MaxLongitude {
+		return longitude >= b.MinLongitude && longitude <= b.MaxLongitude
+	}
+	return longitude >= b.MinLongitude || longitude <= b.MaxLongitude
+}
+
+// CitySortField is a field cities can be sorted by.
+type CitySortField string
+
+const (
+	SortByName       CitySortField = "name"
+	SortByType       CitySortField = "type"
+	SortByDistrict   CitySortField = "district"
+	SortByPopulation CitySortField = "population"
+	SortByChildrens  CitySortField = "childrens"
+	SortByLatitude   CitySortField = "latitude"
+	SortByLongitude  CitySortField = "longitude"
+)
+
+// CitySort orders cities by a field.
+type CitySort struct {
+	Field CitySortField
+	Desc  bool
+}
+
+// ParseCitySort parses a comma separated list of sort fields, each one
+// descending when prefixed with "-", as in "-population,name".
+func ParseCitySort(s string) ([]CitySort, error) {
+	var res []CitySort
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		field := CitySortField(strings.ToLower(strings.TrimPrefix(part, "-")))
+		if !field.valid() {
+			return nil, fmt.Errorf("unknown sort field %q", part)
+		}
+		res = append(res, CitySort{Field: field, Desc: strings.HasPrefix(part, "-")})
+	}
+	return res, nil
+}
+
+func (f CitySortField) valid() bool {
+	switch f {
+	case SortByName, SortByType, SortByDistrict, SortByPopulation, SortByChildrens, SortByLatitude, SortByLongitude:
+		return true
+	}
+	return false
+}
+
+// value returns the field of a city.
+func (f CitySortField) value(c dto.CityDTO) any {
+	switch f {
+	case SortByName:
+		return c.Name
+	case SortByType:
+		return c.Type
+	case SortByDistrict:
+		return c.District
+	case SortByPopulation:
+		return c.Population
+	case SortByChildrens:
+		return c.Childrens
+	case SortByLatitude:
+		return c.Latitude
+	case SortByLongitude:
+		return c.Longitude
+	}
+	return nil
+}
+
+// set decodes the field of a city from JSON.
+func (f CitySortField) set(c *dto.CityDTO, raw json.RawMessage) error {
+	switch f {
+	case SortByName:
+		return json.Unmarshal(raw, &c.Name)
+	case SortByType:
+		return json.Unmarshal(raw, &c.Type)
+	case SortByDistrict:
+		return json.Unmarshal(raw, &c.District)
+	case SortByPopulation:
+		return json.Unmarshal(raw, &c.Population)
+	case SortByChildrens:
+		return json.Unmarshal(raw, &c.Childrens)
+	case SortByLatitude:
+		return json.Unmarshal(raw, &c.Latitude)
+	case SortByLongitude:
+		return json.Unmarshal(raw, &c.Longitude)
+	}
+	return fmt.Errorf("unknown sort field %q", f)
+}
+
+// compare compares the field of two cities.
+func (f CitySortField) compare(a, b dto.CityDTO) int {
+	switch va := f.value(a).(type) {
+	case string:
+		return cmp.Compare(va, f.value(b).(string))
+	case int:
+		return cmp.Compare(va, f.value(b).(int))
+	case float64:
+		return cmp.Compare(va, f.value(b).(float64))
+	}
+	return 0
+}
+
+// Validate reports the first invalid part of the query.
+func (q CityQuery) Validate() error {
+	if q.Limit < 0 || q.Limit > MaxCityLimit {
+		return fmt.Errorf("limit must be between 0 and %d, got %d", MaxCityLimit, q.Limit)
+	}
+	if q.Offset < 0 {
+		return fmt.Errorf("offset must not be negative, got %d", q.Offset)
+	}
+	if q.Offset > 0 && q.Cursor != "" {
+		return fmt.Errorf("offset and cursor cannot be used together")
+	}
+	for name, r := range map[string]IntRange{"population": q.Population, "childrens": q.Childrens} {
+		if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+			return fmt.Errorf("%s range is empty: %d > %d", name, *r.Min, *r.Max)
+		}
+	}
+	if q.BBox != nil && q.BBox.MinLatitude > q.BBox.MaxLatitude {
+		return fmt.Errorf("bounding box latitudes are reversed: %g > %g", q.BBox.MinLatitude, q.BBox.MaxLatitude)
+	}
+	for _, s := range q.Sort {
+		if !s.Field.valid() {
+			return fmt.Errorf("unknown sort field %q", s.Field)
+		}
+	}
+	if q.Cursor != "" {
+		if _, err := q.After(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PageSize returns the number of cities on a page.
+func (q CityQuery) PageSize() int {
+	if q.Limit == 0 {
+		return DefaultCityLimit
+	}
+	return q.Limit
+}
+
+// Matches reports whether a city passes the filters of the query.
+func (q CityQuery) Matches(c dto.CityDTO) bool {
+	return (q.District == "" || c.District == q.District) &&
+		(q.Type == "" || c.Type == q.Type) &&
+		q.Population.Contains(c.Population) &&
+		q.Childrens.Contains(c.Childrens) &&
+		(q.BBox == nil || q.BBox.Contains(c.Latitude, c.Longitude)) &&
+		strings.HasPrefix(strings.ToLower(c.Name), strings.ToLower(q.NamePrefix))
+}
+
+// Compare orders two cities by the sort of the query, then by ID.
+func (q CityQuery) Compare(a, b dto.CityDTO) int {
+	for _, s := range q.Sort {
+		c := s.Field.compare(a, b)
+		if s.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// cityCursor is the position of the last city of a page: its sort fields,
+// in the order of the query, and its ID.
+type cityCursor struct {
+	Values []json.RawMessage `json:"v"`
+	ID     uint              `json:"id"`
+}
+
+// CursorOf returns the cursor of the page that ends with the given city.
+func (q CityQuery) CursorOf(last dto.CityDTO) string {
+	cursor := cityCursor{ID: last.ID}
+	for _, s := range q.Sort {
+		v, _ := json.Marshal(s.Field.value(last))
+		cursor.Values = append(cursor.Values, v)
+	}
+
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// After decodes the cursor of the query into a city with the sort fields
+// and the ID of the city the page starts after.
+func (q CityQuery) After() (dto.CityDTO, error) {
+	var c dto.CityDTO
+
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor: %w", err)
+	}
+	var cursor cityCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return c, fmt.Errorf("invalid cursor: %w", err)
+	}
+	if len(cursor.Values) != len(q.Sort) {
+		return c, fmt.Errorf("invalid cursor: it was made for another sort")
+	}
+
+	c.ID = cursor.ID
+	for i, s := range q.Sort {
+		if err := s.Field.set(&c, cursor.Values[i]); err != nil {
+			return c, fmt.Errorf("invalid cursor: %w", err)
+		}
+	}
+	return c, nil
+}
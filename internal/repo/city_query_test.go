@@ -0,0 +1,86 @@
+package repo
+
+import (
+	"testing"
+
+	"settlements/internal/dto"
+)
+
+func TestParseCitySort(t *testing.T) {
+	sort, err := ParseCitySort("-Population, name")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(sort) != 2 || sort[0] != (CitySort{SortByPopulation, true}) || sort[1] != (CitySort{SortByName, false}) {
+		t.Errorf("Unexpected sort %+v", sort)
+	}
+
+	if _, err := ParseCitySort("area"); err == nil {
+		t.Errorf("Expected an unknown field to be rejected")
+	}
+}
+
+func TestCityCursorRoundTrip(t *testing.T) {
+	q := CityQuery{Sort: []CitySort{{SortByDistrict, false}, {SortByPopulation, true}, {SortByLongitude, false}}}
+	last := dto.CityDTO{ID: 7, District: "Тверская область", Population: 424969, Longitude: 35.9}
+
+	q.Cursor = q.CursorOf(last)
+	after, err := q.After()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if after != last {
+		t.Errorf("Expected %+v, got %+v", last, after)
+	}
+
+	q.Sort = q.Sort[:1]
+	if err := q.Validate(); err == nil {
+		t.Errorf("Expected a cursor of another sort to be rejected")
+	}
+}
+
+func TestCityQueryCompareNames(t *testing.T) {
+	q := CityQuery{Sort: []CitySort{{SortByName, false}}}
+	a, b := dto.CityDTO{ID: 1, Name: "ёлкино"}, dto.CityDTO{ID: 2, Name: "яблоново"}
+
+	if c := q.Compare(a, b); c <= 0 {
+		t.Errorf("Expected %q to sort after %q by code point, got %d", a.Name, b.Name, c)
+	}
+}
+
+func TestCityQueryValidate(t *testing.T) {
+	low, high := 1000, 10
+
+	tests := []struct {
+		name string
+		q    CityQuery
+	}{
+		{"negative limit", CityQuery{Limit: -1}},
+		{"limit over max", CityQuery{Limit: MaxCityLimit + 1}},
+		{"offset with cursor", CityQuery{Offset: 10, Cursor: "x"}},
+		{"empty range", CityQuery{Population: IntRange{Min: &low, Max: &high}}},
+		{"reversed latitudes", CityQuery{BBox: &BoundingBox{MinLatitude: 60, MaxLatitude: 50}}},
+		{"invalid cursor", CityQuery{Cursor: "%%%"}},
+	}
+
+	for _, tt := range tests {
+		if err := tt.q.Validate(); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+
+	if err := (CityQuery{}).Validate(); err != nil {
+		t.Errorf("Expected an empty query to be valid, got %v", err)
+	}
+}
+
+func TestBoundingBoxAcrossSeam(t *testing.T) {
+	box := BoundingBox{MinLatitude: 60, MaxLatitude: 70, MinLongitude: 170, MaxLongitude: -170}
+
+	if !box.Contains(65, 177.5) || !box.Contains(65, -175) {
+		t.Errorf("Expected both sides of the seam in the box")
+	}
+	if box.Contains(65, 0) || box.Contains(55, 177.5) {
+		t.Errorf("Expected points outside the box to be left out")
+	}
+}
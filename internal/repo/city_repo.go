@@ -1,73 +1,596 @@
 package repo
 
 import (
-	"log"
+	"context"
+	"database/sql"
+	"fmt"
 	"settlements/internal/dto"
+	"settlements/internal/geo"
 	"settlements/internal/models"
+	"sort"
+	"strings"
 
 	"gorm.io/gorm"
 )
 
 type CityRepo struct {
 	db *gorm.DB
+	// datasetID is the dataset version queries read from. Zero means the latest one.
+	datasetID uint
+	// longitude is the convention longitudes are returned and filtered in.
+	longitude geo.LongitudeConvention
+	// year, when set, is the census year populations are reported for.
+	year int
 }
 
 func New(db *gorm.DB) *CityRepo {
 	return &CityRepo{db: db}
 }
 
-func (r *CityRepo) All() *[]dto.CityDTO {
+// WithDataset returns a repository that reads the given dataset version.
+// Zero selects the most recently loaded dataset.
+func (r *CityRepo) WithDataset(datasetID uint) CityRepository {
+	c := *r
+	c.datasetID = datasetID
+	return &c
+}
+
+// WithLongitudeConvention returns a repository that normalizes longitudes
+// into the given convention, whatever convention they were loaded in.
+func (r *CityRepo) WithLongitudeConvention(convention geo.LongitudeConvention) CityRepository {
+	c := *r
+	c.longitude = convention
+	return &c
+}
+
+// LongitudeConvention returns the convention longitudes are returned in.
+func (r *CityRepo) LongitudeConvention() geo.LongitudeConvention {
+	return r.longitude
+}
+
+// DatasetID returns the dataset version the repository was scoped to, zero meaning the latest.
+func (r *CityRepo) DatasetID() uint {
+	return r.datasetID
+}
+
+// WithYear returns a repository that reports the population and children
+// of cities as observed in the given census year. Cities without an
+// observation of that year are left out. Zero reports the current values.
+func (r *CityRepo) WithYear(year int) CityRepository {
+	return r.withYear(year)
+}
+
+func (r *CityRepo) withYear(year int) *CityRepo {
+	c := *r
+	c.year = year
+	return &c
+}
+
+// Year returns the census year populations are reported for, zero meaning the current values.
+func (r *CityRepo) Year() int {
+	return r.year
+}
+
+// cities starts a query on the cities of the selected dataset, joined with
+// their observations of the selected year when there is one.
+func (r *CityRepo) cities(ctx context.Context) *gorm.DB {
+	q := r.db.WithContext(ctx).Model(&models.City{})
+	if r.year != 0 {
+		q = q.Joins("JOIN population_observations ON population_observations.city_id = cities.id AND population_observations.year = ?", r.year)
+	}
+	if r.datasetID != 0 {
+		return q.Where("cities.dataset_id = ?", r.datasetID)
+	}
+
+	latest := r.db.Model(&models.Dataset{}).Select("id").Order("loaded_at DESC, id DESC").Limit(1)
+	return q.Where("cities.dataset_id = (?)", latest)
+}
+
+// populationSQL and childrensSQL are the population and children of a city
+// in the selected year.
+func (r *CityRepo) populationSQL() string {
+	if r.year != 0 {
+		return "population_observations.population"
+	}
+	return "cities.population"
+}
+
+func (r *CityRepo) childrensSQL() string {
+	if r.year != 0 {
+		return "population_observations.childrens"
+	}
+	return "cities.childrens"
+}
+
+// findCities reads the cities selected by q, a query started by cities().
+// Longitudes are normalized by the query, so a page cursor holds the very
+// value the keyset condition of the next page compares with.
+func (r *CityRepo) findCities(q *gorm.DB) (*[]dto.CityDTO, error) {
 	var cities []models.City
-	err := r.db.Model(&models.City{}).Preload("Type").Preload("District").Find(&cities).Error
+	err := q.Select("cities.id, cities.dataset_id, cities.name, cities.type_id, cities.district_id, " +
+		r.populationSQL() + " AS population, " + r.childrensSQL() + " AS childrens, " +
+		"cities.latitude, " + r.longitudeSQL() + " AS longitude, cities.latitude_key, cities.longitude_key, " +
+		"cities.admin_unit_id, cities.oktmo, cities.okato").
+		Preload("Type").Preload("District").Find(&cities).Error
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("failed to query cities: %w", err)
 	}
 
-	res := []dto.CityDTO{}
-	for _, c := range cities {
-		cityDTO := dto.CityDTO{
-			ID:         c.ID,
-			Name:       c.Name,
-			Type:       c.Type.Name,
-			District:   c.District.Name,
-			Population: c.Population,
-			Childrens:  c.Childrens,
-			Latitude:   c.Latitude,
-			Longitude:  c.Longitude,
+	return r.toCityDTOs(cities), nil
+}
+
+func (r *CityRepo) All(ctx context.Context) (*[]dto.CityDTO, error) {
+	return r.findCities(r.cities(ctx))
+}
+
+// FindCities returns the page of cities of the selected dataset selected by
+// the query, filtered, sorted and paged in the database. The page is read
+// with one city more than it holds, to know whether another one follows.
+func (r *CityRepo) FindCities(ctx context.Context, q CityQuery) (*dto.CityPageDTO, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	var total int64
+	if err := r.queryCities(ctx, q).Count(&total).Error; err != nil {
+		return nil, fmt.Errorf("failed to count cities: %w", err)
+	}
+
+	page := r.queryCities(ctx, q)
+	if q.Cursor != "" {
+		after, _ := q.After()
+		where, args := r.keysetSQL(q, after)
+		page = page.Where(where, args...)
+	}
+	for _, s := range q.Sort {
+		order := r.sortSQL(s.Field)
+		if s.Desc {
+			order += " DESC"
 		}
-		res = append(res, cityDTO)
+		page = page.Order(order)
 	}
+	size := q.PageSize()
+	page = page.Order("cities.id").Limit(size + 1).Offset(q.Offset)
 
-	return &res
+	cities, err := r.findCities(page)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &dto.CityPageDTO{Cities: *cities, Total: total}
+	if len(res.Cities) > size {
+		res.Cities = res.Cities[:size]
+		res.NextCursor = q.CursorOf(res.Cities[size-1])
+	}
+	return res, nil
+}
+
+// queryCities starts a query on the cities of the selected dataset that
+// pass the filters of q, joined with their types and districts.
+func (r *CityRepo) queryCities(ctx context.Context, q CityQuery) *gorm.DB {
+	db := r.cities(ctx).
+		Joins("JOIN types ON types.id = cities.type_id").
+		Joins("JOIN districts ON districts.id = cities.district_id")
+
+	if q.District != "" {
+		db = db.Where("districts.name = ?", q.District)
+	}
+	if q.Type != "" {
+		db = db.Where("types.name = ?", q.Type)
+	}
+	db = whereRange(db, r.populationSQL(), q.Population)
+	db = whereRange(db, r.childrensSQL(), q.Childrens)
+	if b := q.BBox; b != nil {
+		db = db.Where("cities.latitude BETWEEN ? AND ?", b.MinLatitude, b.MaxLatitude)
+		longitude := r.longitudeSQL()
+		if b.MinLongitude <= b.MaxLongitude {
+			db = db.Where(longitude+" BETWEEN ? AND ?", b.MinLongitude, b.MaxLongitude)
+		} else {
+			db = db.Where(longitude+" >= ? OR "+longitude+" <= ?", b.MinLongitude, b.MaxLongitude)
+		}
+	}
+	if q.NamePrefix != "" {
+		db = db.Where("cities.name ILIKE ?", likeEscaper.Replace(q.NamePrefix)+"%")
+	}
+	return db
 }
 
-func (r *CityRepo) MinLongitude() float64 {
-	var city models.City
-	err := r.db.Order("longitude").Limit(1).Find(&city).Error
+// likeEscaper escapes the wildcards of LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func whereRange(db *gorm.DB, column string, r IntRange) *gorm.DB {
+	if r.Min != nil {
+		db = db.Where(column+" >= ?", *r.Min)
+	}
+	if r.Max != nil {
+		db = db.Where(column+" <= ?", *r.Max)
+	}
+	return db
+}
+
+// sortSQL is the column of a sort field in queries started by queryCities.
+// Names are compared in the C collation, by code point, as CityQuery.Compare
+// compares them.
+func (r *CityRepo) sortSQL(field CitySortField) string {
+	switch field {
+	case SortByType:
+		return `types.name COLLATE "C"`
+	case SortByDistrict:
+		return `districts.name COLLATE "C"`
+	case SortByPopulation:
+		return r.populationSQL()
+	case SortByChildrens:
+		return r.childrensSQL()
+	case SortByLatitude:
+		return "cities.latitude"
+	case SortByLongitude:
+		return r.longitudeSQL()
+	}
+	return `cities.name COLLATE "C"`
+}
+
+// keysetSQL is the condition that selects the cities sorted after the city
+// after: those greater in the first sort field, or equal in it and greater
+// in the next one, and so on down to the ID.
+func (r *CityRepo) keysetSQL(q CityQuery, after dto.CityDTO) (string, []any) {
+	type key struct {
+		column string
+		desc   bool
+		value  any
+	}
+	keys := []key{}
+	for _, s := range q.Sort {
+		keys = append(keys, key{r.sortSQL(s.Field), s.Desc, s.Field.value(after)})
+	}
+	keys = append(keys, key{"cities.id", false, after.ID})
+
+	var (
+		or   []string
+		args []any
+	)
+	for i, k := range keys {
+		var and []string
+		for _, eq := range keys[:i] {
+			and = append(and, eq.column+" = ?")
+			args = append(args, eq.value)
+		}
+		op := " > ?"
+		if k.desc {
+			op = " < ?"
+		}
+		and = append(and, k.column+op)
+		args = append(args, k.value)
+		or = append(or, "("+strings.Join(and, " AND ")+")")
+	}
+	return "(" + strings.Join(or, " OR ") + ")", args
+}
+
+func (r *CityRepo) MinLongitude(ctx context.Context) (float64, error) {
+	var res sql.NullFloat64
+	err := r.cities(ctx).Select("MIN(" + r.longitudeSQL() + ")").Scan(&res).Error
 	if err != nil {
-		log.Fatal(err)
+		return 0, fmt.Errorf("failed to query min longitude: %w", err)
 	}
 
-	return city.Longitude
+	return res.Float64, nil
 }
 
-func (r *CityRepo) MaxLongitude() float64 {
-	var city models.City
-	err := r.db.Order("longitude desc").Limit(1).Find(&city).Error
+func (r *CityRepo) MaxLongitude(ctx context.Context) (float64, error) {
+	var res sql.NullFloat64
+	err := r.cities(ctx).Select("MAX(" + r.longitudeSQL() + ")").Scan(&res).Error
 	if err != nil {
-		log.Fatal(err)
+		return 0, fmt.Errorf("failed to query max longitude: %w", err)
 	}
 
-	return city.Longitude
+	return res.Float64, nil
 }
 
-func (r *CityRepo) GetCitiesInLongitudeGap(lMin, lMax float64) *[]dto.CityDTO {
-	var cities []models.City
-	err := r.db.Where("longitude >= ? AND longitude < ?", lMin, lMax).Preload("Type").Preload("District").Find(&cities).Error
+func (r *CityRepo) GetCitiesInLongitudeGap(ctx context.Context, lMin, lMax float64) (*[]dto.CityDTO, error) {
+	longitude := r.longitudeSQL()
+	return r.findCities(r.cities(ctx).Where(longitude+" >= ? AND "+longitude+" < ?", lMin, lMax))
+}
+
+// TypeStats aggregates the cities of the selected dataset by settlement
+// type in the database, the type with the highest average population first.
+func (r *CityRepo) TypeStats(ctx context.Context) (*[]dto.TypeStatsDTO, error) {
+	population, childrens := r.populationSQL(), r.childrensSQL()
+
+	res := []dto.TypeStatsDTO{}
+	err := r.cities(ctx).
+		Select("types.name AS type, COUNT(*) AS cities, " +
+			"AVG(" + population + ") AS avg_population, AVG(" + childrens + ") AS avg_childrens, " +
+			"MIN(" + population + ") AS min_population, MAX(" + population + ") AS max_population").
+		Joins("JOIN types ON types.id = cities.type_id").
+		Group("types.id, types.name").
+		Order("avg_population DESC, types.name").
+		Scan(&res).Error
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("failed to query type stats: %w", err)
 	}
 
+	return &res, nil
+}
+
+// DistrictTotals sums the cities of the selected dataset by district in the
+// database, the most populous district first.
+func (r *CityRepo) DistrictTotals(ctx context.Context) (*[]dto.DistrictTotalDTO, error) {
+	res := []dto.DistrictTotalDTO{}
+	err := r.cities(ctx).
+		Select("districts.name AS district, COUNT(*) AS cities, " +
+			"SUM(" + r.populationSQL() + ") AS population, SUM(" + r.childrensSQL() + ") AS childrens").
+		Joins("JOIN districts ON districts.id = cities.district_id").
+		Group("districts.id, districts.name").
+		Order("population DESC, districts.name").
+		Scan(&res).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query district totals: %w", err)
+	}
+
+	return &res, nil
+}
+
+// histogramSQL buckets the values v of the rows of @vals with width_bucket
+// over their range, putting the largest value into the last bucket and every
+// value into the first when they are all equal.
+const histogramSQL = `WITH vals AS (@vals),
+	bounds AS (SELECT MIN(v) AS lo, MAX(v) AS hi FROM vals)
+	SELECT CASE WHEN bounds.hi = bounds.lo THEN 0
+			ELSE LEAST(width_bucket(vals.v, bounds.lo, bounds.hi, CAST(@buckets AS integer)), @buckets) - 1 END AS bucket,
+		bounds.lo, bounds.hi,
+		COUNT(*) AS cities, SUM(vals.population) AS population, SUM(vals.childrens) AS childrens
+	FROM vals CROSS JOIN bounds
+	GROUP BY 1, bounds.lo, bounds.hi
+	ORDER BY 1`
+
+// Histogram sums the cities of the selected dataset into buckets over the
+// range of a numeric column with a single width_bucket query.
+func (r *CityRepo) Histogram(ctx context.Context, column HistogramColumn, buckets int) (*[]dto.HistogramBucketDTO, error) {
+	if buckets <= 0 {
+		return nil, fmt.Errorf("histogram needs at least one bucket, got %d", buckets)
+	}
+
+	var value string
+	switch column {
+	case HistogramLongitude:
+		value = r.longitudeSQL()
+	case HistogramLatitude:
+		value = "cities.latitude"
+	case HistogramPopulation:
+		value = r.populationSQL()
+	default:
+		return nil, fmt.Errorf("unknown histogram column %q", column)
+	}
+
+	vals := r.cities(ctx).Select("(" + value + ")::float8 AS v, " +
+		r.populationSQL() + " AS population, " + r.childrensSQL() + " AS childrens")
+
+	var rows []struct {
+		Bucket     int
+		Lo, Hi     float64
+		Cities     int64
+		Population int64
+		Childrens  int64
+	}
+	err := r.db.WithContext(ctx).Raw(histogramSQL, sql.Named("vals", vals), sql.Named("buckets", buckets)).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s histogram: %w", column, err)
+	}
+
+	res := []dto.HistogramBucketDTO{}
+	if len(rows) == 0 {
+		return &res, nil
+	}
+
+	res = dto.HistogramBuckets(rows[0].Lo, rows[0].Hi, buckets)
+	for _, row := range rows {
+		res[row.Bucket].Cities = row.Cities
+		res[row.Bucket].Population = row.Population
+		res[row.Bucket].Childrens = row.Childrens
+	}
+	return &res, nil
+}
+
+// adminTreeSQL maps every administrative unit to itself and to each unit
+// below it, as (root, id) pairs, for the roots selected by the condition.
+const adminTreeSQL = `WITH RECURSIVE tree AS (
+	SELECT id AS root, id FROM admin_units WHERE %s
+	UNION ALL
+	SELECT tree.root, admin_units.id FROM admin_units JOIN tree ON admin_units.parent_id = tree.id
+)`
+
+// AdminUnits aggregates the cities of the selected dataset by the
+// administrative units of the given level. A unit counts the cities linked
+// to it and to every unit below it; units without cities are left out.
+func (r *CityRepo) AdminUnits(ctx context.Context, level models.AdminUnitLevel) (*[]dto.AdminUnitDTO, error) {
+	totals := r.cities(ctx).
+		Select("cities.admin_unit_id, COUNT(*) AS cities, SUM(" + r.populationSQL() + ") AS population, SUM(" + r.childrensSQL() + ") AS childrens").
+		Group("cities.admin_unit_id")
+
+	var res []dto.AdminUnitDTO
+	err := r.db.WithContext(ctx).Raw(fmt.Sprintf(adminTreeSQL, "level = @level")+`
+		SELECT admin_units.id, admin_units.parent_id, admin_units.level, admin_units.name,
+			SUM(totals.cities) AS cities, SUM(totals.population) AS population, SUM(totals.childrens) AS childrens
+		FROM admin_units
+		JOIN tree ON tree.root = admin_units.id
+		JOIN (@totals) AS totals ON totals.admin_unit_id = tree.id
+		GROUP BY admin_units.id, admin_units.parent_id, admin_units.level, admin_units.name
+		ORDER BY admin_units.name, admin_units.id`,
+		sql.Named("level", level), sql.Named("totals", totals),
+	).Scan(&res).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query admin units: %w", err)
+	}
+
+	if res == nil {
+		res = []dto.AdminUnitDTO{}
+	}
+	return &res, nil
+}
+
+// CitiesInAdminUnit returns the cities of the selected dataset linked to the
+// administrative unit with the given ID or to any unit below it.
+func (r *CityRepo) CitiesInAdminUnit(ctx context.Context, id uint) (*[]dto.CityDTO, error) {
+	units := r.db.WithContext(ctx).Raw(fmt.Sprintf(adminTreeSQL, "id = ?")+" SELECT id FROM tree", id)
+
+	return r.findCities(r.cities(ctx).Where("cities.admin_unit_id IN (?)", units))
+}
+
+// CitiesByCode returns the cities of the selected dataset with the given
+// OKTMO or OKATO code. The code is normalized before the lookup.
+func (r *CityRepo) CitiesByCode(ctx context.Context, scheme models.CodeScheme, code string) (*[]dto.CityDTO, error) {
+	return r.findCities(r.cities(ctx).Where("cities."+string(scheme)+" = ?", models.NormalizeCode(code)).Order("cities.id"))
+}
+
+// CodeMismatches returns the cities of the selected dataset whose OKTMO or
+// OKATO code belongs to another federal subject than the code of the same
+// scheme their district has. Cities or districts without a code are not checked.
+func (r *CityRepo) CodeMismatches(ctx context.Context) (*[]dto.CodeMismatchDTO, error) {
+	res := []dto.CodeMismatchDTO{}
+	for _, scheme := range []models.CodeScheme{models.SchemeOKTMO, models.SchemeOKATO} {
+		city, district := "cities."+string(scheme), "districts."+string(scheme)
+
+		var mismatches []dto.CodeMismatchDTO
+		err := r.cities(ctx).
+			Select("cities.id AS city_id, cities.name AS city, districts.name AS district, ? AS scheme, "+
+				city+" AS city_code, "+district+" AS district_code", scheme).
+			Joins("JOIN districts ON districts.id = cities.district_id").
+			Where(city+" <> '' AND "+district+" <> ''").
+			Where("left("+city+", ?) <> left("+district+", ?)", models.SubjectCodeLen, models.SubjectCodeLen).
+			Order("districts.name, cities.name, cities.id").
+			Scan(&mismatches).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to query %s mismatches: %w", scheme, err)
+		}
+		res = append(res, mismatches...)
+	}
+
+	return &res, nil
+}
+
+// PopulationHistory returns the observations of the city with the given ID,
+// the earliest year first.
+func (r *CityRepo) PopulationHistory(ctx context.Context, cityID uint) (*[]dto.PopulationObservationDTO, error) {
+	res := []dto.PopulationObservationDTO{}
+	err := r.db.WithContext(ctx).Model(&models.PopulationObservation{}).
+		Select("city_id, year, population, childrens, source").
+		Where("city_id = ?", cityID).Order("year").Scan(&res).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query population history: %w", err)
+	}
+
+	return &res, nil
+}
+
+// ObservedYears returns the census years the cities of the selected dataset
+// have observations of, in ascending order.
+func (r *CityRepo) ObservedYears(ctx context.Context) ([]int, error) {
+	years := []int{}
+	err := r.withYear(0).cities(ctx).
+		Joins("JOIN population_observations ON population_observations.city_id = cities.id").
+		Distinct("population_observations.year").Order("population_observations.year").
+		Pluck("population_observations.year", &years).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query observed years: %w", err)
+	}
+
+	return years, nil
+}
+
+// observedIn starts a query on the cities of the selected dataset observed
+// in both years, with their observations joined as from_obs and to_obs.
+func (r *CityRepo) observedIn(ctx context.Context, fromYear, toYear int) *gorm.DB {
+	return r.withYear(0).cities(ctx).
+		Joins("JOIN population_observations AS from_obs ON from_obs.city_id = cities.id AND from_obs.year = ?", fromYear).
+		Joins("JOIN population_observations AS to_obs ON to_obs.city_id = cities.id AND to_obs.year = ?", toYear).
+		Joins("JOIN districts ON districts.id = cities.district_id")
+}
+
+// GrowthRates returns how the population of every city of the selected
+// dataset observed in both years changed between them, the fastest growing
+// first. Cities with no population in fromYear have no rate and are left out.
+func (r *CityRepo) GrowthRates(ctx context.Context, fromYear, toYear int) (*[]dto.GrowthRateDTO, error) {
+	res := []dto.GrowthRateDTO{}
+	err := r.observedIn(ctx, fromYear, toYear).
+		Select(`cities.id AS city_id, cities.name, types.name AS type, districts.name AS district,
+			from_obs.year AS from_year, to_obs.year AS to_year,
+			from_obs.population AS from_population, to_obs.population AS to_population`).
+		Joins("JOIN types ON types.id = cities.type_id").
+		Where("from_obs.population > 0").
+		Scan(&res).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query growth rates: %w", err)
+	}
+
+	for i := range res {
+		res[i].Rate, res[i].AnnualRate = dto.GrowthRates(int64(res[i].FromPopulation), int64(res[i].ToPopulation), toYear-fromYear)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Rate != res[j].Rate {
+			return res[i].Rate > res[j].Rate
+		}
+		return res[i].CityID < res[j].CityID
+	})
+
+	return &res, nil
+}
+
+// DistrictGrowthRates sums the populations of the cities of every district
+// observed in both years and returns how the sums changed, the fastest
+// growing district first. Districts with no population in fromYear are left out.
+func (r *CityRepo) DistrictGrowthRates(ctx context.Context, fromYear, toYear int) (*[]dto.DistrictGrowthRateDTO, error) {
+	res := []dto.DistrictGrowthRateDTO{}
+	err := r.observedIn(ctx, fromYear, toYear).
+		Select(`districts.name AS district, COUNT(*) AS cities,
+			MIN(from_obs.year) AS from_year, MIN(to_obs.year) AS to_year,
+			SUM(from_obs.population) AS from_population, SUM(to_obs.population) AS to_population`).
+		Group("districts.id, districts.name").
+		Having("SUM(from_obs.population) > 0").
+		Scan(&res).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query district growth rates: %w", err)
+	}
+
+	for i := range res {
+		res[i].Rate, res[i].AnnualRate = dto.GrowthRates(res[i].FromPopulation, res[i].ToPopulation, toYear-fromYear)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Rate != res[j].Rate {
+			return res[i].Rate > res[j].Rate
+		}
+		return res[i].District < res[j].District
+	})
+
+	return &res, nil
+}
+
+// longitudeSQL is the city longitude normalized into the repository convention.
+func (r *CityRepo) longitudeSQL() string {
+	return r.longitude.SQL("cities.longitude")
+}
+
+// Datasets returns every loaded dataset version, the latest first.
+func (r *CityRepo) Datasets(ctx context.Context) (*[]dto.DatasetDTO, error) {
+	var datasets []models.Dataset
+	err := r.db.WithContext(ctx).Order("loaded_at DESC, id DESC").Find(&datasets).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query datasets: %w", err)
+	}
+
+	res := []dto.DatasetDTO{}
+	for _, d := range datasets {
+		res = append(res, dto.DatasetDTO{
+			ID:         d.ID,
+			Label:      d.Label,
+			SourceFile: d.SourceFile,
+			LoadedAt:   d.LoadedAt,
+		})
+	}
+
+	return &res, nil
+}
+
+func (r *CityRepo) toCityDTOs(cities []models.City) *[]dto.CityDTO {
 	res := []dto.CityDTO{}
 	for _, c := range cities {
 		cityDTO := dto.CityDTO{
@@ -79,6 +602,8 @@ func (r *CityRepo) GetCitiesInLongitudeGap(lMin, lMax float64) *[]dto.CityDTO {
 			Childrens:  c.Childrens,
 			Latitude:   c.Latitude,
 			Longitude:  c.Longitude,
+			OKTMO:      c.OKTMO,
+			OKATO:      c.OKATO,
 		}
 		res = append(res, cityDTO)
 	}
@@ -0,0 +1,105 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"settlements/internal/dto"
+	"settlements/internal/geo"
+	"settlements/internal/models"
+)
+
+// CityRepository reads the settlements data. CityRepo implements it on
+// PostgreSQL; package memory keeps the data in memory, for tests and for
+// running the application without a database.
+//
+// A repository is scoped to one dataset version, one census year and one
+// longitude convention. The With methods return a copy with another scope
+// and leave the receiver as it is. The query methods stop when ctx is done
+// and return the errors of the underlying store instead of exiting.
+type CityRepository interface {
+	// WithDataset scopes the copy to a dataset version; zero selects the most
+	// recently loaded one.
+	WithDataset(datasetID uint) CityRepository
+	// WithYear scopes the copy to the populations observed in a census year;
+	// zero selects the current populations.
+	WithYear(year int) CityRepository
+	// WithLongitudeConvention makes the copy return and filter longitudes in
+	// the given convention.
+	WithLongitudeConvention(convention geo.LongitudeConvention) CityRepository
+
+	DatasetID() uint
+	Year() int
+	LongitudeConvention() geo.LongitudeConvention
+
+	// Datasets returns every loaded dataset version, the latest first.
+	Datasets(ctx context.Context) (*[]dto.DatasetDTO, error)
+
+	All(ctx context.Context) (*[]dto.CityDTO, error)
+	// FindCities returns the page of cities selected by a query with the
+	// number of cities matching its filters on all pages. Invalid queries
+	// are rejected with the error of their Validate.
+	FindCities(ctx context.Context, q CityQuery) (*dto.CityPageDTO, error)
+	MinLongitude(ctx context.Context) (float64, error)
+	MaxLongitude(ctx context.Context) (float64, error)
+	// GetCitiesInLongitudeGap returns the cities with longitudes in [lMin, lMax).
+	GetCitiesInLongitudeGap(ctx context.Context, lMin, lMax float64) (*[]dto.CityDTO, error)
+
+	// TypeStats aggregates the cities by settlement type, the type with the
+	// highest average population first.
+	TypeStats(ctx context.Context) (*[]dto.TypeStatsDTO, error)
+	// DistrictTotals aggregates the cities by district, the most populous first.
+	DistrictTotals(ctx context.Context) (*[]dto.DistrictTotalDTO, error)
+
+	// Histogram sums the cities into a number of equally wide buckets over
+	// the range of a numeric column, given by buckets, lowest bucket first.
+	// Every bucket is returned, empty ones too; none are returned when there
+	// are no cities.
+	Histogram(ctx context.Context, column HistogramColumn, buckets int) (*[]dto.HistogramBucketDTO, error)
+
+	// AdminUnits aggregates the cities by the administrative units of a level,
+	// counting the cities of every unit below a unit too.
+	AdminUnits(ctx context.Context, level models.AdminUnitLevel) (*[]dto.AdminUnitDTO, error)
+	// CitiesInAdminUnit returns the cities of a unit and of the units below it.
+	CitiesInAdminUnit(ctx context.Context, id uint) (*[]dto.CityDTO, error)
+
+	// CitiesByCode returns the cities with an OKTMO or OKATO code.
+	CitiesByCode(ctx context.Context, scheme models.CodeScheme, code string) (*[]dto.CityDTO, error)
+	// CodeMismatches returns the cities whose codes belong to another federal
+	// subject than the codes of their districts.
+	CodeMismatches(ctx context.Context) (*[]dto.CodeMismatchDTO, error)
+
+	// PopulationHistory returns the observations of a city, the earliest year first.
+	PopulationHistory(ctx context.Context, cityID uint) (*[]dto.PopulationObservationDTO, error)
+	// ObservedYears returns the census years with observations, in ascending order.
+	ObservedYears(ctx context.Context) ([]int, error)
+	// GrowthRates returns the population change of every city observed in
+	// both years, the fastest growing first.
+	GrowthRates(ctx context.Context, fromYear, toYear int) (*[]dto.GrowthRateDTO, error)
+	// DistrictGrowthRates returns the population change of every district,
+	// counting the cities observed in both years, the fastest growing first.
+	DistrictGrowthRates(ctx context.Context, fromYear, toYear int) (*[]dto.DistrictGrowthRateDTO, error)
+}
+
+var _ CityRepository = (*CityRepo)(nil)
+
+// HistogramColumn is a numeric city column a histogram can be built over.
+type HistogramColumn string
+
+const (
+	// HistogramLongitude is the longitude in the convention of the repository.
+	HistogramLongitude HistogramColumn = "longitude"
+	HistogramLatitude  HistogramColumn = "latitude"
+	// HistogramPopulation is the population in the year of the repository.
+	HistogramPopulation HistogramColumn = "population"
+)
+
+// ParseHistogramColumn converts a column name, in any case, into a HistogramColumn.
+func ParseHistogramColumn(s string) (HistogramColumn, error) {
+	switch column := HistogramColumn(strings.ToLower(strings.TrimSpace(s))); column {
+	case HistogramLongitude, HistogramLatitude, HistogramPopulation:
+		return column, nil
+	}
+	return "", fmt.Errorf("unknown histogram column %q (want %q, %q or %q)", s, HistogramLongitude, HistogramLatitude, HistogramPopulation)
+}
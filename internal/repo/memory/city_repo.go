@@ -0,0 +1,761 @@
+// Package memory implements repo.CityRepository on data held in memory. It
+// needs no database, so services and controllers can be run and tested
+// against seeded data.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"settlements/internal/dto"
+	"settlements/internal/geo"
+	"settlements/internal/models"
+	"settlements/internal/repo"
+)
+
+// CityRepo is a repo.CityRepository over data held in memory. It is seeded
+// with AddDataset, AddCity, SetDistrictCodes and AddObservation, or from CSV
+// with LoadCSV. It is safe for concurrent use; the copies made by the With
+// methods share the data of the repository they were made from. Queries
+// return the error of a done context, or the error set by Fail.
+type CityRepo struct {
+	data *store
+	// datasetID, year and longitude scope queries as they do in repo.CityRepo.
+	datasetID uint
+	year      int
+	longitude geo.LongitudeConvention
+}
+
+var _ repo.CityRepository = (*CityRepo)(nil)
+
+type store struct {
+	mu           sync.RWMutex
+	datasets     []dto.DatasetDTO
+	cities       []city
+	districts    map[string]districtCodes
+	units        []adminUnit
+	observations []dto.PopulationObservationDTO
+	// err, when set, is returned by every query.
+	err error
+}
+
+// city is a stored city. Its longitude is kept as it was added.
+type city struct {
+	dto.CityDTO
+	datasetID   uint
+	adminUnitID uint
+}
+
+type districtCodes struct {
+	oktmo string
+	okato string
+}
+
+// adminUnit is a node of the administrative hierarchy. Subjects have a zero parentID.
+type adminUnit struct {
+	id       uint
+	parentID uint
+	level    models.AdminUnitLevel
+	name     string
+}
+
+// City is a settlement to seed the repository with.
+type City struct {
+	Name     string
+	Type     string
+	District string
+	// Municipality links the city to a municipality of its district. The city
+	// is linked to the district, as a federal subject, when it is empty.
+	Municipality string
+	Population   int
+	Childrens    int
+	Latitude     float64
+	Longitude    float64
+	OKTMO        string
+	OKATO        string
+}
+
+// New creates an empty repository.
+func New() *CityRepo {
+	return &CityRepo{data: &store{districts: map[string]districtCodes{}}}
+}
+
+// AddDataset adds a dataset version and returns its ID.
+func (r *CityRepo) AddDataset(label string, loadedAt time.Time) uint {
+	r.data.mu.Lock()
+	defer r.data.mu.Unlock()
+
+	id := uint(len(r.data.datasets) + 1)
+	r.data.datasets = append(r.data.datasets, dto.DatasetDTO{ID: id, Label: label, LoadedAt: loadedAt})
+	return id
+}
+
+// AddCity adds a city to the dataset with the given ID and returns the ID of
+// the city. Its administrative units are created as needed.
+func (r *CityRepo) AddCity(datasetID uint, c City) uint {
+	r.data.mu.Lock()
+	defer r.data.mu.Unlock()
+
+	unitID := r.data.adminUnit(0, models.AdminLevelSubject, c.District)
+	if c.Municipality != "" {
+		unitID = r.data.adminUnit(unitID, models.AdminLevelMunicipality, c.Municipality)
+	}
+
+	id := uint(len(r.data.cities) + 1)
+	r.data.cities = append(r.data.cities, city{
+		CityDTO: dto.CityDTO{
+			ID:         id,
+			Name:       c.Name,
+			Type:       c.Type,
+			District:   c.District,
+			Population: c.Population,
+			Childrens:  c.Childrens,
+			Latitude:   c.Latitude,
+			Longitude:  c.Longitude,
+			OKTMO:      models.NormalizeCode(c.OKTMO),
+			OKATO:      models.NormalizeCode(c.OKATO),
+		},
+		datasetID:   datasetID,
+		adminUnitID: unitID,
+	})
+	return id
+}
+
+// adminUnit finds or creates the unit of the given level and name under
+// parentID and returns its ID. The caller must hold the write lock.
+func (s *store) adminUnit(parentID uint, level models.AdminUnitLevel, name string) uint {
+	for _, u := range s.units {
+		if u.parentID == parentID && u.level == level && u.name == name {
+			return u.id
+		}
+	}
+
+	id := uint(len(s.units) + 1)
+	s.units = append(s.units, adminUnit{id: id, parentID: parentID, level: level, name: name})
+	return id
+}
+
+// SetDistrictCodes sets the OKTMO and OKATO codes of the district with the
+// given name. An empty code leaves the code of the scheme as it was.
+func (r *CityRepo) SetDistrictCodes(district, oktmo, okato string) {
+	r.data.mu.Lock()
+	defer r.data.mu.Unlock()
+
+	codes := r.data.districts[district]
+	if oktmo != "" {
+		codes.oktmo = models.NormalizeCode(oktmo)
+	}
+	if okato != "" {
+		codes.okato = models.NormalizeCode(okato)
+	}
+	r.data.districts[district] = codes
+}
+
+// AddObservation records the population of a city in a year, replacing an
+// earlier observation of the same city and year.
+func (r *CityRepo) AddObservation(o dto.PopulationObservationDTO) {
+	r.data.mu.Lock()
+	defer r.data.mu.Unlock()
+
+	for i, existing := range r.data.observations {
+		if existing.CityID == o.CityID && existing.Year == o.Year {
+			r.data.observations[i] = o
+			return
+		}
+	}
+	r.data.observations = append(r.data.observations, o)
+}
+
+// Fail makes every query of the repository and of its copies return err,
+// as a database that went away would. Nil makes queries succeed again.
+func (r *CityRepo) Fail(err error) {
+	r.data.mu.Lock()
+	defer r.data.mu.Unlock()
+
+	r.data.err = err
+}
+
+// check returns the error a query should fail with, if any. It takes the
+// read lock itself, so it is called before a query takes it.
+func (r *CityRepo) check(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+	return r.data.err
+}
+
+func (r *CityRepo) WithDataset(datasetID uint) repo.CityRepository {
+	c := *r
+	c.datasetID = datasetID
+	return &c
+}
+
+func (r *CityRepo) WithYear(year int) repo.CityRepository {
+	c := *r
+	c.year = year
+	return &c
+}
+
+func (r *CityRepo) WithLongitudeConvention(convention geo.LongitudeConvention) repo.CityRepository {
+	c := *r
+	c.longitude = convention
+	return &c
+}
+
+func (r *CityRepo) DatasetID() uint {
+	return r.datasetID
+}
+
+func (r *CityRepo) Year() int {
+	return r.year
+}
+
+func (r *CityRepo) LongitudeConvention() geo.LongitudeConvention {
+	return r.longitude
+}
+
+func (r *CityRepo) Datasets(ctx context.Context) (*[]dto.DatasetDTO, error) {
+	if err := r.check(ctx); err != nil {
+		return nil, err
+	}
+
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	res := append([]dto.DatasetDTO{}, r.data.datasets...)
+	sortDatasets(res)
+	return &res, nil
+}
+
+// sortDatasets orders datasets the latest first.
+func sortDatasets(datasets []dto.DatasetDTO) {
+	sort.SliceStable(datasets, func(i, j int) bool {
+		if !datasets[i].LoadedAt.Equal(datasets[j].LoadedAt) {
+			return datasets[i].LoadedAt.After(datasets[j].LoadedAt)
+		}
+		return datasets[i].ID > datasets[j].ID
+	})
+}
+
+// selected returns the cities in the scope of the repository, in the order
+// they were added, with the population of the selected year and longitudes
+// in the selected convention. The caller must hold the read lock.
+func (r *CityRepo) selected() []city {
+	datasetID := r.datasetID
+	if datasetID == 0 {
+		datasets := append([]dto.DatasetDTO{}, r.data.datasets...)
+		if len(datasets) == 0 {
+			return nil
+		}
+		sortDatasets(datasets)
+		datasetID = datasets[0].ID
+	}
+
+	var res []city
+	for _, c := range r.data.cities {
+		if c.datasetID != datasetID {
+			continue
+		}
+		if r.year != 0 {
+			o, ok := r.data.observation(c.ID, r.year)
+			if !ok {
+				continue
+			}
+			c.Population, c.Childrens = o.Population, o.Childrens
+		}
+		c.Longitude = r.longitude.Normalize(c.Longitude)
+		res = append(res, c)
+	}
+	return res
+}
+
+// observation returns the observation of a city in a year. The caller must hold the read lock.
+func (s *store) observation(cityID uint, year int) (dto.PopulationObservationDTO, bool) {
+	for _, o := range s.observations {
+		if o.CityID == cityID && o.Year == year {
+			return o, true
+		}
+	}
+	return dto.PopulationObservationDTO{}, false
+}
+
+// cityDTOs returns the selected cities that match keep.
+func (r *CityRepo) cityDTOs(ctx context.Context, keep func(c city) bool) (*[]dto.CityDTO, error) {
+	if err := r.check(ctx); err != nil {
+		return nil, err
+	}
+
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	res := []dto.CityDTO{}
+	for _, c := range r.selected() {
+		if keep(c) {
+			res = append(res, c.CityDTO)
+		}
+	}
+	return &res, nil
+}
+
+func (r *CityRepo) All(ctx context.Context) (*[]dto.CityDTO, error) {
+	return r.cityDTOs(ctx, func(city) bool { return true })
+}
+
+func (r *CityRepo) FindCities(ctx context.Context, q repo.CityQuery) (*dto.CityPageDTO, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	all, err := r.cityDTOs(ctx, func(c city) bool { return q.Matches(c.CityDTO) })
+	if err != nil {
+		return nil, err
+	}
+	cities := *all
+	slices.SortStableFunc(cities, q.Compare)
+
+	res := &dto.CityPageDTO{Total: int64(len(cities))}
+	if q.Cursor != "" {
+		after, _ := q.After()
+		i, _ := slices.BinarySearchFunc(cities, after, q.Compare)
+		for i < len(cities) && q.Compare(cities[i], after) <= 0 {
+			i++
+		}
+		cities = cities[i:]
+	}
+	cities = cities[min(q.Offset, len(cities)):]
+
+	size := q.PageSize()
+	if len(cities) > size {
+		cities = cities[:size]
+		res.NextCursor = q.CursorOf(cities[size-1])
+	}
+	res.Cities = cities
+	return res, nil
+}
+
+func (r *CityRepo) MinLongitude(ctx context.Context) (float64, error) {
+	return r.longitudeBound(ctx, func(l, bound float64) bool { return l < bound })
+}
+
+func (r *CityRepo) MaxLongitude(ctx context.Context) (float64, error) {
+	return r.longitudeBound(ctx, func(l, bound float64) bool { return l > bound })
+}
+
+// longitudeBound returns the selected longitude that beats every other one,
+// or zero when no city is selected.
+func (r *CityRepo) longitudeBound(ctx context.Context, beats func(l, bound float64) bool) (float64, error) {
+	if err := r.check(ctx); err != nil {
+		return 0, err
+	}
+
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	cities := r.selected()
+	if len(cities) == 0 {
+		return 0, nil
+	}
+
+	bound := cities[0].Longitude
+	for _, c := range cities[1:] {
+		if beats(c.Longitude, bound) {
+			bound = c.Longitude
+		}
+	}
+	return bound, nil
+}
+
+func (r *CityRepo) GetCitiesInLongitudeGap(ctx context.Context, lMin, lMax float64) (*[]dto.CityDTO, error) {
+	return r.cityDTOs(ctx, func(c city) bool { return c.Longitude >= lMin && c.Longitude < lMax })
+}
+
+func (r *CityRepo) TypeStats(ctx context.Context) (*[]dto.TypeStatsDTO, error) {
+	if err := r.check(ctx); err != nil {
+		return nil, err
+	}
+
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	byType := map[string]*dto.TypeStatsDTO{}
+	sums := map[string][2]int64{}
+	for _, c := range r.selected() {
+		t, ok := byType[c.Type]
+		if !ok {
+			t = &dto.TypeStatsDTO{Type: c.Type, MinPopulation: c.Population, MaxPopulation: c.Population}
+			byType[c.Type] = t
+		}
+		t.Cities++
+		t.MinPopulation = min(t.MinPopulation, c.Population)
+		t.MaxPopulation = max(t.MaxPopulation, c.Population)
+		sum := sums[c.Type]
+		sums[c.Type] = [2]int64{sum[0] + int64(c.Population), sum[1] + int64(c.Childrens)}
+	}
+
+	res := []dto.TypeStatsDTO{}
+	for name, t := range byType {
+		t.AvgPopulation = float64(sums[name][0]) / float64(t.Cities)
+		t.AvgChildrens = float64(sums[name][1]) / float64(t.Cities)
+		res = append(res, *t)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].AvgPopulation != res[j].AvgPopulation {
+			return res[i].AvgPopulation > res[j].AvgPopulation
+		}
+		return res[i].Type < res[j].Type
+	})
+	return &res, nil
+}
+
+func (r *CityRepo) DistrictTotals(ctx context.Context) (*[]dto.DistrictTotalDTO, error) {
+	if err := r.check(ctx); err != nil {
+		return nil, err
+	}
+
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	byDistrict := map[string]*dto.DistrictTotalDTO{}
+	for _, c := range r.selected() {
+		d, ok := byDistrict[c.District]
+		if !ok {
+			d = &dto.DistrictTotalDTO{District: c.District}
+			byDistrict[c.District] = d
+		}
+		d.Cities++
+		d.Population += int64(c.Population)
+		d.Childrens += int64(c.Childrens)
+	}
+
+	res := []dto.DistrictTotalDTO{}
+	for _, d := range byDistrict {
+		res = append(res, *d)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Population != res[j].Population {
+			return res[i].Population > res[j].Population
+		}
+		return res[i].District < res[j].District
+	})
+	return &res, nil
+}
+
+func (r *CityRepo) Histogram(ctx context.Context, column repo.HistogramColumn, buckets int) (*[]dto.HistogramBucketDTO, error) {
+	if buckets <= 0 {
+		return nil, fmt.Errorf("histogram needs at least one bucket, got %d", buckets)
+	}
+
+	var value func(c city) float64
+	switch column {
+	case repo.HistogramLongitude:
+		value = func(c city) float64 { return c.Longitude }
+	case repo.HistogramLatitude:
+		value = func(c city) float64 { return c.Latitude }
+	case repo.HistogramPopulation:
+		value = func(c city) float64 { return float64(c.Population) }
+	default:
+		return nil, fmt.Errorf("unknown histogram column %q", column)
+	}
+
+	if err := r.check(ctx); err != nil {
+		return nil, err
+	}
+
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	cities := r.selected()
+	res := []dto.HistogramBucketDTO{}
+	if len(cities) == 0 {
+		return &res, nil
+	}
+
+	lo, hi := value(cities[0]), value(cities[0])
+	for _, c := range cities[1:] {
+		lo, hi = min(lo, value(c)), max(hi, value(c))
+	}
+
+	res = dto.HistogramBuckets(lo, hi, buckets)
+	for _, c := range cities {
+		b := &res[dto.HistogramBucket(value(c), lo, hi, buckets)]
+		b.Cities++
+		b.Population += int64(c.Population)
+		b.Childrens += int64(c.Childrens)
+	}
+	return &res, nil
+}
+
+func (r *CityRepo) AdminUnits(ctx context.Context, level models.AdminUnitLevel) (*[]dto.AdminUnitDTO, error) {
+	if err := r.check(ctx); err != nil {
+		return nil, err
+	}
+
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	cities := r.selected()
+	res := []dto.AdminUnitDTO{}
+	for _, u := range r.data.units {
+		if u.level != level {
+			continue
+		}
+
+		tree := r.data.subtree(u.id)
+		unit := dto.AdminUnitDTO{ID: u.id, Level: string(u.level), Name: u.name}
+		if u.parentID != 0 {
+			parentID := u.parentID
+			unit.ParentID = &parentID
+		}
+		for _, c := range cities {
+			if tree[c.adminUnitID] {
+				unit.Cities++
+				unit.Population += int64(c.Population)
+				unit.Childrens += int64(c.Childrens)
+			}
+		}
+		if unit.Cities > 0 {
+			res = append(res, unit)
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Name != res[j].Name {
+			return res[i].Name < res[j].Name
+		}
+		return res[i].ID < res[j].ID
+	})
+	return &res, nil
+}
+
+// subtree returns the IDs of the unit with the given ID and of every unit
+// below it. The caller must hold the read lock.
+func (s *store) subtree(id uint) map[uint]bool {
+	res := map[uint]bool{id: true}
+	for grew := true; grew; {
+		grew = false
+		for _, u := range s.units {
+			if res[u.parentID] && !res[u.id] {
+				res[u.id] = true
+				grew = true
+			}
+		}
+	}
+	return res
+}
+
+func (r *CityRepo) CitiesInAdminUnit(ctx context.Context, id uint) (*[]dto.CityDTO, error) {
+	r.data.mu.RLock()
+	tree := r.data.subtree(id)
+	r.data.mu.RUnlock()
+
+	return r.cityDTOs(ctx, func(c city) bool { return tree[c.adminUnitID] })
+}
+
+func (r *CityRepo) CitiesByCode(ctx context.Context, scheme models.CodeScheme, code string) (*[]dto.CityDTO, error) {
+	code = models.NormalizeCode(code)
+	return r.cityDTOs(ctx, func(c city) bool { return cityCode(c.CityDTO, scheme) == code })
+}
+
+func cityCode(c dto.CityDTO, scheme models.CodeScheme) string {
+	if scheme == models.SchemeOKATO {
+		return c.OKATO
+	}
+	return c.OKTMO
+}
+
+func (c districtCodes) code(scheme models.CodeScheme) string {
+	if scheme == models.SchemeOKATO {
+		return c.okato
+	}
+	return c.oktmo
+}
+
+func (r *CityRepo) CodeMismatches(ctx context.Context) (*[]dto.CodeMismatchDTO, error) {
+	if err := r.check(ctx); err != nil {
+		return nil, err
+	}
+
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	cities := r.selected()
+	res := []dto.CodeMismatchDTO{}
+	for _, scheme := range []models.CodeScheme{models.SchemeOKTMO, models.SchemeOKATO} {
+		var mismatches []dto.CodeMismatchDTO
+		for _, c := range cities {
+			code, districtCode := cityCode(c.CityDTO, scheme), r.data.districts[c.District].code(scheme)
+			if code == "" || districtCode == "" || models.SameSubject(code, districtCode) {
+				continue
+			}
+			mismatches = append(mismatches, dto.CodeMismatchDTO{
+				CityID:       c.ID,
+				City:         c.Name,
+				District:     c.District,
+				Scheme:       string(scheme),
+				CityCode:     code,
+				DistrictCode: districtCode,
+			})
+		}
+
+		sort.SliceStable(mismatches, func(i, j int) bool {
+			a, b := mismatches[i], mismatches[j]
+			if a.District != b.District {
+				return a.District < b.District
+			}
+			if a.City != b.City {
+				return a.City < b.City
+			}
+			return a.CityID < b.CityID
+		})
+		res = append(res, mismatches...)
+	}
+	return &res, nil
+}
+
+func (r *CityRepo) PopulationHistory(ctx context.Context, cityID uint) (*[]dto.PopulationObservationDTO, error) {
+	if err := r.check(ctx); err != nil {
+		return nil, err
+	}
+
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	res := []dto.PopulationObservationDTO{}
+	for _, o := range r.data.observations {
+		if o.CityID == cityID {
+			res = append(res, o)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Year < res[j].Year })
+	return &res, nil
+}
+
+// current returns a copy of the repository that reads the current populations.
+func (r *CityRepo) current() *CityRepo {
+	c := *r
+	c.year = 0
+	return &c
+}
+
+func (r *CityRepo) ObservedYears(ctx context.Context) ([]int, error) {
+	if err := r.check(ctx); err != nil {
+		return nil, err
+	}
+
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	selected := map[uint]bool{}
+	for _, c := range r.current().selected() {
+		selected[c.ID] = true
+	}
+
+	seen := map[int]bool{}
+	years := []int{}
+	for _, o := range r.data.observations {
+		if selected[o.CityID] && !seen[o.Year] {
+			seen[o.Year] = true
+			years = append(years, o.Year)
+		}
+	}
+	sort.Ints(years)
+	return years, nil
+}
+
+func (r *CityRepo) GrowthRates(ctx context.Context, fromYear, toYear int) (*[]dto.GrowthRateDTO, error) {
+	if err := r.check(ctx); err != nil {
+		return nil, err
+	}
+
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	res := []dto.GrowthRateDTO{}
+	for _, c := range r.current().selected() {
+		from, ok := r.data.observation(c.ID, fromYear)
+		if !ok || from.Population <= 0 {
+			continue
+		}
+		to, ok := r.data.observation(c.ID, toYear)
+		if !ok {
+			continue
+		}
+
+		g := dto.GrowthRateDTO{
+			CityID:         c.ID,
+			Name:           c.Name,
+			Type:           c.Type,
+			District:       c.District,
+			FromYear:       fromYear,
+			ToYear:         toYear,
+			FromPopulation: from.Population,
+			ToPopulation:   to.Population,
+		}
+		g.Rate, g.AnnualRate = dto.GrowthRates(int64(g.FromPopulation), int64(g.ToPopulation), toYear-fromYear)
+		res = append(res, g)
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Rate != res[j].Rate {
+			return res[i].Rate > res[j].Rate
+		}
+		return res[i].CityID < res[j].CityID
+	})
+	return &res, nil
+}
+
+func (r *CityRepo) DistrictGrowthRates(ctx context.Context, fromYear, toYear int) (*[]dto.DistrictGrowthRateDTO, error) {
+	if err := r.check(ctx); err != nil {
+		return nil, err
+	}
+
+	r.data.mu.RLock()
+	defer r.data.mu.RUnlock()
+
+	byDistrict := map[string]*dto.DistrictGrowthRateDTO{}
+	for _, c := range r.current().selected() {
+		from, ok := r.data.observation(c.ID, fromYear)
+		if !ok {
+			continue
+		}
+		to, ok := r.data.observation(c.ID, toYear)
+		if !ok {
+			continue
+		}
+
+		g, ok := byDistrict[c.District]
+		if !ok {
+			g = &dto.DistrictGrowthRateDTO{District: c.District, FromYear: fromYear, ToYear: toYear}
+			byDistrict[c.District] = g
+		}
+		g.Cities++
+		g.FromPopulation += int64(from.Population)
+		g.ToPopulation += int64(to.Population)
+	}
+
+	res := []dto.DistrictGrowthRateDTO{}
+	for _, g := range byDistrict {
+		if g.FromPopulation <= 0 {
+			continue
+		}
+		g.Rate, g.AnnualRate = dto.GrowthRates(g.FromPopulation, g.ToPopulation, toYear-fromYear)
+		res = append(res, *g)
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Rate != res[j].Rate {
+			return res[i].Rate > res[j].Rate
+		}
+		return res[i].District < res[j].District
+	})
+	return &res, nil
+}
@@ -0,0 +1,402 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"settlements/internal/dto"
+	"settlements/internal/geo"
+	"settlements/internal/models"
+	"settlements/internal/repo"
+)
+
+const seedCSV = `name,type,district,municipality,population,children,latitude,longitude,oktmo,region_oktmo,population_2010,population_2021
+Тверь,город,Тверская область,Тверь,424969,70000,56.86,35.9,28701000001,28000000,403726,424969
+Эммаус,поселок,Тверская область,Калининский,2000,300,56.95,35.7,45000000001,,2200,2000
+Москва,город,Москва,,13010112,2100000,55.75,37.62,,45000000,11503501,13010112
+Анадырь,город,Чукотский АО,,13000,2500,64.73,177.5,,,,
+`
+
+func newSeeded(t *testing.T) *CityRepo {
+	t.Helper()
+
+	r, err := NewFromCSV(strings.NewReader(seedCSV), "vpn")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return r
+}
+
+func allCities(t *testing.T, r interface {
+	All(context.Context) (*[]dto.CityDTO, error)
+}) []dto.CityDTO {
+	t.Helper()
+
+	cities, err := r.All(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return *cities
+}
+
+func TestLoadCSV(t *testing.T) {
+	r := newSeeded(t)
+
+	cities := allCities(t, r)
+	if len(cities) != 4 {
+		t.Fatalf("Expected 4 cities, got %d", len(cities))
+	}
+	if cities[0].Name != "Тверь" || cities[0].Population != 424969 || cities[0].Childrens != 70000 {
+		t.Errorf("Unexpected first city %+v", cities[0])
+	}
+	if cities[0].OKTMO != "28701000001" {
+		t.Errorf("Expected OKTMO 28701000001, got %q", cities[0].OKTMO)
+	}
+
+	years, err := r.ObservedYears(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(years) != 2 || years[0] != 2010 || years[1] != 2021 {
+		t.Errorf("Expected years [2010 2021], got %v", years)
+	}
+}
+
+func TestLoadCSVMissingColumns(t *testing.T) {
+	_, err := NewFromCSV(strings.NewReader("name,type\nТверь,город\n"), "broken")
+	if err == nil || !strings.Contains(err.Error(), "population") {
+		t.Errorf("Expected missing columns to be reported, got %v", err)
+	}
+}
+
+func TestLoadCSVInvalidNumber(t *testing.T) {
+	input := "name,district,population,latitude,longitude\nТверь,Тверская область,many,56.86,35.9\n"
+	_, err := NewFromCSV(strings.NewReader(input), "broken")
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected the line of the invalid population, got %v", err)
+	}
+}
+
+func TestDatasetScope(t *testing.T) {
+	r := New()
+	old := r.AddDataset("2010", time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC))
+	latest := r.AddDataset("2021", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	r.AddCity(old, City{Name: "Тверь", District: "Тверская область", Population: 403726})
+	r.AddCity(latest, City{Name: "Тверь", District: "Тверская область", Population: 424969})
+
+	if got := allCities(t, r)[0].Population; got != 424969 {
+		t.Errorf("Expected the latest dataset by default, got population %d", got)
+	}
+	if got := allCities(t, r.WithDataset(old))[0].Population; got != 403726 {
+		t.Errorf("Expected the selected dataset, got population %d", got)
+	}
+
+	datasets, err := r.Datasets(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if (*datasets)[0].ID != latest {
+		t.Errorf("Expected the latest dataset first, got %+v", *datasets)
+	}
+}
+
+func TestYearScope(t *testing.T) {
+	cities := allCities(t, newSeeded(t).WithYear(2010))
+	if len(cities) != 3 {
+		t.Fatalf("Expected the 3 cities observed in 2010, got %d", len(cities))
+	}
+	if cities[0].Population != 403726 {
+		t.Errorf("Expected the population of 2010, got %d", cities[0].Population)
+	}
+}
+
+func TestLongitudes(t *testing.T) {
+	ctx := context.Background()
+	r := newSeeded(t).WithLongitudeConvention(geo.LongitudeConvention{Center: 180})
+
+	min, err := r.MinLongitude(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	max, err := r.MaxLongitude(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if min != 35.7 || max != 177.5 {
+		t.Errorf("Expected longitudes in [35.7, 177.5], got [%g, %g]", min, max)
+	}
+
+	gap, err := r.GetCitiesInLongitudeGap(ctx, 35, 36)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(*gap) != 2 {
+		t.Errorf("Expected 2 cities in [35, 36), got %d", len(*gap))
+	}
+}
+
+func TestAdminUnits(t *testing.T) {
+	ctx := context.Background()
+	r := newSeeded(t)
+
+	subjects, err := r.AdminUnits(ctx, models.AdminLevelSubject)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(*subjects) != 3 {
+		t.Fatalf("Expected 3 subjects, got %+v", *subjects)
+	}
+	tver := (*subjects)[1]
+	if tver.Name != "Тверская область" || tver.Cities != 2 || tver.Population != 426969 || tver.ParentID != nil {
+		t.Errorf("Expected the subject to count the cities of its municipalities, got %+v", tver)
+	}
+
+	municipalities, err := r.AdminUnits(ctx, models.AdminLevelMunicipality)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if m := *municipalities; len(m) != 2 || m[1].Name != "Тверь" || *m[1].ParentID != tver.ID {
+		t.Errorf("Expected 2 municipalities of the subject, got %+v", m)
+	}
+
+	cities, err := r.CitiesInAdminUnit(ctx, tver.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(*cities) != 2 {
+		t.Errorf("Expected 2 cities in the subject, got %d", len(*cities))
+	}
+}
+
+func TestCodes(t *testing.T) {
+	ctx := context.Background()
+	r := newSeeded(t)
+
+	cities, err := r.CitiesByCode(ctx, models.SchemeOKTMO, "28 701 000 001")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(*cities) != 1 || (*cities)[0].Name != "Тверь" {
+		t.Errorf("Expected Тверь by its OKTMO, got %+v", *cities)
+	}
+
+	mismatches, err := r.CodeMismatches(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if m := *mismatches; len(m) != 1 || m[0].City != "Эммаус" || m[0].DistrictCode != "28000000" {
+		t.Errorf("Expected Эммаус to be flagged, got %+v", m)
+	}
+}
+
+func TestGrowthRates(t *testing.T) {
+	ctx := context.Background()
+	r := newSeeded(t)
+
+	rates, err := r.GrowthRates(ctx, 2010, 2021)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(*rates) != 3 {
+		t.Fatalf("Expected 3 cities observed in both years, got %d", len(*rates))
+	}
+	if g := *rates; g[0].Name != "Москва" || g[2].Name != "Эммаус" || g[2].Rate >= 0 {
+		t.Errorf("Expected the fastest growing first and Эммаус declining, got %+v", g)
+	}
+
+	districts, err := r.DistrictGrowthRates(ctx, 2010, 2021)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	d := *districts
+	if len(d) != 2 || d[1].District != "Тверская область" || d[1].Cities != 2 {
+		t.Fatalf("Expected 2 districts, got %+v", d)
+	}
+	if d[1].FromPopulation != 405926 || d[1].ToPopulation != 426969 {
+		t.Errorf("Expected district sums, got %+v", d[1])
+	}
+}
+
+func TestPopulationHistory(t *testing.T) {
+	r := newSeeded(t)
+	r.AddObservation(dto.PopulationObservationDTO{CityID: 1, Year: 2002, Population: 408903})
+	r.AddObservation(dto.PopulationObservationDTO{CityID: 1, Year: 2010, Population: 403700})
+
+	history, err := r.PopulationHistory(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if h := *history; len(h) != 3 || h[0].Year != 2002 || h[1].Population != 403700 {
+		t.Errorf("Expected 3 observations by year with 2010 replaced, got %+v", h)
+	}
+}
+
+func TestFail(t *testing.T) {
+	r := newSeeded(t)
+	scoped := r.WithYear(2010)
+
+	outage := errors.New("connection refused")
+	r.Fail(outage)
+	if _, err := scoped.All(context.Background()); !errors.Is(err, outage) {
+		t.Errorf("Expected the copies to fail too, got %v", err)
+	}
+
+	r.Fail(nil)
+	if _, err := scoped.All(context.Background()); err != nil {
+		t.Errorf("Expected queries to succeed again, got %v", err)
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := newSeeded(t).All(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestTypeStats(t *testing.T) {
+	stats, err := newSeeded(t).TypeStats(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	s := *stats
+	if len(s) != 2 || s[0].Type != "город" || s[1].Type != "поселок" {
+		t.Fatalf("Expected город then поселок, got %+v", s)
+	}
+	if s[0].Cities != 3 || s[0].MinPopulation != 13000 || s[0].MaxPopulation != 13010112 {
+		t.Errorf("Unexpected город stats %+v", s[0])
+	}
+	if want := float64(424969+13010112+13000) / 3; s[0].AvgPopulation != want {
+		t.Errorf("Expected average population %g, got %g", want, s[0].AvgPopulation)
+	}
+}
+
+func TestDistrictTotals(t *testing.T) {
+	totals, err := newSeeded(t).WithYear(2010).DistrictTotals(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	d := *totals
+	if len(d) != 2 || d[0].District != "Москва" || d[1].Population != 405926 || d[1].Cities != 2 {
+		t.Errorf("Expected the totals of 2010, the most populous first, got %+v", d)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	ctx := context.Background()
+	r := newSeeded(t)
+
+	buckets, err := r.Histogram(ctx, repo.HistogramPopulation, 2)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	b := *buckets
+	if len(b) != 2 || b[0].Cities != 3 || b[1].Cities != 1 || b[1].Population != 13010112 {
+		t.Errorf("Expected Москва alone in the upper half, got %+v", b)
+	}
+	if b[0].Start != 2000 || b[1].End != 13010112 {
+		t.Errorf("Expected buckets over [2000, 13010112], got %+v", b)
+	}
+
+	buckets, err = r.WithLongitudeConvention(geo.Positive).Histogram(ctx, repo.HistogramLongitude, 10)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if b := *buckets; len(b) != 10 || b[0].Cities != 3 || b[9].Cities != 1 {
+		t.Errorf("Expected Анадырь alone in the last bucket, got %+v", b)
+	}
+
+	if _, err := r.Histogram(ctx, "area", 10); err == nil {
+		t.Errorf("Expected an unknown column to be rejected")
+	}
+}
+
+func TestHistogramEmpty(t *testing.T) {
+	buckets, err := New().Histogram(context.Background(), repo.HistogramLatitude, 10)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(*buckets) != 0 {
+		t.Errorf("Expected no buckets without cities, got %+v", *buckets)
+	}
+}
+
+func TestFindCities(t *testing.T) {
+	ctx := context.Background()
+	r := newSeeded(t)
+	minPopulation := 10000
+
+	page, err := r.FindCities(ctx, repo.CityQuery{
+		Type:       "город",
+		Population: repo.IntRange{Min: &minPopulation},
+		Sort:       []repo.CitySort{{Field: repo.SortByPopulation, Desc: true}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if page.Total != 3 || len(page.Cities) != 3 || page.Cities[0].Name != "Москва" || page.Cities[2].Name != "Анадырь" {
+		t.Errorf("Expected 3 cities, the most populous first, got %+v", page)
+	}
+	if page.NextCursor != "" {
+		t.Errorf("Expected no next page, got cursor %q", page.NextCursor)
+	}
+
+	page, err = r.FindCities(ctx, repo.CityQuery{
+		NamePrefix: "тв",
+		BBox:       &repo.BoundingBox{MinLatitude: 56, MaxLatitude: 57, MinLongitude: 35, MaxLongitude: 36},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if page.Total != 1 || page.Cities[0].Name != "Тверь" {
+		t.Errorf("Expected Тверь by name prefix and box, got %+v", page)
+	}
+
+	if _, err := r.FindCities(ctx, repo.CityQuery{Limit: -1}); err == nil {
+		t.Errorf("Expected an invalid query to be rejected")
+	}
+}
+
+func TestFindCitiesPages(t *testing.T) {
+	ctx := context.Background()
+	r := newSeeded(t)
+	q := repo.CityQuery{Sort: []repo.CitySort{{Field: repo.SortByDistrict}}, Limit: 1}
+
+	var byCursor []string
+	for {
+		page, err := r.FindCities(ctx, q)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if page.Total != 4 {
+			t.Fatalf("Expected a total of 4 on every page, got %d", page.Total)
+		}
+		for _, c := range page.Cities {
+			byCursor = append(byCursor, c.Name)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+
+	want := []string{"Москва", "Тверь", "Эммаус", "Анадырь"}
+	if strings.Join(byCursor, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %v by cursor, got %v", want, byCursor)
+	}
+
+	page, err := r.FindCities(ctx, repo.CityQuery{Sort: q.Sort, Limit: 2, Offset: 2})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(page.Cities) != 2 || page.Cities[0].Name != "Эммаус" {
+		t.Errorf("Expected the second page by offset to start with Эммаус, got %+v", page.Cities)
+	}
+}
@@ -0,0 +1,216 @@
+package memory
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"settlements/internal/dto"
+)
+
+// csvColumns maps the accepted header names of every city column to it.
+var csvColumns = map[string]string{
+	"name":         "name",
+	"settlement":   "name",
+	"type":         "type",
+	"district":     "district",
+	"region":       "district",
+	"municipality": "municipality",
+	"population":   "population",
+	"childrens":    "childrens",
+	"children":     "childrens",
+	"latitude":     "latitude",
+	"lat":          "latitude",
+	"longitude":    "longitude",
+	"lon":          "longitude",
+	"oktmo":        "oktmo",
+	"okato":        "okato",
+	"region_oktmo": "district_oktmo",
+	"region_okato": "district_okato",
+}
+
+// requiredCSVColumns must be in the header of a seed file.
+var requiredCSVColumns = []string{"name", "district", "population", "latitude", "longitude"}
+
+// NewFromCSV creates a repository seeded by LoadCSV.
+func NewFromCSV(r io.Reader, label string) (*CityRepo, error) {
+	repo := New()
+	if _, err := repo.LoadCSV(r, label); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// LoadCSV adds the cities of a CSV file to a new dataset with the given label
+// and returns the ID of the dataset. Columns are found by header name, in
+// any case: name, type, district, municipality, population, childrens,
+// latitude and longitude, with the codes oktmo, okato, region_oktmo and
+// region_okato. Columns named population_<year> and childrens_<year> add
+// observations of that year, with the label as their source. Empty numeric
+// values are zero.
+func (r *CityRepo) LoadCSV(in io.Reader, label string) (uint, error) {
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, fmt.Errorf("CSV file is empty")
+		}
+		return 0, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	columns, years, err := bindCSVHeader(header)
+	if err != nil {
+		return 0, err
+	}
+
+	datasetID := r.AddDataset(label, time.Now())
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return datasetID, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read CSV: %w", err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		if err := r.addCSVRecord(datasetID, label, record, columns, years); err != nil {
+			return 0, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+}
+
+// yearColumn is a population_<year> or childrens_<year> column.
+type yearColumn struct {
+	year     int
+	children bool
+}
+
+// bindCSVHeader returns the positions of the city columns and the year columns.
+func bindCSVHeader(header []string) (map[string]int, map[int]yearColumn, error) {
+	columns := map[string]int{}
+	years := map[int]yearColumn{}
+
+	for i, h := range header {
+		name := strings.ToLower(strings.TrimSpace(h))
+		if column, ok := csvColumns[name]; ok {
+			if _, seen := columns[column]; !seen {
+				columns[column] = i
+			}
+			continue
+		}
+
+		prefix, year, ok := strings.Cut(name, "_")
+		if !ok {
+			continue
+		}
+		y, err := strconv.Atoi(year)
+		if err != nil {
+			continue
+		}
+		switch csvColumns[prefix] {
+		case "population":
+			years[i] = yearColumn{year: y}
+		case "childrens":
+			years[i] = yearColumn{year: y, children: true}
+		}
+	}
+
+	var missing []string
+	for _, column := range requiredCSVColumns {
+		if _, ok := columns[column]; !ok {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, nil, fmt.Errorf("required columns not found in header: %s", strings.Join(missing, ", "))
+	}
+	return columns, years, nil
+}
+
+func (r *CityRepo) addCSVRecord(datasetID uint, label string, record []string, columns map[string]int, years map[int]yearColumn) error {
+	get := func(column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var err error
+	parseInt := func(column, v string) int {
+		if v == "" || err != nil {
+			return 0
+		}
+		n, e := strconv.Atoi(v)
+		if e != nil {
+			err = fmt.Errorf("invalid %s %q", column, v)
+		}
+		return n
+	}
+	parseFloat := func(column string) float64 {
+		v := get(column)
+		if v == "" || err != nil {
+			return 0
+		}
+		f, e := strconv.ParseFloat(v, 64)
+		if e != nil {
+			err = fmt.Errorf("invalid %s %q", column, v)
+		}
+		return f
+	}
+
+	c := City{
+		Name:         get("name"),
+		Type:         get("type"),
+		District:     get("district"),
+		Municipality: get("municipality"),
+		Population:   parseInt("population", get("population")),
+		Childrens:    parseInt("childrens", get("childrens")),
+		Latitude:     parseFloat("latitude"),
+		Longitude:    parseFloat("longitude"),
+		OKTMO:        get("oktmo"),
+		OKATO:        get("okato"),
+	}
+
+	observations := map[int]*dto.PopulationObservationDTO{}
+	for i, col := range years {
+		if i >= len(record) || strings.TrimSpace(record[i]) == "" {
+			continue
+		}
+		o, ok := observations[col.year]
+		if !ok {
+			o = &dto.PopulationObservationDTO{Year: col.year, Source: label}
+			observations[col.year] = o
+		}
+		column := fmt.Sprintf("population of %d", col.year)
+		if col.children {
+			column = fmt.Sprintf("childrens of %d", col.year)
+		}
+		n := parseInt(column, strings.TrimSpace(record[i]))
+		if col.children {
+			o.Childrens = n
+		} else {
+			o.Population = n
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	cityID := r.AddCity(datasetID, c)
+	if oktmo, okato := get("district_oktmo"), get("district_okato"); oktmo != "" || okato != "" {
+		r.SetDistrictCodes(c.District, oktmo, okato)
+	}
+	for _, o := range observations {
+		o.CityID = cityID
+		r.AddObservation(*o)
+	}
+	return nil
+}
@@ -1,20 +1,149 @@
 package data_loader
 
 import (
-	"encoding/csv"
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"os"
+	"io"
+	"path/filepath"
+	"settlements/internal/geo"
 	"settlements/internal/models"
-	"strconv"
+	"settlements/internal/service/dedup"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
+	"golang.org/x/sync/errgroup"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
 type DataLoader struct {
-	db *gorm.DB
+	db   *gorm.DB
+	opts Options
+	// conn is the dedicated connection of the running load.
+	// It is nil outside of inTransaction.
+	conn *sql.Conn
+	// dataset is the version the running load writes to.
+	dataset *models.Dataset
+	// types is the type dictionary read at the start of the running load.
+	types typeNames
+	// mu serializes the statements workers issue on the connection of the
+	// running load. Savepoints of different rows must not interleave.
+	mu *sync.Mutex
+	// typeIDs, districtIDs and adminUnitIDs resolve names to IDs for all
+	// workers of the running load. adminUnitIDs is keyed by adminUnitKey.
+	typeIDs      *idCache
+	districtIDs  *idCache
+	adminUnitIDs *idCache
 }
 
+// Mode selects how the loader writes cities to the database.
+type Mode string
+
+const (
+	// ModeRow resolves and inserts every row with its own queries.
+	ModeRow Mode = "row"
+	// ModeBulk caches type and district IDs and writes cities in batches with COPY.
+	ModeBulk Mode = "bulk"
+)
+
+// defaultBatchSize is the number of cities written by a single COPY in bulk mode.
+const defaultBatchSize = 5000
+
+// Options configures a DataLoader.
+type Options struct {
+	Mode      Mode
+	BatchSize int
+	// Label names the dataset version the cities are loaded into. Loading
+	// into an existing label updates it; a new label keeps the other versions
+	// untouched. When empty, the label is derived from the source name.
+	Label string
+	// Replace deletes the cities of the dataset the source no longer holds,
+	// with their population observations, inside the load transaction, so
+	// readers switch from the old data to the new at commit. The cities the
+	// source still holds are upserted as usual and keep the observations of
+	// other years.
+	Replace bool
+	// MaxErrorRate aborts and rolls back the load when the share of failed
+	// rows exceeds it. Zero disables the check.
+	MaxErrorRate float64
+	// Profile maps source columns to settlement fields.
+	// The profile named DefaultProfileName is used when it is nil.
+	Profile *Profile
+	// Rules are checked against every row before it is persisted, in order.
+	// DefaultRules is used when it is nil.
+	Rules []Rule
+	// Longitude is the convention longitudes are stored in. It is recorded
+	// on the dataset; the zero value is geo.Signed.
+	Longitude geo.LongitudeConvention
+	// Workers is the number of goroutines parsing, validating and persisting
+	// rows. The load stays one transaction on one connection, so workers
+	// take turns issuing statements; the parallel part is everything else.
+	Workers int
+	// Encoding is the character encoding of the source. EncodingAuto detects
+	// it; a UTF-8 byte order mark is recognised either way.
+	Encoding Encoding
+	// Delimiter separates the fields of a CSV source. Zero detects it from the header.
+	Delimiter rune
+	// Format is the format of the source. FormatAuto picks it by the extension
+	// of the source name, falling back to CSV.
+	Format Format
+	// Sheet is the worksheet an XLSX source is read from. Empty selects the first sheet.
+	Sheet string
+	// HeaderOffset is the number of rows above the header of an XLSX source,
+	// such as the title rows of a statistical table.
+	HeaderOffset int
+	// Dedup, when set, looks for likely duplicates within every district of
+	// the dataset once the rows are written and merges them, summing their
+	// population and children. A dry run only lists the duplicates among
+	// the cities the dataset already holds.
+	Dedup *dedup.Options
+	// Year is the census year the source counts the population in. When set,
+	// every loaded city also gets an observation of that year, so loading
+	// the censuses of several years into one dataset builds the population
+	// series of its cities. The cities themselves keep the population of the
+	// latest year loaded. Zero records no observations.
+	Year int
+	// DryRun parses, validates and resolves the rows against the database in
+	// a read-only transaction. The report counts what the load would do and
+	// its Preview lists the districts and types it would create.
+	DryRun bool
+}
+
+// ErrErrorRateExceeded is returned when a load is rolled back because too many rows failed.
+var ErrErrorRateExceeded = errors.New("error rate exceeded")
+
+// outcome is what persisting a row did to the database.
+type outcome int
+
+const (
+	outcomeCreated outcome = iota
+	outcomeUpdated
+	outcomeUnchanged
+)
+
+// DefaultOptions returns the options used by New.
+func DefaultOptions() Options {
+	return Options{
+		Mode:      ModeRow,
+		BatchSize: defaultBatchSize,
+	}
+}
+
+// ParseMode converts a command-line value into a Mode.
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case ModeRow, ModeBulk:
+		return Mode(s), nil
+	}
+	return "", fmt.Errorf("unknown load mode %q (want %q or %q)", s, ModeRow, ModeBulk)
+}
+
+// settlementsTypes is the built-in type dictionary. It seeds the dictionary
+// stored in the database and is used when reading files without a database.
 var settlementsTypes = map[string]string{
 	"г":             "город",
 	"гп":            "городской поселок",
@@ -57,127 +186,685 @@ var settlementsTypes = map[string]string{
 }
 
 func New(db *gorm.DB) *DataLoader {
-	return &DataLoader{db: db}
+	return NewWithOptions(db, DefaultOptions())
+}
+
+// NewWithOptions creates a DataLoader with the given options.
+// Invalid values fall back to their defaults.
+func NewWithOptions(db *gorm.DB, opts Options) *DataLoader {
+	return &DataLoader{db: db, opts: opts.withDefaults()}
+}
+
+// withDefaults replaces unset and invalid values with their defaults.
+func (opts Options) withDefaults() Options {
+	if opts.Mode == "" {
+		opts.Mode = ModeRow
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if opts.Profile == nil {
+		opts.Profile = builtinProfiles[DefaultProfileName]
+	}
+	if opts.Rules == nil {
+		opts.Rules = DefaultRules()
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	return opts
+}
+
+// LoadCityData streams the dataset file at filePath into the database.
+// A path of "-" reads from stdin; gzip-compressed input is detected automatically.
+// The format is picked by the extension of filePath unless Options.Format is set.
+func (dl *DataLoader) LoadCityData(filePath string) (*ImportReport, error) {
+	src, err := OpenSource(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	return dl.Load(context.Background(), filePath, src)
+}
+
+// Load streams records from r through the parse, validate and persist
+// stages one row at a time, so memory use does not grow with the input size.
+// The whole load runs in one transaction: readers see either the data from
+// before the load or the complete new data, never a partial load.
+// The report is returned even when the load fails and is rolled back.
+// name identifies the source in the dataset record and, unless
+// Options.Format is set, selects the format by its extension.
+func (dl *DataLoader) Load(ctx context.Context, name string, r io.Reader) (*ImportReport, error) {
+	report := NewImportReport()
+	if dl.opts.DryRun {
+		report.DryRun = true
+		report.Preview = &Preview{NewDistricts: []string{}, NewTypes: []string{}, NewAdminUnits: []string{}}
+	}
+
+	src, input, err := openSource(r, dl.opts.withFormatOf(name), report)
+	if err != nil {
+		return report, err
+	}
+	defer input.Close()
+
+	err = dl.inTransaction(ctx, func(tl *DataLoader) error {
+		if err := tl.openDataset(name); err != nil {
+			return err
+		}
+		report.DatasetID = tl.dataset.ID
+		report.Dataset = tl.dataset.Label
+		report.LongitudeConvention = tl.dataset.LongitudeConvention
+		report.Year = dl.opts.Year
+		if report.Preview != nil {
+			report.Preview.NewDataset = tl.dataset.ID == 0
+		}
+
+		if err := tl.loadTypes(); err != nil {
+			return err
+		}
+		tl.newIDCaches(report)
+
+		if dl.opts.Replace && !dl.opts.DryRun {
+			if err := tl.trackLoadedCities(); err != nil {
+				return err
+			}
+		}
+
+		if err := tl.run(ctx, src, report); err != nil {
+			return err
+		}
+
+		if dl.opts.Replace && !dl.opts.DryRun {
+			if err := tl.deleteUnloadedCities(report); err != nil {
+				return err
+			}
+		}
+
+		if tl.observes() {
+			if err := tl.syncLatestObservations(); err != nil {
+				return err
+			}
+		}
+
+		if dl.opts.Dedup != nil {
+			if err := tl.dedup(report); err != nil {
+				return err
+			}
+		}
+
+		if dl.opts.MaxErrorRate > 0 && report.ErrorRate() > dl.opts.MaxErrorRate {
+			return fmt.Errorf("%w: %s (limit %.2f%%), load rolled back",
+				ErrErrorRateExceeded, report.Summary(), dl.opts.MaxErrorRate*100)
+		}
+		return nil
+	})
+	if err != nil {
+		report.RolledBack = true
+	}
+
+	return report, err
+}
+
+// dedup finds the duplicates of the dataset and, unless this is a dry run,
+// merges them.
+func (dl *DataLoader) dedup(report *ImportReport) error {
+	if dl.dataset.ID == 0 {
+		report.Dedup = dedup.Find(nil, *dl.opts.Dedup)
+		return nil
+	}
+
+	store := dedup.NewStore(dl.db)
+	cities, err := store.Cities(dl.dataset.ID)
+	if err != nil {
+		return err
+	}
+
+	report.Dedup = dedup.Find(cities, *dl.opts.Dedup)
+	if dl.opts.DryRun || len(report.Dedup.Groups) == 0 {
+		return nil
+	}
+
+	report.Deduplicated, err = store.Apply(report.Dedup.Groups)
+	return err
 }
 
-func (dl *DataLoader) LoadCityData(filePath string) error {
-	file, err := os.Open(filePath)
+// inTransaction runs fn with a DataLoader bound to one transaction on a
+// dedicated connection. COPY needs the raw connection, so the ORM queries
+// are issued on the same one to share the transaction with it.
+func (dl *DataLoader) inTransaction(ctx context.Context, fn func(tl *DataLoader) error) error {
+	sqlDB, err := dl.db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database handle: %w", err)
+	}
+
+	conn, err := sqlDB.Conn(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+		return fmt.Errorf("failed to acquire connection: %w", err)
 	}
-	defer file.Close()
+	defer conn.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	connDB, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{Logger: dl.db.Logger})
 	if err != nil {
-		return fmt.Errorf("failed to read CSV: %w", err)
+		return fmt.Errorf("failed to open load session: %w", err)
 	}
 
-	if len(records) < 2 {
-		return fmt.Errorf("CSV file is empty or has no data rows")
+	return connDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&DataLoader{db: tx, opts: dl.opts, conn: conn, mu: &sync.Mutex{}})
+	}, &sql.TxOptions{ReadOnly: dl.opts.DryRun})
+}
+
+// withDB returns a copy of the loader that issues its queries on db.
+func (dl *DataLoader) withDB(db *gorm.DB) *DataLoader {
+	c := *dl
+	c.db = db
+	return &c
+}
+
+// openDataset finds or creates the dataset the load writes to and stamps it
+// with the source and load time. A dry run only looks the dataset up; a
+// dataset that does not exist yet is left with a zero ID.
+func (dl *DataLoader) openDataset(source string) error {
+	label := dl.opts.Label
+	if label == "" {
+		label = DatasetLabel(source)
 	}
 
-	for i := 1; i < len(records); i++ {
-		record := records[i]
-		if len(record) < 14 {
-			fmt.Printf("Skipping row %d: insufficient columns\n", i)
-			continue
+	dataset := models.Dataset{Label: label}
+	if dl.opts.DryRun {
+		if err := dl.db.Where("label = ?", label).Limit(1).Find(&dataset).Error; err != nil {
+			return fmt.Errorf("failed to find dataset: %w", err)
 		}
+		dataset.LongitudeConvention = dl.opts.Longitude.String()
+		dl.dataset = &dataset
+		return nil
+	}
 
-		err := dl.processRow(record)
-		if err != nil {
-			fmt.Printf("Error processing row %d: %v\n", i, err)
-			continue
+	if err := dl.db.Where("label = ?", label).FirstOrCreate(&dataset).Error; err != nil {
+		return fmt.Errorf("failed to create/find dataset: %w", err)
+	}
+
+	dataset.SourceFile = source
+	dataset.LoadedAt = time.Now()
+	dataset.LongitudeConvention = dl.opts.Longitude.String()
+	if err := dl.db.Save(&dataset).Error; err != nil {
+		return fmt.Errorf("failed to save dataset: %w", err)
+	}
+
+	dl.dataset = &dataset
+	return nil
+}
+
+// loadTypes reads the type dictionary, seeding it on first use. A dry run
+// does not seed and falls back to the built-in dictionary instead.
+func (dl *DataLoader) loadTypes() error {
+	dictionary := NewTypeDictionary(dl.db)
+	if !dl.opts.DryRun {
+		if err := dictionary.Seed(); err != nil {
+			return err
 		}
 	}
 
+	types, err := dictionary.Names()
+	if err != nil {
+		return err
+	}
+	if len(types) == 0 {
+		types = settlementsTypes
+	}
+	dl.types = types
 	return nil
 }
 
-func (dl *DataLoader) processRow(record []string) error {
-	// CSV columns: Region, Settlement, Type, Population, Children, Latitude, Longitude
-	region := strings.TrimSpace(record[1])
-	settlement := strings.TrimSpace(record[3])
-	typeShort := strings.TrimSpace(record[4])
-	populationStr := strings.TrimSpace(record[5])
-	childrenStr := strings.TrimSpace(record[6])
-	latitudeStr := strings.TrimSpace(record[9])
-	longitudeStr := strings.TrimSpace(record[10])
+// DatasetLabel derives the default dataset label from a source path:
+// the file name without directories and extensions.
+func DatasetLabel(source string) string {
+	if source == "" || source == "-" {
+		return "stdin"
+	}
+
+	name := filepath.Base(source)
+	if i := strings.Index(name, "."); i > 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// run executes the load pipeline. It must be called inside inTransaction.
+// Parsing, validation and persisting run in opts.Workers goroutines each;
+// valid rows are sharded to the persist workers by natural key.
+func (dl *DataLoader) run(ctx context.Context, src recordSource, report *ImportReport) error {
+	g, ctx := errgroup.WithContext(ctx)
+	workers := dl.opts.Workers
 
-	population, _ := strconv.Atoi(populationStr)
-	childrens, _ := strconv.Atoi(childrenStr)
-	latitude, _ := strconv.ParseFloat(latitudeStr, 64)
-	longitude, _ := strconv.ParseFloat(longitudeStr, 64)
+	records := make(chan sourceRecord, pipelineBuffer)
+	rows := make(chan settlementRow, pipelineBuffer)
+	valid := make(chan settlementRow, pipelineBuffer)
+	shards := make([]chan settlementRow, workers)
+	for i := range shards {
+		shards[i] = make(chan settlementRow, pipelineBuffer)
+	}
+
+	var stored map[naturalKey]cityValues
+	if dl.opts.DryRun {
+		var err error
+		if stored, err = dl.storedCities(); err != nil {
+			return err
+		}
+		// The plan writers take the cities the source holds off again
+		if dl.opts.Replace {
+			report.Preview.Replaced = int64(len(stored))
+		}
+	}
 
-	if population == 0 {
+	g.Go(func() error {
+		defer close(records)
+		return readRecords(ctx, src, records)
+	})
+	runStage(g, workers, func() { close(rows) }, func() error {
+		return parseRecords(ctx, records, rows, src.mapping(), dl.types, report)
+	})
+	runStage(g, workers, func() { close(valid) }, func() error {
+		return validateRows(ctx, rows, valid, dl.opts.Rules, report)
+	})
+	codes := districtCodes{}
+	g.Go(func() error {
+		defer func() {
+			for _, shard := range shards {
+				close(shard)
+			}
+		}()
+		for row := range valid {
+			codes.add(row)
+			select {
+			case shards[shardOf(row, workers)] <- row:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
 		return nil
+	})
+	for _, shard := range shards {
+		g.Go(func() error {
+			return dl.persist(ctx, shard, stored, report)
+		})
+	}
+
+	err := g.Wait()
+	if report.Preview != nil {
+		sort.Strings(report.Preview.NewDistricts)
+		sort.Strings(report.Preview.NewTypes)
+		sort.Strings(report.Preview.NewAdminUnits)
+	}
+	if err != nil || dl.opts.DryRun {
+		return err
+	}
+	return dl.saveDistrictCodes(codes)
+}
+
+// persist writes the rows of one shard.
+func (dl *DataLoader) persist(ctx context.Context, in <-chan settlementRow, stored map[naturalKey]cityValues, report *ImportReport) error {
+	w := dl.newWriter(report, stored)
+
+	// latest is the last line written per key. Parallel parsing may deliver
+	// a repeated key out of order; the later line wins, as it does when the
+	// rows arrive in order, and the earlier one counts as overwritten.
+	latest := map[naturalKey]int{}
+
+	for row := range in {
+		if !isMergedRow(row) {
+			key := rowKey(row)
+			if row.line < latest[key] {
+				report.record(outcomeUpdated)
+				continue
+			}
+			latest[key] = row.line
+		}
+
+		if err := w.write(ctx, row); err != nil {
+			report.fail(row.line, ReasonPersistFailed, err.Error(), row.raw)
+		}
+	}
+	return w.flush(ctx)
+}
+
+// locked runs fn while holding the statement lock of the running load.
+func (dl *DataLoader) locked(fn func() error) error {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	return fn()
+}
+
+// newIDCaches sets up the type and district lookups shared by the workers.
+// A load finds or creates every name in its own savepoint, so a row that
+// fails later cannot roll back an ID other rows already use. A dry run only
+// looks names up and lists the missing ones in the preview.
+func (dl *DataLoader) newIDCaches(report *ImportReport) {
+	if dl.opts.DryRun {
+		dl.typeIDs = newIDCache(func(name string) (uint, error) {
+			return dl.lookupID(&models.Type{}, name, &report.Preview.NewTypes)
+		})
+		dl.districtIDs = newIDCache(func(name string) (uint, error) {
+			return dl.lookupID(&models.District{}, name, &report.Preview.NewDistricts)
+		})
+		dl.adminUnitIDs = newIDCache(func(key string) (uint, error) {
+			return dl.resolveAdminUnit(key, func(parentID *uint, level models.AdminUnitLevel, name string) (uint, error) {
+				return dl.lookupAdminUnit(parentID, level, name, &report.Preview.NewAdminUnits)
+			})
+		})
+		return
+	}
+
+	dl.typeIDs = newIDCache(func(name string) (uint, error) {
+		var id uint
+		err := dl.locked(func() error {
+			return dl.db.Transaction(func(tx *gorm.DB) error {
+				typeM, err := dl.withDB(tx).findOrCreateType(name)
+				id = typeM.ID
+				return err
+			})
+		})
+		return id, err
+	})
+	dl.districtIDs = newIDCache(func(name string) (uint, error) {
+		var id uint
+		err := dl.locked(func() error {
+			return dl.db.Transaction(func(tx *gorm.DB) error {
+				district, err := dl.withDB(tx).findOrCreateDistrict(name)
+				id = district.ID
+				return err
+			})
+		})
+		return id, err
+	})
+	dl.adminUnitIDs = newIDCache(func(key string) (uint, error) {
+		return dl.resolveAdminUnit(key, func(parentID *uint, level models.AdminUnitLevel, name string) (uint, error) {
+			var id uint
+			err := dl.locked(func() error {
+				return dl.db.Transaction(func(tx *gorm.DB) error {
+					unit, err := dl.withDB(tx).findOrCreateAdminUnit(parentID, level, name)
+					id = unit.ID
+					return err
+				})
+			})
+			return id, err
+		})
+	})
+}
+
+// adminKeySep separates the subject and the municipality in adminUnitKey.
+const adminKeySep = "\x00"
+
+// adminUnitKey names the lowest administrative unit of row: its municipality
+// or, when the row has none, its subject. A federal city is a subject itself,
+// so its rows are linked to the subject whatever their municipality.
+func adminUnitKey(row settlementRow) string {
+	if isMergedRow(row) {
+		return row.region + adminKeySep
+	}
+	return row.region + adminKeySep + row.municipality
+}
+
+// resolveAdminUnit returns the ID of the unit named by key with find. The
+// subject of a municipality is resolved first, through the shared cache.
+func (dl *DataLoader) resolveAdminUnit(key string, find func(parentID *uint, level models.AdminUnitLevel, name string) (uint, error)) (uint, error) {
+	subject, municipality, _ := strings.Cut(key, adminKeySep)
+	if municipality == "" {
+		return find(nil, models.AdminLevelSubject, subject)
+	}
+
+	parentID, err := dl.adminUnitIDs.get(subject + adminKeySep)
+	if err != nil {
+		return 0, err
+	}
+	return find(&parentID, models.AdminLevelMunicipality, municipality)
+}
+
+// lookupAdminUnit returns the ID of an existing unit, or zero when there is
+// none, in which case the unit is added to missing. A unit whose parent does
+// not exist yet is missing as well.
+func (dl *DataLoader) lookupAdminUnit(parentID *uint, level models.AdminUnitLevel, name string, missing *[]string) (uint, error) {
+	var ids []uint
+	if parentID == nil || *parentID != 0 {
+		err := dl.locked(func() error {
+			return adminUnitScope(dl.db, parentID, level, name).Order("id").Limit(1).Pluck("id", &ids).Error
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to resolve %s %q: %w", level, name, err)
+		}
+	}
+
+	if len(ids) == 0 {
+		dl.locked(func() error {
+			*missing = append(*missing, fmt.Sprintf("%s %s", level, name))
+			return nil
+		})
+		return 0, nil
+	}
+	return ids[0], nil
+}
+
+// lookupID returns the ID of the row of model named name, or zero when there
+// is none, in which case the name is added to missing.
+func (dl *DataLoader) lookupID(model any, name string, missing *[]string) (uint, error) {
+	var ids []uint
+	err := dl.locked(func() error {
+		return dl.db.Model(model).Where("name = ?", name).Order("id").Limit(1).Pluck("id", &ids).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve %q: %w", name, err)
+	}
+
+	if len(ids) == 0 {
+		dl.locked(func() error {
+			*missing = append(*missing, name)
+			return nil
+		})
+		return 0, nil
+	}
+	return ids[0], nil
+}
+
+// processRow resolves the type and district of row and upserts its city in
+// a savepoint, so a failed row does not abort the surrounding load transaction.
+func (dl *DataLoader) processRow(row settlementRow) (outcome, error) {
+	typeID, err := dl.typeIDs.get(row.typeName)
+	if err != nil {
+		return 0, err
+	}
+
+	districtID, err := dl.districtIDs.get(row.region)
+	if err != nil {
+		return 0, err
+	}
+
+	adminUnitID, err := dl.adminUnitIDs.get(adminUnitKey(row))
+	if err != nil {
+		return 0, err
 	}
 
-	if longitude < 0 {
-		longitude = 180 - longitude
+	city := dl.newCity(row, typeID, districtID, adminUnitID)
+
+	var res outcome
+	err = dl.locked(func() error {
+		return dl.db.Transaction(func(tx *gorm.DB) error {
+			txl := dl.withDB(tx)
+			var err error
+			if res, err = txl.upsertCity(&city); err != nil {
+				return err
+			}
+			if dl.opts.Replace {
+				if err := txl.markLoadedCity(&city); err != nil {
+					return err
+				}
+			}
+			if !dl.observes() {
+				return nil
+			}
+			return txl.observeCity(&city)
+		})
+	})
+	return res, err
+}
+
+// upsertCity inserts the city or updates the one with the same natural key.
+func (dl *DataLoader) upsertCity(city *models.City) (outcome, error) {
+	var inserted []bool
+	err := dl.db.Raw(upsertCitySQL,
+		city.DatasetID, city.Name, city.TypeID, city.DistrictID, city.Population, city.Childrens,
+		city.Latitude, city.Longitude, city.LatitudeKey, city.LongitudeKey, city.AdminUnitID, city.OKTMO, city.OKATO,
+	).Scan(&inserted).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to upsert city: %w", err)
 	}
 
-	typ := ""
-	v, ok := settlementsTypes[typeShort]
-	if ok {
-		typ = v
-	} else {
-		typ = typeShort
+	switch {
+	case len(inserted) == 0:
+		return outcomeUnchanged, nil
+	case inserted[0]:
+		return outcomeCreated, nil
+	default:
+		return outcomeUpdated, nil
 	}
+}
 
+func (dl *DataLoader) findOrCreateType(name string) (models.Type, error) {
 	var typeM models.Type
-	err := dl.db.Where("name=?", typ).FirstOrCreate(&typeM, models.Type{Name: typ}).Error
+	err := dl.db.Where("name=?", name).FirstOrCreate(&typeM, models.Type{Name: name}).Error
 	if err != nil {
-		return fmt.Errorf("failed to create/find type: %w", err)
+		return typeM, fmt.Errorf("failed to create/find type: %w", err)
 	}
+	return typeM, nil
+}
 
+func (dl *DataLoader) findOrCreateDistrict(name string) (models.District, error) {
 	var district models.District
-	err = dl.db.Where("name=?", region).FirstOrCreate(&district, models.District{Name: region}).Error
-	if err != nil {
-		return fmt.Errorf("failed to create/find district: %w", err)
-	}
-
-	if region == settlement {
-		var city models.City
-		err = dl.db.Where("name=? AND latitude=? AND longitude=?", settlement, latitude, longitude).FirstOrCreate(
-			&city,
-			models.City{
-				Name:       settlement,
-				TypeID:     typeM.ID,
-				DistrictID: district.ID,
-				Population: 0,
-				Childrens:  0,
-				Latitude:   latitude,
-				Longitude:  longitude,
-			}).Error
-		if err != nil {
-			return fmt.Errorf("failed to create/find city: %w", err)
+	err := dl.db.Where("name=?", name).FirstOrCreate(&district, models.District{Name: name}).Error
+	if err != nil {
+		return district, fmt.Errorf("failed to create/find district: %w", err)
+	}
+	return district, nil
+}
+
+// districtCodes collects the region codes rows give for their districts, by
+// district name and scheme. Rows of one region that disagree are resolved
+// by line: the code of the earliest row is kept.
+type districtCodes map[string]map[models.CodeScheme]lineCode
+
+// lineCode is a code with the line it was read from.
+type lineCode struct {
+	line int
+	code string
+}
+
+// add records the well-formed region codes of row.
+func (c districtCodes) add(row settlementRow) {
+	set := func(scheme models.CodeScheme, code string) {
+		if !scheme.Valid(code) {
+			return
+		}
+		if c[row.region] == nil {
+			c[row.region] = map[models.CodeScheme]lineCode{}
+		}
+		if prev, ok := c[row.region][scheme]; !ok || row.line < prev.line {
+			c[row.region][scheme] = lineCode{line: row.line, code: code}
+		}
+	}
+
+	set(models.SchemeOKTMO, row.regionOKTMO)
+	set(models.SchemeOKATO, row.regionOKATO)
+}
+
+// saveDistrictCodes stores the collected region codes on the districts.
+// Codes the source does not give are left as they are.
+func (dl *DataLoader) saveDistrictCodes(codes districtCodes) error {
+	for name, byScheme := range codes {
+		updates := map[string]any{}
+		for scheme, c := range byScheme {
+			updates[string(scheme)] = c.code
 		}
-		city.Population += population
-		city.Childrens += childrens
 
-		err = dl.db.Save(&city).Error
+		err := dl.db.Model(&models.District{}).Where("name = ?", name).Updates(updates).Error
 		if err != nil {
-			return fmt.Errorf("failed to save updated city: %w", err)
+			return fmt.Errorf("failed to save codes of district %q: %w", name, err)
 		}
+	}
+	return nil
+}
 
-		return nil
+func (dl *DataLoader) findOrCreateAdminUnit(parentID *uint, level models.AdminUnitLevel, name string) (models.AdminUnit, error) {
+	var unit models.AdminUnit
+	err := adminUnitScope(dl.db, parentID, level, name).
+		FirstOrCreate(&unit, models.AdminUnit{ParentID: parentID, Level: level, Name: name}).Error
+	if err != nil {
+		return unit, fmt.Errorf("failed to create/find %s: %w", level, err)
 	}
+	return unit, nil
+}
 
-	city := models.City{
-		Name:       settlement,
-		TypeID:     typeM.ID,
-		DistrictID: district.ID,
-		Population: population,
-		Childrens:  childrens,
-		Latitude:   latitude,
-		Longitude:  longitude,
+// adminUnitScope selects the unit of the given level and name under parentID,
+// or among the roots when parentID is nil.
+func adminUnitScope(db *gorm.DB, parentID *uint, level models.AdminUnitLevel, name string) *gorm.DB {
+	q := db.Model(&models.AdminUnit{}).Where("level = ? AND name = ?", level, name)
+	if parentID == nil {
+		return q.Where("parent_id IS NULL")
 	}
+	return q.Where("parent_id = ?", *parentID)
+}
 
-	err = dl.db.Create(&city).Error
-	if err != nil {
-		return fmt.Errorf("failed to create city: %w", err)
+// isMergedRow reports whether the row is part of a federal city that has to be merged.
+func isMergedRow(row settlementRow) bool {
+	return row.region == row.settlement
+}
+
+// naturalKey identifies a city before its type and district are resolved.
+type naturalKey struct {
+	region       string
+	settlement   string
+	typeName     string
+	latitudeKey  int64
+	longitudeKey int64
+}
+
+func rowKey(row settlementRow) naturalKey {
+	return naturalKey{
+		region:       row.region,
+		settlement:   row.settlement,
+		typeName:     row.typeName,
+		latitudeKey:  models.CoordinateKey(row.latitude),
+		longitudeKey: models.LongitudeKey(row.longitude),
 	}
+}
 
-	return nil
+// newCity builds the city of row in the dataset being loaded, with its
+// longitude in the configured convention.
+func (dl *DataLoader) newCity(row settlementRow, typeID, districtID, adminUnitID uint) models.City {
+	city := models.City{
+		DatasetID:  dl.dataset.ID,
+		Name:       row.settlement,
+		TypeID:     typeID,
+		DistrictID: districtID,
+		Population: row.population,
+		Childrens:  row.childrens,
+		Latitude:   row.latitude,
+		Longitude:  dl.opts.Longitude.Normalize(row.longitude),
+	}
+	if adminUnitID != 0 {
+		city.AdminUnitID = &adminUnitID
+	}
+	city.OKTMO, city.OKATO = validCodes(row.oktmo, row.okato)
+	city.UpdateCoordinateKeys()
+	return city
+}
+
+// validCodes returns the OKTMO and OKATO codes that are well-formed,
+// leaving the others empty.
+func validCodes(oktmo, okato string) (string, string) {
+	if !models.SchemeOKTMO.Valid(oktmo) {
+		oktmo = ""
+	}
+	if !models.SchemeOKATO.Valid(okato) {
+		okato = ""
+	}
+	return oktmo, okato
 }
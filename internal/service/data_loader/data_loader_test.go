@@ -1,6 +1,7 @@
 package data_loader
 
 import (
+	"settlements/internal/models"
 	"testing"
 )
 
@@ -149,3 +150,203 @@ func TestMapValueUniqueness(t *testing.T) {
 		t.Errorf("Unexpected duplicate entries in settlementsTypes")
 	}
 }
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Mode
+		wantErr bool
+	}{
+		{"row", ModeRow, false},
+		{"bulk", ModeBulk, false},
+		{"copy", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseMode(test.value)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseMode(%q): unexpected error state %v", test.value, err)
+		}
+		if got != test.want {
+			t.Errorf("ParseMode(%q): expected %q, got %q", test.value, test.want, got)
+		}
+	}
+}
+
+func TestNewWithOptionsDefaults(t *testing.T) {
+	dl := NewWithOptions(nil, Options{})
+
+	if dl.opts.Mode != ModeRow {
+		t.Errorf("Expected default mode %q, got %q", ModeRow, dl.opts.Mode)
+	}
+
+	if dl.opts.BatchSize != defaultBatchSize {
+		t.Errorf("Expected default batch size %d, got %d", defaultBatchSize, dl.opts.BatchSize)
+	}
+}
+
+func TestMergeBufferSumsFederalCityRows(t *testing.T) {
+	b := newMergeBuffer()
+
+	rows := []settlementRow{
+		{line: 2, region: "Москва", settlement: "Москва", typeShort: "г", population: 1000, childrens: 100, latitude: 55.75, longitude: 37.62},
+		{line: 3, region: "Москва", settlement: "Москва", typeShort: "г", population: 2000, childrens: 300, latitude: 55.75, longitude: 37.62},
+		{line: 4, region: "Севастополь", settlement: "Севастополь", typeShort: "г", population: 500, childrens: 50, latitude: 44.6, longitude: 33.5},
+	}
+
+	folded := 0
+	for _, row := range rows {
+		if b.add(row) {
+			folded++
+		}
+	}
+
+	if folded != 1 {
+		t.Errorf("Expected 1 folded row, got %d", folded)
+	}
+
+	if len(b.order) != 2 {
+		t.Fatalf("Expected 2 buffered cities, got %d", len(b.order))
+	}
+
+	moscow := b.rows[b.order[0]]
+	if moscow.population != 3000 || moscow.childrens != 400 {
+		t.Errorf("Expected summed population 3000/400, got %d/%d", moscow.population, moscow.childrens)
+	}
+}
+
+func TestRowKeyRoundsCoordinates(t *testing.T) {
+	a := rowKey(settlementRow{region: "R", settlement: "S", typeShort: "д", typeName: "деревня", latitude: 56.000001, longitude: 35.000001})
+	b := rowKey(settlementRow{region: "R", settlement: "S", typeShort: "д", typeName: "деревня", latitude: 56.000004, longitude: 34.999996})
+
+	if a != b {
+		t.Errorf("Expected equal keys for coordinates within rounding, got %+v and %+v", a, b)
+	}
+
+	if a.typeName != "деревня" {
+		t.Errorf("Expected expanded type name in key, got %s", a.typeName)
+	}
+}
+
+func TestDatasetLabel(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"datasets/dataset.csv", "dataset"},
+		{"/data/census-2010.csv.gz", "census-2010"},
+		{"-", "stdin"},
+		{"", "stdin"},
+		{".hidden", ".hidden"},
+	}
+
+	for _, test := range tests {
+		if got := DatasetLabel(test.source); got != test.expected {
+			t.Errorf("DatasetLabel(%q): expected %q, got %q", test.source, test.expected, got)
+		}
+	}
+}
+
+func TestTypeNamesExpand(t *testing.T) {
+	types := typeNames{"пгт": "поселок городского типа", "аул": "аул"}
+
+	if name, ok := types.expand("пгт"); !ok || name != "поселок городского типа" {
+		t.Errorf("Expected known 'поселок городского типа', got %q/%v", name, ok)
+	}
+
+	if name, ok := types.expand("xyz"); ok || name != "xyz" {
+		t.Errorf("Expected unknown abbreviation kept as is, got %q/%v", name, ok)
+	}
+}
+
+func TestAdminUnitKey(t *testing.T) {
+	row := settlementRow{region: "Тверская область", municipality: "Калининский", settlement: "Эммаус"}
+	if key := adminUnitKey(row); key != "Тверская область\x00Калининский" {
+		t.Errorf("Expected the municipality key, got %q", key)
+	}
+
+	capital := settlementRow{region: "Москва", municipality: "Зеленоград", settlement: "Москва"}
+	if key := adminUnitKey(capital); key != "Москва\x00" {
+		t.Errorf("Expected a federal city to be keyed by its subject, got %q", key)
+	}
+}
+
+func TestResolveAdminUnit(t *testing.T) {
+	type call struct {
+		parent uint
+		level  models.AdminUnitLevel
+		name   string
+	}
+	var calls []call
+	ids := map[string]uint{"Тверская область": 1, "Калининский": 2}
+
+	dl := &DataLoader{}
+	find := func(parentID *uint, level models.AdminUnitLevel, name string) (uint, error) {
+		c := call{level: level, name: name}
+		if parentID != nil {
+			c.parent = *parentID
+		}
+		calls = append(calls, c)
+		return ids[name], nil
+	}
+	dl.adminUnitIDs = newIDCache(func(key string) (uint, error) {
+		return dl.resolveAdminUnit(key, find)
+	})
+
+	row := settlementRow{region: "Тверская область", municipality: "Калининский", settlement: "Эммаус"}
+	if id, err := dl.adminUnitIDs.get(adminUnitKey(row)); err != nil || id != 2 {
+		t.Fatalf("Expected municipality 2, got %d, %v", id, err)
+	}
+	row.municipality = ""
+	if id, _ := dl.adminUnitIDs.get(adminUnitKey(row)); id != 1 {
+		t.Errorf("Expected subject 1 for a row without municipality, got %d", id)
+	}
+
+	want := []call{
+		{0, models.AdminLevelSubject, "Тверская область"},
+		{1, models.AdminLevelMunicipality, "Калининский"},
+	}
+	if len(calls) != len(want) || calls[0] != want[0] || calls[1] != want[1] {
+		t.Errorf("Expected the subject to be resolved once before its municipality, got %v", calls)
+	}
+}
+
+func TestDistrictCodesKeepEarliestLine(t *testing.T) {
+	codes := districtCodes{}
+	later := settlementRow{line: 9, region: "Тверская область", regionOKTMO: "28000000", regionOKATO: "28"}
+	earlier := settlementRow{line: 3, region: "Тверская область", regionOKTMO: "28000001", regionOKATO: "bad"}
+
+	codes.add(later)
+	codes.add(earlier)
+	codes.add(settlementRow{line: 5, region: "Москва"})
+
+	tver := codes["Тверская область"]
+	if tver[models.SchemeOKTMO].code != "28000001" {
+		t.Errorf("Expected the OKTMO of the earliest line, got %q", tver[models.SchemeOKTMO].code)
+	}
+	if tver[models.SchemeOKATO].code != "28" {
+		t.Errorf("Expected a malformed OKATO to be ignored, got %q", tver[models.SchemeOKATO].code)
+	}
+	if _, ok := codes["Москва"]; ok {
+		t.Error("Expected no entry for a region without codes")
+	}
+}
+
+func TestObservesCensusYear(t *testing.T) {
+	tests := []struct {
+		year   int
+		dryRun bool
+		want   bool
+	}{
+		{0, false, false},
+		{2021, false, true},
+		{2021, true, false},
+	}
+
+	for _, test := range tests {
+		dl := NewWithOptions(nil, Options{Year: test.year, DryRun: test.dryRun})
+		if got := dl.observes(); got != test.want {
+			t.Errorf("Year %d, dry run %v: expected observes=%v, got %v", test.year, test.dryRun, test.want, got)
+		}
+	}
+}
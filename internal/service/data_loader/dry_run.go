@@ -0,0 +1,170 @@
+package data_loader
+
+import (
+	"context"
+	"fmt"
+
+	"settlements/internal/models"
+)
+
+// Preview lists what a dry run found the load would change besides the city
+// counters of the report.
+type Preview struct {
+	// NewDistricts and NewTypes are the districts and types the load would create.
+	NewDistricts []string `json:"newDistricts"`
+	NewTypes     []string `json:"newTypes"`
+	// NewAdminUnits are the administrative units the load would create,
+	// each given as its level and name.
+	NewAdminUnits []string `json:"newAdminUnits"`
+	// NewDataset is set when the load would create its dataset.
+	NewDataset bool `json:"newDataset"`
+	// Replaced counts the cities the load would delete because of
+	// Options.Replace: those of the dataset the source does not hold.
+	Replaced int64 `json:"replaced"`
+}
+
+// cityValues are the stored values a re-load compares to decide between
+// updating and leaving a city unchanged.
+type cityValues struct {
+	population int
+	childrens  int
+	latitude   float64
+	longitude  float64
+	oktmo      string
+	okato      string
+}
+
+// planWriter is the persist stage of a dry run. It resolves rows against the
+// database with reads only and counts what a load would do to them.
+type planWriter struct {
+	dl     *DataLoader
+	report *ImportReport
+	merges *mergeBuffer
+	// stored holds the cities the dataset already has. It is shared by the
+	// workers and only read.
+	stored map[naturalKey]cityValues
+	// planned holds the values the rows of this worker would write.
+	planned map[naturalKey]cityValues
+}
+
+func newPlanWriter(dl *DataLoader, report *ImportReport, stored map[naturalKey]cityValues) *planWriter {
+	return &planWriter{
+		dl:      dl,
+		report:  report,
+		merges:  newMergeBuffer(),
+		stored:  stored,
+		planned: map[naturalKey]cityValues{},
+	}
+}
+
+// storedCities reads the natural keys and values of the cities the dataset
+// holds. It returns nothing for a new dataset.
+func (dl *DataLoader) storedCities() (map[naturalKey]cityValues, error) {
+	res := map[naturalKey]cityValues{}
+	if dl.dataset.ID == 0 {
+		return res, nil
+	}
+
+	var rows []struct {
+		District     string
+		Name         string
+		Type         string
+		LatitudeKey  int64
+		LongitudeKey int64
+		Population   int
+		Childrens    int
+		Latitude     float64
+		Longitude    float64
+		OKTMO        string
+		OKATO        string
+	}
+
+	err := dl.db.Model(&models.City{}).
+		Select(`districts.name AS district, cities.name, types.name AS type,
+			cities.latitude_key, cities.longitude_key,
+			cities.population, cities.childrens, cities.latitude, cities.longitude,
+			cities.oktmo, cities.okato`).
+		Joins("JOIN districts ON districts.id = cities.district_id").
+		Joins("JOIN types ON types.id = cities.type_id").
+		Where("cities.dataset_id = ?", dl.dataset.ID).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dataset cities: %w", err)
+	}
+
+	for _, r := range rows {
+		key := naturalKey{
+			region:       r.District,
+			settlement:   r.Name,
+			typeName:     r.Type,
+			latitudeKey:  r.LatitudeKey,
+			longitudeKey: r.LongitudeKey,
+		}
+		res[key] = cityValues{r.Population, r.Childrens, r.Latitude, r.Longitude, r.OKTMO, r.OKATO}
+	}
+	return res, nil
+}
+
+func (w *planWriter) write(_ context.Context, row settlementRow) error {
+	if isMergedRow(row) {
+		if w.merges.add(row) {
+			w.report.addMerged()
+		}
+		return nil
+	}
+	return w.plan(row)
+}
+
+func (w *planWriter) flush(context.Context) error {
+	for _, key := range w.merges.order {
+		row := w.merges.rows[key]
+		if err := w.plan(*row); err != nil {
+			w.report.fail(row.line, ReasonPersistFailed, err.Error(), row.raw)
+		}
+	}
+
+	// Rows are sharded by key, so every stored city is planned by one writer at most
+	if w.dl.opts.Replace {
+		kept := 0
+		for key := range w.planned {
+			if _, ok := w.stored[key]; ok {
+				kept++
+			}
+		}
+		w.report.keepReplaced(kept)
+	}
+	return nil
+}
+
+// plan counts what upserting row would do. Types, districts and units that do not
+// exist yet are listed in the preview by the lookups of the load.
+func (w *planWriter) plan(row settlementRow) error {
+	if _, err := w.dl.typeIDs.get(row.typeName); err != nil {
+		return err
+	}
+	if _, err := w.dl.districtIDs.get(row.region); err != nil {
+		return err
+	}
+	if _, err := w.dl.adminUnitIDs.get(adminUnitKey(row)); err != nil {
+		return err
+	}
+
+	key := rowKey(row)
+	oktmo, okato := validCodes(row.oktmo, row.okato)
+	values := cityValues{row.population, row.childrens, row.latitude, w.dl.opts.Longitude.Normalize(row.longitude), oktmo, okato}
+
+	existing, ok := w.planned[key]
+	if !ok {
+		existing, ok = w.stored[key]
+	}
+	switch {
+	case !ok:
+		w.report.record(outcomeCreated)
+	case existing != values:
+		w.report.record(outcomeUpdated)
+	default:
+		w.report.record(outcomeUnchanged)
+	}
+	w.planned[key] = values
+	return nil
+}
@@ -0,0 +1,98 @@
+package data_loader
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// newTestPlanWriter returns a plan writer whose districts and types are all
+// known, so planning does not need a database. stored holds the cities the
+// dataset already has.
+func newTestPlanWriter(report *ImportReport, stored map[naturalKey]cityValues) *planWriter {
+	report.Preview = &Preview{}
+	known := func(string) (uint, error) { return 1, nil }
+	dl := &DataLoader{
+		opts:         DefaultOptions(),
+		mu:           &sync.Mutex{},
+		typeIDs:      newIDCache(known),
+		districtIDs:  newIDCache(known),
+		adminUnitIDs: newIDCache(known),
+	}
+	return newPlanWriter(dl, report, stored)
+}
+
+func TestPlanWriterOutcomes(t *testing.T) {
+	report := NewImportReport()
+	tver := settlementRow{region: "Тверская область", settlement: "Тверь", typeName: "город", population: 400000, latitude: 56.85, longitude: 35.9}
+	emmaus := settlementRow{region: "Тверская область", settlement: "Эммаус", typeName: "поселок", population: 2000, latitude: 56.95, longitude: 35.7}
+	w := newTestPlanWriter(report, map[naturalKey]cityValues{
+		rowKey(tver):   {400000, 0, 56.85, 35.9, "", ""},
+		rowKey(emmaus): {1900, 0, 56.95, 35.7, "", ""},
+	})
+
+	rows := []settlementRow{
+		tver,
+		emmaus,
+		{region: "Тверская область", settlement: "Новое", typeName: "поселок", population: 10, latitude: 57, longitude: 35},
+		{region: "Москва", settlement: "Москва", typeName: "город", population: 1000, latitude: 55.75, longitude: 37.62},
+		{region: "Москва", settlement: "Москва", typeName: "город", population: 2000, latitude: 55.75, longitude: 37.62},
+	}
+	for _, row := range rows {
+		if err := w.write(context.Background(), row); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if err := w.flush(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if report.Created != 2 || report.Updated != 1 || report.Unchanged != 1 || report.Merged != 1 {
+		t.Errorf("Expected 2 created, 1 updated, 1 unchanged, 1 merged, got %s", report.Summary())
+	}
+
+	if len(report.Preview.NewDistricts) != 0 || len(report.Preview.NewTypes) != 0 {
+		t.Errorf("Expected no new districts or types, got %+v", report.Preview)
+	}
+}
+
+func TestPlanWriterRepeatedKey(t *testing.T) {
+	report := NewImportReport()
+	w := newTestPlanWriter(report, map[naturalKey]cityValues{})
+
+	row := settlementRow{region: "Тверская область", settlement: "Тверь", typeName: "город", population: 400000, latitude: 56.85, longitude: 35.9}
+	w.write(context.Background(), row)
+	row.population = 410000
+	w.write(context.Background(), row)
+
+	if report.Created != 1 || report.Updated != 1 {
+		t.Errorf("Expected the repeated key to count as an update, got %s", report.Summary())
+	}
+}
+
+func TestPlanWriterReplace(t *testing.T) {
+	report := NewImportReport()
+	tver := settlementRow{region: "Тверская область", settlement: "Тверь", typeName: "город", population: 400000, latitude: 56.85, longitude: 35.9}
+	emmaus := settlementRow{region: "Тверская область", settlement: "Эммаус", typeName: "поселок", population: 2000, latitude: 56.95, longitude: 35.7}
+	stored := map[naturalKey]cityValues{
+		rowKey(tver):   {400000, 0, 56.85, 35.9, "", ""},
+		rowKey(emmaus): {1900, 0, 56.95, 35.7, "", ""},
+	}
+	w := newTestPlanWriter(report, stored)
+	w.dl.opts.Replace = true
+	report.Preview.Replaced = int64(len(stored))
+
+	// Тверь is still in the source and keeps its city, and with it the
+	// observations of other years; only Эммаус is deleted
+	w.write(context.Background(), tver)
+	if err := w.flush(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if report.Unchanged != 1 || report.Created != 0 {
+		t.Errorf("Expected the stored city to be upserted, not re-created, got %s", report.Summary())
+	}
+	if report.Preview.Replaced != 1 {
+		t.Errorf("Expected 1 city to be deleted, got %d", report.Preview.Replaced)
+	}
+}
@@ -0,0 +1,207 @@
+package data_loader
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/transform"
+)
+
+// Encoding is the character encoding of a source file.
+type Encoding string
+
+const (
+	// EncodingAuto detects the encoding from the start of the file.
+	EncodingAuto   Encoding = ""
+	EncodingUTF8   Encoding = "utf-8"
+	EncodingCP1251 Encoding = "cp1251"
+	EncodingKOI8R  Encoding = "koi8-r"
+)
+
+// encodingAliases maps the accepted spellings of every encoding to it.
+var encodingAliases = map[string]Encoding{
+	"":             EncodingAuto,
+	"auto":         EncodingAuto,
+	"utf-8":        EncodingUTF8,
+	"utf8":         EncodingUTF8,
+	"cp1251":       EncodingCP1251,
+	"windows-1251": EncodingCP1251,
+	"koi8-r":       EncodingKOI8R,
+	"koi8r":        EncodingKOI8R,
+}
+
+// ParseEncoding converts a configuration value into an Encoding.
+// "auto" and the empty string select detection.
+func ParseEncoding(s string) (Encoding, error) {
+	if enc, ok := encodingAliases[strings.ToLower(strings.TrimSpace(s))]; ok {
+		return enc, nil
+	}
+	return "", fmt.Errorf("unknown encoding %q (want auto, %q, %q or %q)", s, EncodingUTF8, EncodingCP1251, EncodingKOI8R)
+}
+
+func (e Encoding) decoder() *encoding.Decoder {
+	switch e {
+	case EncodingCP1251:
+		return charmap.Windows1251.NewDecoder()
+	case EncodingKOI8R:
+		return charmap.KOI8R.NewDecoder()
+	}
+	return encoding.Nop.NewDecoder()
+}
+
+// ParseDelimiter converts a configuration value into a CSV delimiter.
+// "auto" and the empty string select detection and return zero; "tab" and
+// "\t" select the tab character.
+func ParseDelimiter(s string) (rune, error) {
+	switch s {
+	case "", "auto":
+		return 0, nil
+	case "tab", `\t`:
+		return '\t', nil
+	}
+
+	r, size := utf8.DecodeRuneInString(s)
+	if size != len(s) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+		return 0, fmt.Errorf("invalid delimiter %q (want a single character, \"tab\" or \"auto\")", s)
+	}
+	return r, nil
+}
+
+// detectionSample is the number of bytes encoding and delimiter detection look at.
+const detectionSample = 64 * 1024
+
+// utf8BOM is the byte order mark some tools write at the start of UTF-8 files.
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// decode returns a UTF-8 reader over r, which is in enc or, for EncodingAuto,
+// in the encoding detected from its start. A UTF-8 byte order mark is dropped.
+// The encoding used is returned with the reader.
+func decode(r io.Reader, enc Encoding) (io.Reader, Encoding, error) {
+	br := bufio.NewReaderSize(r, detectionSample)
+
+	sample, err := br.Peek(detectionSample)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, "", fmt.Errorf("failed to read input: %w", err)
+	}
+
+	if bytes.HasPrefix(sample, utf8BOM) {
+		br.Discard(len(utf8BOM))
+		if enc == EncodingAuto {
+			enc = EncodingUTF8
+		}
+		sample = sample[len(utf8BOM):]
+	}
+	if enc == EncodingAuto {
+		enc = detectEncoding(sample, len(sample) < detectionSample)
+	}
+
+	if enc == EncodingUTF8 {
+		return br, enc, nil
+	}
+	return transform.NewReader(br, enc.decoder()), enc, nil
+}
+
+// detectEncoding guesses the encoding of sample. Valid UTF-8 is taken as is.
+// Otherwise the sample is decoded as CP1251 and as KOI8-R: the two share the
+// Cyrillic range but swap the cases, so the decoding with more lowercase
+// letters is the right one for ordinary text. complete is set when sample
+// is the whole input and does not end in a cut-off character.
+func detectEncoding(sample []byte, complete bool) Encoding {
+	if !complete {
+		sample = trimPartialRune(sample)
+	}
+	if utf8.Valid(sample) {
+		return EncodingUTF8
+	}
+
+	if lowercaseLetters(sample, EncodingKOI8R) > lowercaseLetters(sample, EncodingCP1251) {
+		return EncodingKOI8R
+	}
+	return EncodingCP1251
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence from the end of b.
+func trimPartialRune(b []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				return b[:len(b)-i]
+			}
+			break
+		}
+	}
+	return b
+}
+
+func lowercaseLetters(sample []byte, enc Encoding) int {
+	text, err := enc.decoder().Bytes(sample)
+	if err != nil {
+		return 0
+	}
+
+	n := 0
+	for _, r := range string(text) {
+		if unicode.Is(unicode.Cyrillic, r) && unicode.IsLower(r) {
+			n++
+		}
+	}
+	return n
+}
+
+// delimiterCandidates are the delimiters detection chooses from, in order of preference.
+var delimiterCandidates = []rune{',', ';', '\t', '|'}
+
+// detectDelimiter picks the candidate that occurs most often outside quotes
+// in the header line of sample. It falls back to a comma.
+func detectDelimiter(sample []byte) rune {
+	header := sample
+	if i := bytes.IndexByte(header, '\n'); i >= 0 {
+		header = header[:i]
+	}
+
+	counts := map[rune]int{}
+	quoted := false
+	for _, r := range string(header) {
+		if r == '"' {
+			quoted = !quoted
+			continue
+		}
+		if !quoted {
+			counts[r]++
+		}
+	}
+
+	best := ','
+	for _, c := range delimiterCandidates {
+		if counts[c] > counts[best] {
+			best = c
+		}
+	}
+	return best
+}
+
+// peekDelimiter returns a reader equivalent to r together with the delimiter
+// detected from its first line.
+func peekDelimiter(r io.Reader) (io.Reader, rune, error) {
+	br := bufio.NewReaderSize(r, detectionSample)
+	sample, err := br.Peek(detectionSample)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, 0, fmt.Errorf("failed to read input: %w", err)
+	}
+	return br, detectDelimiter(sample), nil
+}
+
+// delimiterName is how a delimiter is shown in reports.
+func delimiterName(r rune) string {
+	if r == '\t' {
+		return "tab"
+	}
+	return string(r)
+}
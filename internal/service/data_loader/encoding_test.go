@@ -0,0 +1,137 @@
+package data_loader
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+const cyrillicCSV = "Регион;Населенный пункт;Тип;Население;Широта;Долгота\nТверская область;Тверь;г;400000;56,85;35,9\n"
+
+func encodeTest(t *testing.T, enc *charmap.Charmap, s string) []byte {
+	t.Helper()
+
+	b, err := enc.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return b
+}
+
+func TestDecodeDetectsEncoding(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  Encoding
+	}{
+		{"utf-8", []byte(cyrillicCSV), EncodingUTF8},
+		{"utf-8 with BOM", append(append([]byte{}, utf8BOM...), cyrillicCSV...), EncodingUTF8},
+		{"cp1251", encodeTest(t, charmap.Windows1251, cyrillicCSV), EncodingCP1251},
+		{"koi8-r", encodeTest(t, charmap.KOI8R, cyrillicCSV), EncodingKOI8R},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, enc, err := decode(bytes.NewReader(tt.input), EncodingAuto)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if enc != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, enc)
+			}
+
+			text, _ := io.ReadAll(r)
+			if string(text) != cyrillicCSV {
+				t.Errorf("Expected the text to decode to the original, got %q", text)
+			}
+		})
+	}
+}
+
+func TestDecodeConfiguredEncoding(t *testing.T) {
+	input := encodeTest(t, charmap.KOI8R, "Тверь")
+
+	r, enc, err := decode(bytes.NewReader(input), EncodingKOI8R)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	text, _ := io.ReadAll(r)
+	if enc != EncodingKOI8R || string(text) != "Тверь" {
+		t.Errorf("Expected Тверь in koi8-r, got %q in %s", text, enc)
+	}
+}
+
+func TestDetectEncodingCutSample(t *testing.T) {
+	// A sample cut in the middle of "ь" is still UTF-8.
+	sample := []byte("Тверь")[:len("Тверь")-1]
+
+	if enc := detectEncoding(sample, false); enc != EncodingUTF8 {
+		t.Errorf("Expected utf-8, got %s", enc)
+	}
+}
+
+func TestDetectDelimiter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   rune
+	}{
+		{"region,settlement,type\n", ','},
+		{"region;settlement;type\n1,2;3,4;5\n", ';'},
+		{"region\tsettlement\ttype", '\t'},
+		{`"a;b",c,d` + "\n", ','},
+		{"region\n", ','},
+	}
+
+	for _, tt := range tests {
+		if got := detectDelimiter([]byte(tt.header)); got != tt.want {
+			t.Errorf("detectDelimiter(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestParseDelimiter(t *testing.T) {
+	for in, want := range map[string]rune{"": 0, "auto": 0, ";": ';', "tab": '\t', `\t`: '\t'} {
+		got, err := ParseDelimiter(in)
+		if err != nil || got != want {
+			t.Errorf("ParseDelimiter(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{";;", `"`, "\n"} {
+		if _, err := ParseDelimiter(in); err == nil {
+			t.Errorf("Expected an error for %q", in)
+		}
+	}
+}
+
+func TestParseEncoding(t *testing.T) {
+	for in, want := range map[string]Encoding{"auto": EncodingAuto, "UTF-8": EncodingUTF8, "windows-1251": EncodingCP1251, "koi8-r": EncodingKOI8R} {
+		got, err := ParseEncoding(in)
+		if err != nil || got != want {
+			t.Errorf("ParseEncoding(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+
+	if _, err := ParseEncoding("latin1"); err == nil {
+		t.Error("Expected an error for an unknown encoding")
+	}
+}
+
+func TestReadCitiesCP1251Semicolons(t *testing.T) {
+	input := "Регион;Населенный пункт;Тип;Население;Широта;Долгота\nТверская область;Тверь;г;400000;56.85;35.9\n"
+
+	cities, report, err := ReadCities(bytes.NewReader(encodeTest(t, charmap.Windows1251, input)), Options{Profile: builtinProfiles["generic"]}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if report.Encoding != string(EncodingCP1251) || report.Delimiter != ";" {
+		t.Errorf("Expected cp1251 and ;, got %s and %s", report.Encoding, report.Delimiter)
+	}
+	if len(cities) != 1 || cities[0].Name != "Тверь" || !strings.HasPrefix(cities[0].District, "Тверская") {
+		t.Errorf("Expected Тверь in Тверская область, got %+v", cities)
+	}
+}
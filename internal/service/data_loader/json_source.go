@@ -0,0 +1,233 @@
+package data_loader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// jsonColumns binds the keys of JSON objects to settlement fields through
+// the aliases of a profile. Objects carry their keys, so unlike CSV headers
+// the keys are matched per object and positional fallbacks do not apply.
+// Records hold the values in the order of jsonFields.
+type jsonColumns struct {
+	fields  map[string]Field
+	columns *columnMap
+}
+
+// jsonFields is the order of the values in records of JSON sources.
+var jsonFields = []Field{
+	FieldRegion,
+	FieldMunicipality,
+	FieldSettlement,
+	FieldType,
+	FieldPopulation,
+	FieldChildren,
+	FieldLatitude,
+	FieldLongitude,
+	FieldOKTMO,
+	FieldOKATO,
+	FieldRegionOKTMO,
+	FieldRegionOKATO,
+}
+
+func newJSONColumns(profile *Profile) *jsonColumns {
+	c := &jsonColumns{
+		fields:  map[string]Field{},
+		columns: &columnMap{positions: map[Field]int{}},
+	}
+
+	for i, field := range jsonFields {
+		col, ok := profile.Columns[field]
+		if !ok {
+			continue
+		}
+		c.columns.positions[field] = i
+		if col.Required {
+			c.columns.required = append(c.columns.required, field)
+		}
+
+		c.fields[normalizeHeader(string(field))] = field
+		for _, alias := range col.Aliases {
+			c.fields[normalizeHeader(alias)] = field
+		}
+	}
+	return c
+}
+
+// record converts the properties of an object into a record. Keys that no
+// field is known under are ignored.
+func (c *jsonColumns) record(line int, props map[string]any) sourceRecord {
+	fields := make([]string, len(jsonFields))
+	for key, value := range props {
+		field, ok := c.fields[normalizeHeader(key)]
+		if !ok {
+			continue
+		}
+		fields[c.columns.positions[field]] = jsonValue(value)
+	}
+	return sourceRecord{line: line, fields: fields}
+}
+
+// jsonValue formats a decoded JSON value the way it would appear in a CSV field.
+func jsonValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return fmt.Sprint(v)
+	}
+
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// ndjsonSource reads one settlement object per line. Blank lines are
+// ignored; record lines are the lines of the file.
+type ndjsonSource struct {
+	scanner *bufio.Scanner
+	columns *jsonColumns
+	line    int
+}
+
+// maxNDJSONLine is the longest line an NDJSON source accepts.
+const maxNDJSONLine = 1024 * 1024
+
+func newNDJSONSource(r io.Reader, profile *Profile) *ndjsonSource {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxNDJSONLine)
+	return &ndjsonSource{scanner: scanner, columns: newJSONColumns(profile)}
+}
+
+func (s *ndjsonSource) next() (sourceRecord, error) {
+	for s.scanner.Scan() {
+		s.line++
+		data := bytes.TrimSpace(s.scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		var props map[string]any
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&props); err != nil {
+			return sourceRecord{}, fmt.Errorf("failed to read NDJSON line %d: %w", s.line, err)
+		}
+		return s.columns.record(s.line, props), nil
+	}
+
+	if err := s.scanner.Err(); err != nil {
+		return sourceRecord{}, fmt.Errorf("failed to read NDJSON: %w", err)
+	}
+	return sourceRecord{}, io.EOF
+}
+
+func (s *ndjsonSource) mapping() *columnMap {
+	return s.columns.columns
+}
+
+// geoJSONFeature is a feature of a GeoJSON FeatureCollection.
+type geoJSONFeature struct {
+	Geometry *struct {
+		Type        string        `json:"type"`
+		Coordinates []json.Number `json:"coordinates"`
+	} `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+// geoJSONSource streams the features of a GeoJSON FeatureCollection.
+// The coordinates of Point geometries take precedence over coordinate
+// properties. Record lines are the positions of the features, from 1.
+type geoJSONSource struct {
+	dec     *json.Decoder
+	columns *jsonColumns
+	feature int
+}
+
+// newGeoJSONSource reads r up to the first feature of its collection.
+func newGeoJSONSource(r io.Reader, profile *Profile) (*geoJSONSource, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+	for {
+		if !dec.More() {
+			return nil, fmt.Errorf("GeoJSON has no features")
+		}
+
+		key, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GeoJSON: %w", err)
+		}
+		if key == "features" {
+			break
+		}
+
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, fmt.Errorf("failed to read GeoJSON: %w", err)
+		}
+	}
+	if err := expectDelim(dec, '['); err != nil {
+		return nil, err
+	}
+
+	return &geoJSONSource{dec: dec, columns: newJSONColumns(profile)}, nil
+}
+
+// expectDelim reads the next token of dec and fails unless it is delim.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("failed to read GeoJSON: %w", err)
+	}
+	if tok != delim {
+		return fmt.Errorf("failed to read GeoJSON: expected %q, got %v", delim, tok)
+	}
+	return nil
+}
+
+func (s *geoJSONSource) next() (sourceRecord, error) {
+	// Whatever follows the features array is not needed.
+	if !s.dec.More() {
+		return sourceRecord{}, io.EOF
+	}
+
+	var feature geoJSONFeature
+	if err := s.dec.Decode(&feature); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return sourceRecord{}, fmt.Errorf("failed to read GeoJSON feature %d: %w", s.feature+1, err)
+	}
+	s.feature++
+
+	props := feature.Properties
+	if props == nil {
+		props = map[string]any{}
+	}
+	if g := feature.Geometry; g != nil && strings.EqualFold(g.Type, "Point") && len(g.Coordinates) >= 2 {
+		for key := range props {
+			if field := s.columns.fields[normalizeHeader(key)]; field == FieldLatitude || field == FieldLongitude {
+				delete(props, key)
+			}
+		}
+		props[string(FieldLongitude)] = g.Coordinates[0]
+		props[string(FieldLatitude)] = g.Coordinates[1]
+	}
+	return s.columns.record(s.feature, props), nil
+}
+
+func (s *geoJSONSource) mapping() *columnMap {
+	return s.columns.columns
+}
@@ -0,0 +1,129 @@
+package data_loader
+
+import (
+	"strings"
+	"testing"
+)
+
+const sampleGeoJSON = `{
+  "type": "FeatureCollection",
+  "name": "settlements",
+  "features": [
+    {"type": "Feature", "geometry": {"type": "Point", "coordinates": [35.9, 56.85]},
+     "properties": {"region": "Тверская область", "settlement": "Тверь", "type": "г", "population": 400000, "children": 60000, "latitude": 1}},
+    {"type": "Feature", "geometry": null,
+     "properties": {"Регион": "Тверская область", "Населенный пункт": "Эммаус", "Тип": "п", "Население": "2000", "Широта": 56.95, "Долгота": 35.7}}
+  ]
+}`
+
+const sampleNDJSON = `{"region": "Тверская область", "settlement": "Тверь", "type": "г", "population": 400000, "latitude": 56.85, "longitude": 35.9, "id": 17}
+
+{"region": "Тверская область", "settlement": "Эммаус", "type": "п", "population": 2000, "latitude": 56.95, "longitude": 35.7}
+`
+
+func TestReadCitiesFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		input  string
+	}{
+		{"geojson", FormatGeoJSON, sampleGeoJSON},
+		{"ndjson", FormatNDJSON, sampleNDJSON},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cities, report, err := ReadCities(strings.NewReader(tt.input), Options{Format: tt.format}, nil)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if report.Format != string(tt.format) {
+				t.Errorf("Expected format %s, got %s", tt.format, report.Format)
+			}
+			if len(cities) != 2 {
+				t.Fatalf("Expected 2 cities, got %d (%s)", len(cities), report.Summary())
+			}
+
+			tver, emmaus := cities[0], cities[1]
+			if tver.Name != "Тверь" || tver.Type != "город" || tver.Population != 400000 {
+				t.Errorf("Unexpected first city %+v", tver)
+			}
+			if tver.Latitude != 56.85 || tver.Longitude != 35.9 {
+				t.Errorf("Expected coordinates 56.85/35.9, got %f/%f", tver.Latitude, tver.Longitude)
+			}
+			if emmaus.Name != "Эммаус" || emmaus.Population != 2000 || emmaus.Latitude != 56.95 {
+				t.Errorf("Unexpected second city %+v", emmaus)
+			}
+		})
+	}
+}
+
+func TestNDJSONSourceLines(t *testing.T) {
+	src := newNDJSONSource(strings.NewReader(sampleNDJSON), builtinProfiles[DefaultProfileName])
+
+	first, err := src.next()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	second, err := src.next()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if first.line != 1 || second.line != 3 {
+		t.Errorf("Expected lines 1 and 3, got %d and %d", first.line, second.line)
+	}
+}
+
+func TestNDJSONSourceMissingRequired(t *testing.T) {
+	report := NewImportReport()
+	src := newNDJSONSource(strings.NewReader(`{"region": "Тверская область", "settlement": "Тверь", "type": "г", "latitude": 56.85, "longitude": 35.9}`),
+		builtinProfiles[DefaultProfileName])
+
+	rec, err := src.next()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	row, ok := parseOrSkip(rec, src.mapping(), settlementsTypes, report)
+	if !ok {
+		t.Fatal("Expected the record to parse")
+	}
+	if _, invalid := row.invalid[FieldPopulation]; !invalid {
+		t.Errorf("Expected the missing population to be invalid, got %v", row.invalid)
+	}
+}
+
+func TestNDJSONSourceMalformed(t *testing.T) {
+	src := newNDJSONSource(strings.NewReader("{\"region\": \n"), builtinProfiles[DefaultProfileName])
+
+	if _, err := src.next(); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("Expected an error naming line 1, got %v", err)
+	}
+}
+
+func TestGeoJSONSourceRejectsOtherDocuments(t *testing.T) {
+	for _, input := range []string{`[]`, `{"type": "FeatureCollection"}`, `{"features": {}}`} {
+		if _, err := newGeoJSONSource(strings.NewReader(input), builtinProfiles[DefaultProfileName]); err == nil {
+			t.Errorf("Expected an error for %s", input)
+		}
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := map[string]Format{
+		"datasets/dataset.csv":    FormatCSV,
+		"datasets/dataset.csv.gz": FormatCSV,
+		"points.GeoJSON":          FormatGeoJSON,
+		"points.json.gz":          FormatGeoJSON,
+		"dump.ndjson":             FormatNDJSON,
+		"dump.jsonl":              FormatNDJSON,
+		"-":                       FormatCSV,
+		"settlements":             FormatCSV,
+	}
+
+	for name, want := range tests {
+		if got := DetectFormat(name); got != want {
+			t.Errorf("DetectFormat(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
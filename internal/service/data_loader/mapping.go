@@ -0,0 +1,245 @@
+package data_loader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Field is a settlement attribute the loader reads from a source.
+type Field string
+
+const (
+	FieldRegion       Field = "region"
+	FieldMunicipality Field = "municipality"
+	FieldSettlement   Field = "settlement"
+	FieldType         Field = "type"
+	FieldPopulation   Field = "population"
+	FieldChildren     Field = "children"
+	FieldLatitude     Field = "latitude"
+	FieldLongitude    Field = "longitude"
+	// FieldOKTMO and FieldOKATO are the classifier codes of the settlement,
+	// FieldRegionOKTMO and FieldRegionOKATO those of its region.
+	FieldOKTMO       Field = "oktmo"
+	FieldOKATO       Field = "okato"
+	FieldRegionOKTMO Field = "region_oktmo"
+	FieldRegionOKATO Field = "region_okato"
+)
+
+// Column tells the loader how to find a field in the source header.
+type Column struct {
+	// Aliases are the header names the field may appear under, matched case-insensitively.
+	Aliases []string `json:"aliases"`
+	// Index is the zero-based position of the field in files without a header
+	// row. It is only used when the first row of a file matches none of the
+	// aliases of the profile, which is then read as the first data row.
+	Index *int `json:"index,omitempty"`
+	// Required fields must be present in the header, otherwise the load is rejected.
+	Required bool `json:"required"`
+}
+
+// Profile is a named column mapping for one family of source files.
+type Profile struct {
+	Name    string           `json:"name"`
+	Columns map[Field]Column `json:"columns"`
+}
+
+// DefaultProfileName is the profile used when none is configured.
+const DefaultProfileName = "rosstat"
+
+func columnIndex(i int) *int { return &i }
+
+// builtinProfiles are the profiles that can be picked by name.
+var builtinProfiles = map[string]*Profile{
+	// rosstat is the settlements dataset the application was built for.
+	// Positions are kept for extracts exported without a header row.
+	"rosstat": {
+		Name: "rosstat",
+		Columns: map[Field]Column{
+			FieldRegion:       {Aliases: []string{"region", "регион", "субъект"}, Index: columnIndex(1), Required: true},
+			FieldMunicipality: {Aliases: []string{"municipality", "муниципальное образование", "муниципалитет"}, Index: columnIndex(2)},
+			FieldSettlement:   {Aliases: []string{"settlement", "населенный пункт", "населённый пункт"}, Index: columnIndex(3), Required: true},
+			FieldType:         {Aliases: []string{"type", "тип"}, Index: columnIndex(4), Required: true},
+			FieldPopulation:   {Aliases: []string{"population", "население"}, Index: columnIndex(5), Required: true},
+			FieldChildren:     {Aliases: []string{"children", "дети"}, Index: columnIndex(6)},
+			FieldLatitude:     {Aliases: []string{"latitude_dd", "latitude", "широта"}, Index: columnIndex(9), Required: true},
+			FieldLongitude:    {Aliases: []string{"longitude_dd", "longitude", "долгота"}, Index: columnIndex(10), Required: true},
+			FieldOKTMO:        {Aliases: []string{"oktmo", "октмо"}},
+			FieldOKATO:        {Aliases: []string{"okato", "окато"}},
+			FieldRegionOKTMO:  {Aliases: []string{"region_oktmo", "октмо региона", "октмо субъекта"}},
+			FieldRegionOKATO:  {Aliases: []string{"region_okato", "окато региона", "окато субъекта"}},
+		},
+	},
+	// generic matches files by header names only, with common English and Russian spellings.
+	"generic": {
+		Name: "generic",
+		Columns: map[Field]Column{
+			FieldRegion:       {Aliases: []string{"region", "subject", "state", "province", "регион", "субъект"}, Required: true},
+			FieldMunicipality: {Aliases: []string{"municipality", "municipal district", "county", "муниципальное образование", "муниципальный район", "муниципалитет"}},
+			FieldSettlement:   {Aliases: []string{"settlement", "name", "city", "locality", "населенный пункт", "название"}, Required: true},
+			FieldType:         {Aliases: []string{"type", "kind", "тип"}},
+			FieldPopulation:   {Aliases: []string{"population", "pop", "население"}, Required: true},
+			FieldChildren:     {Aliases: []string{"children", "дети"}},
+			FieldLatitude:     {Aliases: []string{"latitude", "lat", "широта"}, Required: true},
+			FieldLongitude:    {Aliases: []string{"longitude", "lon", "lng", "долгота"}, Required: true},
+			FieldOKTMO:        {Aliases: []string{"oktmo", "oktmo_code", "октмо", "код октмо"}},
+			FieldOKATO:        {Aliases: []string{"okato", "okato_code", "окато", "код окато"}},
+			FieldRegionOKTMO:  {Aliases: []string{"region_oktmo", "subject_oktmo", "октмо региона", "октмо субъекта"}},
+			FieldRegionOKATO:  {Aliases: []string{"region_okato", "subject_okato", "окато региона", "окато субъекта"}},
+		},
+	},
+}
+
+// ProfileNames returns the names of the built-in profiles.
+func ProfileNames() []string {
+	names := make([]string, 0, len(builtinProfiles))
+	for name := range builtinProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadProfile returns the built-in profile with the given name,
+// or reads a profile from the JSON file at that path.
+func LoadProfile(nameOrPath string) (*Profile, error) {
+	if nameOrPath == "" {
+		nameOrPath = DefaultProfileName
+	}
+	if p, ok := builtinProfiles[nameOrPath]; ok {
+		return p, nil
+	}
+
+	data, err := os.ReadFile(nameOrPath)
+	if err != nil {
+		return nil, fmt.Errorf("unknown profile %q (built-in: %s): %w",
+			nameOrPath, strings.Join(ProfileNames(), ", "), err)
+	}
+
+	var p Profile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse profile %s: %w", nameOrPath, err)
+	}
+	if len(p.Columns) == 0 {
+		return nil, fmt.Errorf("profile %s defines no columns", nameOrPath)
+	}
+	if p.Name == "" {
+		p.Name = nameOrPath
+	}
+	return &p, nil
+}
+
+// columnMap is a profile bound to the header of a concrete source.
+type columnMap struct {
+	positions map[Field]int
+	required  []Field
+	// headerless is set when the source has no header row, so its columns
+	// were bound by position and the first row holds data.
+	headerless bool
+}
+
+// bind resolves the profile against a header row. When the row matches none
+// of the aliases and the profile has positions, the source is taken to have
+// no header and every column is bound by position. Positions are never mixed
+// with names: a header missing a required column is rejected.
+func (p *Profile) bind(header []string) (*columnMap, error) {
+	byName := make(map[string]int, len(header))
+	for i, h := range header {
+		name := normalizeHeader(h)
+		if _, seen := byName[name]; !seen {
+			byName[name] = i
+		}
+	}
+
+	cm := &columnMap{positions: map[Field]int{}, headerless: p.hasPositions()}
+	for _, col := range p.Columns {
+		for _, alias := range col.Aliases {
+			if _, ok := byName[normalizeHeader(alias)]; ok {
+				cm.headerless = false
+			}
+		}
+	}
+	missing := []string{}
+
+	for field, col := range p.Columns {
+		pos, ok := -1, false
+		if cm.headerless {
+			if col.Index != nil {
+				pos, ok = *col.Index, true
+			}
+		} else {
+			for _, alias := range col.Aliases {
+				if pos, ok = byName[normalizeHeader(alias)]; ok {
+					break
+				}
+			}
+		}
+
+		if !ok {
+			if col.Required {
+				missing = append(missing, string(field))
+			}
+			continue
+		}
+
+		cm.positions[field] = pos
+		if col.Required {
+			cm.required = append(cm.required, field)
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("profile %q: required columns not found in header: %s",
+			p.Name, strings.Join(missing, ", "))
+	}
+	sort.Slice(cm.required, func(i, j int) bool { return cm.required[i] < cm.required[j] })
+	return cm, nil
+}
+
+// hasPositions reports whether any column of the profile has an Index.
+func (p *Profile) hasPositions() bool {
+	for _, col := range p.Columns {
+		if col.Index != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// missing returns the required fields that record is too short to contain.
+func (cm *columnMap) missing(record []string) []Field {
+	var res []Field
+	for _, field := range cm.required {
+		if cm.positions[field] >= len(record) {
+			res = append(res, field)
+		}
+	}
+	return res
+}
+
+// isRequired reports whether field is required by the profile.
+func (cm *columnMap) isRequired(field Field) bool {
+	for _, f := range cm.required {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns the trimmed value of field in record.
+// The second result is false when the field is not mapped or the record is too short.
+func (cm *columnMap) get(record []string, field Field) (string, bool) {
+	pos, ok := cm.positions[field]
+	if !ok || pos >= len(record) {
+		return "", false
+	}
+	return strings.TrimSpace(record[pos]), true
+}
+
+func normalizeHeader(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
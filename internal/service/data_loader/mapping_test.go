@@ -0,0 +1,108 @@
+package data_loader
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProfileBindByHeaderName(t *testing.T) {
+	header := []string{"Долгота", "Широта", "Население", "Тип", "Населенный пункт", "Регион"}
+
+	columns, err := builtinProfiles["generic"].bind(header)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	record := []string{"37.6", "55.7", "1000", "д", "Ивановка", "Тверская область"}
+
+	tests := []struct {
+		field    Field
+		expected string
+	}{
+		{FieldLongitude, "37.6"},
+		{FieldLatitude, "55.7"},
+		{FieldPopulation, "1000"},
+		{FieldType, "д"},
+		{FieldSettlement, "Ивановка"},
+		{FieldRegion, "Тверская область"},
+	}
+
+	for _, test := range tests {
+		if v, ok := columns.get(record, test.field); !ok || v != test.expected {
+			t.Errorf("Field %s: expected %q, got %q", test.field, test.expected, v)
+		}
+	}
+
+	if _, ok := columns.get(record, FieldChildren); ok {
+		t.Errorf("Expected unmapped children column")
+	}
+}
+
+func TestProfileBindHeaderlessByIndex(t *testing.T) {
+	first := []string{"1", "Тверская область", "Тверь", "Тверь", "г", "424969", "70000", "", "", "56.86", "35.9"}
+
+	columns, err := builtinProfiles[DefaultProfileName].bind(first)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if !columns.headerless {
+		t.Errorf("Expected a row without column names to be read as data")
+	}
+	if columns.positions[FieldSettlement] != 3 || columns.positions[FieldLongitude] != 10 {
+		t.Errorf("Expected positional columns, got %v", columns.positions)
+	}
+}
+
+func TestProfileBindHeaderIgnoresIndex(t *testing.T) {
+	// latitude and longitude would otherwise be taken from columns 9 and 10
+	header := []string{"region", "settlement", "type", "population", "lat", "lon", "a", "b", "c", "d", "e"}
+
+	_, err := builtinProfiles[DefaultProfileName].bind(header)
+
+	if err == nil || !strings.Contains(err.Error(), "latitude, longitude") {
+		t.Errorf("Expected the missing coordinates to be reported, got %v", err)
+	}
+}
+
+func TestProfileBindMissingRequired(t *testing.T) {
+	_, err := builtinProfiles["generic"].bind([]string{"name", "population"})
+
+	if err == nil {
+		t.Errorf("Expected error for missing required columns")
+	}
+}
+
+func TestColumnMapMissing(t *testing.T) {
+	columns, _ := builtinProfiles[DefaultProfileName].bind([]string{"1", "Тверская область"})
+
+	missing := columns.missing([]string{"1", "Тверская область", "x", "Тверь"})
+	if len(missing) != 4 {
+		t.Errorf("Expected 4 missing fields, got %v", missing)
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	p, err := LoadProfile("")
+	if err != nil || p.Name != DefaultProfileName {
+		t.Errorf("Expected default profile, got %v, %v", p, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "agency.json")
+	os.WriteFile(path, []byte(`{"columns": {"settlement": {"aliases": ["town"], "required": true}}}`), 0o644)
+
+	p, err = LoadProfile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if p.Name != path || len(p.Columns[FieldSettlement].Aliases) != 1 {
+		t.Errorf("Unexpected profile loaded from file: %+v", p)
+	}
+
+	if _, err := LoadProfile("no-such-profile"); err == nil {
+		t.Errorf("Expected error for unknown profile")
+	}
+}
@@ -0,0 +1,86 @@
+package data_loader
+
+import (
+	"database/sql"
+	"fmt"
+
+	"settlements/internal/models"
+)
+
+// observeCitySQL records the population of the city with the given natural
+// key as its observation of a year, replacing an earlier one of that year.
+const observeCitySQL = `
+INSERT INTO population_observations (city_id, year, population, childrens, source)
+SELECT id, ?, ?, ?, ? FROM cities
+WHERE dataset_id = ? AND district_id = ? AND name = ? AND type_id = ? AND latitude_key = ? AND longitude_key = ?
+ON CONFLICT (city_id, year) DO UPDATE
+SET population = EXCLUDED.population,
+	childrens = EXCLUDED.childrens,
+	source = EXCLUDED.source`
+
+// observeStagingSQL records the populations of a staged batch, the last row
+// of a repeated key winning as it does in mergeStagingSQL.
+const observeStagingSQL = `
+INSERT INTO population_observations (city_id, year, population, childrens, source)
+SELECT DISTINCT ON (cities.id) cities.id, ?, s.population, s.childrens, ?
+FROM ` + stagingTable + ` AS s
+JOIN cities ON (cities.dataset_id, cities.district_id, cities.name, cities.type_id, cities.latitude_key, cities.longitude_key)
+	= (s.dataset_id, s.district_id, s.name, s.type_id, s.latitude_key, s.longitude_key)
+ORDER BY cities.id, s.seq DESC
+ON CONFLICT (city_id, year) DO UPDATE
+SET population = EXCLUDED.population,
+	childrens = EXCLUDED.childrens,
+	source = EXCLUDED.source`
+
+// syncLatestObservationsSQL sets the population of every city of a dataset
+// to its observation of the latest year.
+const syncLatestObservationsSQL = `
+UPDATE cities
+SET population = latest.population, childrens = latest.childrens
+FROM (
+	SELECT DISTINCT ON (city_id) city_id, population, childrens
+	FROM population_observations
+	WHERE city_id IN (SELECT id FROM cities WHERE dataset_id = @dataset)
+	ORDER BY city_id, year DESC
+) AS latest
+WHERE cities.id = latest.city_id
+	AND cities.dataset_id = @dataset
+	AND (cities.population, cities.childrens) IS DISTINCT FROM (latest.population, latest.childrens)`
+
+// observes reports whether the load records population observations.
+func (dl *DataLoader) observes() bool {
+	return dl.opts.Year != 0 && !dl.opts.DryRun
+}
+
+// observeCity records the population of city as its observation of the
+// census year of the load. The city must have been written already.
+func (dl *DataLoader) observeCity(city *models.City) error {
+	err := dl.db.Exec(observeCitySQL,
+		dl.opts.Year, city.Population, city.Childrens, dl.dataset.SourceFile,
+		city.DatasetID, city.DistrictID, city.Name, city.TypeID, city.LatitudeKey, city.LongitudeKey,
+	).Error
+	if err != nil {
+		return fmt.Errorf("failed to record population of %d: %w", dl.opts.Year, err)
+	}
+	return nil
+}
+
+// observeStaging records the populations of the batch in the staging table.
+// The caller must hold the statement lock.
+func (dl *DataLoader) observeStaging() error {
+	if err := dl.db.Exec(observeStagingSQL, dl.opts.Year, dl.dataset.SourceFile).Error; err != nil {
+		return fmt.Errorf("failed to record population of %d: %w", dl.opts.Year, err)
+	}
+	return nil
+}
+
+// syncLatestObservations makes the cities of the dataset show the population
+// of the latest year observed, so loading an older census after a newer one
+// extends the series without rolling the cities back.
+func (dl *DataLoader) syncLatestObservations() error {
+	err := dl.db.Exec(syncLatestObservationsSQL, sql.Named("dataset", dl.dataset.ID)).Error
+	if err != nil {
+		return fmt.Errorf("failed to update cities to the latest population: %w", err)
+	}
+	return nil
+}
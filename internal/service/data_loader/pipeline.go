@@ -0,0 +1,253 @@
+package data_loader
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"settlements/internal/models"
+)
+
+// pipelineBuffer is the capacity of the channels between pipeline stages.
+// It bounds how many rows can be in flight at once.
+const pipelineBuffer = 256
+
+// sourceRecord is a raw record with the line it starts on in the source.
+// Its fields are read through the column map of the source.
+type sourceRecord struct {
+	line   int
+	fields []string
+}
+
+// settlementRow is a parsed data row ready to be validated and persisted.
+type settlementRow struct {
+	line   int
+	raw    []string
+	region string
+	// municipality is the municipal district or okrug, empty when unknown.
+	municipality string
+	settlement   string
+	typeShort    string
+	// typeName is typeShort expanded with the type dictionary;
+	// knownType is false when the dictionary has no such abbreviation.
+	typeName   string
+	knownType  bool
+	population int
+	childrens  int
+	latitude   float64
+	longitude  float64
+	// oktmo and okato are the normalized codes of the settlement,
+	// regionOKTMO and regionOKATO those of its region; empty when unknown.
+	oktmo       string
+	okato       string
+	regionOKTMO string
+	regionOKATO string
+	// invalid maps fields that could not be parsed to their raw values.
+	invalid map[Field]string
+}
+
+// record returns the view of the row checked by rules.
+func (row settlementRow) record() Record {
+	return Record{
+		Line:         row.line,
+		Region:       row.region,
+		Municipality: row.municipality,
+		Settlement:   row.settlement,
+		Type:         row.typeShort,
+		KnownType:    row.knownType,
+		Population:   row.population,
+		Children:     row.childrens,
+		Latitude:     row.latitude,
+		Longitude:    row.longitude,
+		OKTMO:        row.oktmo,
+		OKATO:        row.okato,
+		RegionOKTMO:  row.regionOKTMO,
+		RegionOKATO:  row.regionOKATO,
+		Invalid:      row.invalid,
+	}
+}
+
+// csvSource reads data rows from a CSV stream whose header was bound to a profile.
+type csvSource struct {
+	reader  *csv.Reader
+	columns *columnMap
+	// first is the first row of a source without a header, read before the others.
+	first *sourceRecord
+}
+
+// newCSVSource reads the header of r, whose fields are separated by comma,
+// and binds it to profile.
+func newCSVSource(r io.Reader, profile *Profile, comma rune) (*csvSource, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("CSV file is empty or has no data rows")
+		}
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	columns, err := profile.bind(header)
+	if err != nil {
+		return nil, err
+	}
+
+	src := &csvSource{reader: reader, columns: columns}
+	if columns.headerless {
+		line, _ := reader.FieldPos(0)
+		src.first = &sourceRecord{line: line, fields: header}
+	}
+	return src, nil
+}
+
+func (s *csvSource) next() (sourceRecord, error) {
+	if s.first != nil {
+		rec := *s.first
+		s.first = nil
+		return rec, nil
+	}
+
+	fields, err := s.reader.Read()
+	if errors.Is(err, io.EOF) {
+		return sourceRecord{}, io.EOF
+	}
+	if err != nil {
+		return sourceRecord{}, fmt.Errorf("failed to read CSV: %w", err)
+	}
+
+	line, _ := s.reader.FieldPos(0)
+	return sourceRecord{line: line, fields: fields}, nil
+}
+
+func (s *csvSource) mapping() *columnMap {
+	return s.columns
+}
+
+// readRecords reads src one record at a time and sends every data row to out.
+func readRecords(ctx context.Context, src recordSource, out chan<- sourceRecord) error {
+	for {
+		rec, err := src.next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		select {
+		case out <- rec:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// parseRecords converts raw records into settlement rows.
+func parseRecords(ctx context.Context, in <-chan sourceRecord, out chan<- settlementRow, columns *columnMap, types typeNames, report *ImportReport) error {
+	for rec := range in {
+		row, ok := parseOrSkip(rec, columns, types, report)
+		if !ok {
+			continue
+		}
+
+		select {
+		case out <- row:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// parseOrSkip counts the record, parses it and expands its type. Records that
+// cannot be parsed are recorded as skipped and reported with false.
+func parseOrSkip(rec sourceRecord, columns *columnMap, types typeNames, report *ImportReport) (settlementRow, bool) {
+	report.addRow()
+	if missing := columns.missing(rec.fields); len(missing) > 0 {
+		report.skip(rec.line, ReasonInsufficientColumns,
+			fmt.Sprintf("%d columns, missing %v", len(rec.fields), missing), rec.fields)
+		return settlementRow{}, false
+	}
+
+	row := parseRecord(rec, columns)
+	row.typeName, row.knownType = types.expand(row.typeShort)
+	return row, true
+}
+
+// parseRecord extracts the settlement fields from a record. Numeric
+// values that cannot be parsed are left at zero and noted in row.invalid;
+// an empty value is only invalid for a required field.
+func parseRecord(rec sourceRecord, columns *columnMap) settlementRow {
+	get := func(field Field) string {
+		v, _ := columns.get(rec.fields, field)
+		return v
+	}
+
+	row := settlementRow{
+		line:         rec.line,
+		raw:          rec.fields,
+		region:       get(FieldRegion),
+		municipality: get(FieldMunicipality),
+		settlement:   get(FieldSettlement),
+		typeShort:    get(FieldType),
+		oktmo:        models.NormalizeCode(get(FieldOKTMO)),
+		okato:        models.NormalizeCode(get(FieldOKATO)),
+		regionOKTMO:  models.NormalizeCode(get(FieldRegionOKTMO)),
+		regionOKATO:  models.NormalizeCode(get(FieldRegionOKATO)),
+	}
+
+	invalid := func(field Field, v string, err error) bool {
+		if err == nil || (v == "" && !columns.isRequired(field)) {
+			return false
+		}
+		if row.invalid == nil {
+			row.invalid = map[Field]string{}
+		}
+		row.invalid[field] = v
+		return true
+	}
+	parseInt := func(field Field) int {
+		v := get(field)
+		n, err := strconv.Atoi(v)
+		if invalid(field, v, err) {
+			return 0
+		}
+		return n
+	}
+	parseFloat := func(field Field) float64 {
+		v := get(field)
+		f, err := strconv.ParseFloat(v, 64)
+		if invalid(field, v, err) {
+			return 0
+		}
+		return f
+	}
+
+	row.population = parseInt(FieldPopulation)
+	row.childrens = parseInt(FieldChildren)
+	row.latitude = parseFloat(FieldLatitude)
+	row.longitude = parseFloat(FieldLongitude)
+	return row
+}
+
+// validateRows checks every row against rules and drops the rows that must not be persisted.
+func validateRows(ctx context.Context, in <-chan settlementRow, out chan<- settlementRow, rules []Rule, report *ImportReport) error {
+	for row := range in {
+		if !applyRules(row, rules, report) {
+			continue
+		}
+
+		select {
+		case out <- row:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
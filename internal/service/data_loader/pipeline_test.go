@@ -0,0 +1,252 @@
+package data_loader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"math"
+	"settlements/internal/geo"
+	"strings"
+	"testing"
+)
+
+const sampleCSV = `id,region,municipality,settlement,type,population,children,lat_dms,lon_dms,latitude,longitude,a,b,c
+1,Тверская область,Тверь,Тверь,г,400000,60000,,,56.85,35.9,,,
+2,Тверская область,Калининский,Эммаус,п,2000,300,,,56.95,35.7,,,
+3,short,row
+`
+
+func newTestSource(t *testing.T, r io.Reader) *csvSource {
+	t.Helper()
+
+	src, err := newCSVSource(r, builtinProfiles[DefaultProfileName], ',')
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return src
+}
+
+func collectRecords(t *testing.T, r io.Reader) []sourceRecord {
+	t.Helper()
+
+	out := make(chan sourceRecord, pipelineBuffer)
+	if err := readRecords(context.Background(), newTestSource(t, r), out); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	close(out)
+
+	res := []sourceRecord{}
+	for rec := range out {
+		res = append(res, rec)
+	}
+	return res
+}
+
+func TestReadRecordsSkipsHeader(t *testing.T) {
+	records := collectRecords(t, strings.NewReader(sampleCSV))
+
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(records))
+	}
+
+	if records[0].line != 2 {
+		t.Errorf("Expected first record on line 2, got %d", records[0].line)
+	}
+
+	if records[2].line != 4 {
+		t.Errorf("Expected last record on line 4, got %d", records[2].line)
+	}
+}
+
+func TestNewCSVSourceEmptyInput(t *testing.T) {
+	_, err := newCSVSource(strings.NewReader(""), builtinProfiles[DefaultProfileName], ',')
+
+	if err == nil {
+		t.Errorf("Expected error for empty input")
+	}
+}
+
+func TestDecompressGzip(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(sampleCSV))
+	gz.Close()
+
+	input, err := decompress(&buf)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer input.Close()
+
+	records := collectRecords(t, input)
+	if len(records) != 3 {
+		t.Errorf("Expected 3 records from gzip input, got %d", len(records))
+	}
+}
+
+func TestDecompressPlain(t *testing.T) {
+	input, err := decompress(strings.NewReader(sampleCSV))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer input.Close()
+
+	data, _ := io.ReadAll(input)
+	if string(data) != sampleCSV {
+		t.Errorf("Expected plain input to pass through unchanged")
+	}
+}
+
+func TestParseRecord(t *testing.T) {
+	src := newTestSource(t, strings.NewReader(sampleCSV))
+	records := collectRecords(t, strings.NewReader(sampleCSV))
+	row := parseRecord(records[0], src.columns)
+
+	if row.region != "Тверская область" {
+		t.Errorf("Expected region 'Тверская область', got %s", row.region)
+	}
+
+	if row.settlement != "Тверь" {
+		t.Errorf("Expected settlement 'Тверь', got %s", row.settlement)
+	}
+
+	if row.municipality != "Тверь" {
+		t.Errorf("Expected municipality 'Тверь', got %s", row.municipality)
+	}
+
+	if row.typeShort != "г" {
+		t.Errorf("Expected type 'г', got %s", row.typeShort)
+	}
+
+	if row.population != 400000 || row.childrens != 60000 {
+		t.Errorf("Expected population 400000/60000, got %d/%d", row.population, row.childrens)
+	}
+
+	if row.latitude != 56.85 || row.longitude != 35.9 {
+		t.Errorf("Expected coordinates 56.85/35.9, got %f/%f", row.latitude, row.longitude)
+	}
+}
+
+func TestParseRecordCodes(t *testing.T) {
+	input := "region,settlement,type,population,children,latitude,longitude,ОКТМО,okato,region_oktmo\n" +
+		"Тверская область,Эммаус,п,2000,300,56.95,35.7,28 620 444 101,,28000000\n"
+	src := newTestSource(t, strings.NewReader(input))
+	records := collectRecords(t, strings.NewReader(input))
+	row := parseRecord(records[0], src.columns)
+
+	if row.oktmo != "28620444101" || row.regionOKTMO != "28000000" {
+		t.Errorf("Expected normalized OKTMO codes, got %q/%q", row.oktmo, row.regionOKTMO)
+	}
+	if row.okato != "" || row.regionOKATO != "" {
+		t.Errorf("Expected no OKATO codes, got %q/%q", row.okato, row.regionOKATO)
+	}
+	if len(row.invalid) != 0 {
+		t.Errorf("Expected codes not to be parse errors, got %v", row.invalid)
+	}
+}
+
+func TestParseRecordsSkipsShortRows(t *testing.T) {
+	records := make(chan sourceRecord, pipelineBuffer)
+	for _, rec := range collectRecords(t, strings.NewReader(sampleCSV)) {
+		records <- rec
+	}
+	close(records)
+
+	rows := make(chan settlementRow, pipelineBuffer)
+	report := NewImportReport()
+	columns := newTestSource(t, strings.NewReader(sampleCSV)).columns
+	if err := parseRecords(context.Background(), records, rows, columns, settlementsTypes, report); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	close(rows)
+
+	count := 0
+	for range rows {
+		count++
+	}
+
+	if count != 2 {
+		t.Errorf("Expected 2 parsed rows, got %d", count)
+	}
+
+	if report.Total != 3 {
+		t.Errorf("Expected 3 counted rows, got %d", report.Total)
+	}
+
+	if report.Skipped != 1 || report.Issues[0].Reason != ReasonInsufficientColumns {
+		t.Errorf("Expected one row skipped for insufficient columns, got %+v", report.Issues)
+	}
+
+	if report.Issues[0].Line != 4 {
+		t.Errorf("Expected issue on line 4, got %d", report.Issues[0].Line)
+	}
+}
+
+func TestReadCities(t *testing.T) {
+	input := sampleCSV + `4,Москва,Москва,Москва,г,1000,100,,,55.75,37.62,,,
+5,Москва,Москва,Москва,г,2000,200,,,55.75,37.62,,,
+`
+	cities, report, err := ReadCities(strings.NewReader(input), Options{}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(cities) != 3 {
+		t.Fatalf("Expected 3 cities, got %d", len(cities))
+	}
+
+	moscow := cities[2]
+	if moscow.Name != "Москва" || moscow.Population != 3000 || moscow.Childrens != 300 {
+		t.Errorf("Expected merged Москва 3000/300, got %+v", moscow)
+	}
+
+	if report.Merged != 1 || report.Skipped != 1 {
+		t.Errorf("Expected 1 merged and 1 skipped row, got %s", report.Summary())
+	}
+}
+
+func TestReadCitiesLongitudeConvention(t *testing.T) {
+	input := `id,region,municipality,settlement,type,population,children,lat_dms,lon_dms,latitude,longitude
+1,Чукотский автономный округ,Провиденский,Провидения,пгт,1800,400,,,64.42,-173.23
+`
+	cities, _, err := ReadCities(strings.NewReader(input), Options{Longitude: geo.Positive}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(cities) != 1 || math.Abs(cities[0].Longitude-186.77) > 1e-9 {
+		t.Errorf("Expected longitude 186.77, got %+v", cities)
+	}
+}
+
+func TestReadCitiesTypes(t *testing.T) {
+	input := `id,region,municipality,settlement,type,population,children,lat_dms,lon_dms,latitude,longitude
+1,Московская область,Балашиха,Балашиха,г,500000,90000,,,55.8,37.94
+`
+	cities, _, err := ReadCities(strings.NewReader(input), Options{}, map[string]string{"г": "городской округ"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(cities) != 1 || cities[0].Type != "городской округ" {
+		t.Errorf("Expected the type from the given dictionary, got %+v", cities)
+	}
+}
+
+func TestReadCitiesWithoutHeader(t *testing.T) {
+	input := `1,Тверская область,Тверь,Тверь,г,400000,60000,,,56.85,35.9
+2,Тверская область,Калининский,Эммаус,п,2000,300,,,56.95,35.7
+`
+	cities, report, err := ReadCities(strings.NewReader(input), Options{}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(cities) != 2 || cities[0].Name != "Тверь" {
+		t.Errorf("Expected the first row to be read as data, got %+v", cities)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Reason != ReasonNoHeader || report.Issues[0].Line != 1 {
+		t.Errorf("Expected a no_header warning on line 1, got %+v", report.Issues)
+	}
+}
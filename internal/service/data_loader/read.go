@@ -0,0 +1,79 @@
+package data_loader
+
+import (
+	"errors"
+	"io"
+
+	"settlements/internal/dto"
+)
+
+// ReadCities parses a source into cities without touching the database.
+// Rows are expanded, normalised and merged the same way a load stores them,
+// so the result can be compared with a loaded dataset. Only the profile,
+// the rules, the longitude convention and the format, encoding and
+// delimiter of opts are used; an automatic format reads CSV. Types map
+// abbreviations to type names, as TypeDictionary.Names returns them for
+// loads; the built-in dictionary is used when it is empty.
+func ReadCities(r io.Reader, opts Options, types map[string]string) ([]dto.CityDTO, *ImportReport, error) {
+	report := NewImportReport()
+	opts = opts.withDefaults()
+	if len(types) == 0 {
+		types = settlementsTypes
+	}
+
+	src, input, err := openSource(r, opts, report)
+	if err != nil {
+		return nil, report, err
+	}
+	defer input.Close()
+
+	rows := map[naturalKey]*settlementRow{}
+	order := []naturalKey{}
+
+	for {
+		rec, err := src.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, report, err
+		}
+
+		row, ok := parseOrSkip(rec, src.mapping(), types, report)
+		if !ok || !applyRules(row, opts.Rules, report) {
+			continue
+		}
+
+		key := rowKey(row)
+		acc, seen := rows[key]
+		switch {
+		case !seen:
+			rows[key] = &row
+			order = append(order, key)
+		case isMergedRow(row):
+			acc.population += row.population
+			acc.childrens += row.childrens
+			report.addMerged()
+		default:
+			// The load keeps the last row of a repeated key.
+			*acc = row
+		}
+	}
+
+	cities := make([]dto.CityDTO, 0, len(order))
+	for _, key := range order {
+		row := rows[key]
+		cities = append(cities, dto.CityDTO{
+			Name:       row.settlement,
+			Type:       key.typeName,
+			District:   row.region,
+			Population: row.population,
+			Childrens:  row.childrens,
+			Latitude:   row.latitude,
+			Longitude:  opts.Longitude.Normalize(row.longitude),
+		})
+	}
+	report.addOutcomes(len(cities), 0, 0)
+
+	return cities, report, nil
+}
@@ -0,0 +1,71 @@
+package data_loader
+
+import (
+	"fmt"
+
+	"settlements/internal/models"
+)
+
+// loadedCitiesTable holds the IDs of the cities a load with Options.Replace
+// wrote, so the other cities of the dataset can be deleted once the source
+// is read. Deleting them up front would take the population observations of
+// other years with them.
+const loadedCitiesTable = "loaded_cities"
+
+const createLoadedCitiesSQL = `
+CREATE TEMP TABLE IF NOT EXISTS ` + loadedCitiesTable + ` (
+	id bigint PRIMARY KEY
+) ON COMMIT DROP`
+
+// markLoadedCitySQL tracks the city with the given natural key.
+const markLoadedCitySQL = `
+INSERT INTO ` + loadedCitiesTable + ` (id)
+SELECT id FROM cities
+WHERE dataset_id = ? AND district_id = ? AND name = ? AND type_id = ? AND latitude_key = ? AND longitude_key = ?
+ON CONFLICT DO NOTHING`
+
+// markLoadedStagingSQL tracks the cities of a staged batch.
+const markLoadedStagingSQL = `
+INSERT INTO ` + loadedCitiesTable + ` (id)
+SELECT cities.id
+FROM ` + stagingTable + ` AS s
+JOIN cities ON (cities.dataset_id, cities.district_id, cities.name, cities.type_id, cities.latitude_key, cities.longitude_key)
+	= (s.dataset_id, s.district_id, s.name, s.type_id, s.latitude_key, s.longitude_key)
+ON CONFLICT DO NOTHING`
+
+const deleteUnloadedCitiesSQL = `
+DELETE FROM cities
+WHERE dataset_id = ?
+	AND id NOT IN (SELECT id FROM ` + loadedCitiesTable + `)`
+
+// trackLoadedCities creates the table the cities written by a replacing
+// load are tracked in.
+func (dl *DataLoader) trackLoadedCities() error {
+	if err := dl.db.Exec(createLoadedCitiesSQL).Error; err != nil {
+		return fmt.Errorf("failed to create %s table: %w", loadedCitiesTable, err)
+	}
+	return nil
+}
+
+// markLoadedCity tracks city as written by the load. The city must have
+// been written already.
+func (dl *DataLoader) markLoadedCity(city *models.City) error {
+	err := dl.db.Exec(markLoadedCitySQL,
+		city.DatasetID, city.DistrictID, city.Name, city.TypeID, city.LatitudeKey, city.LongitudeKey,
+	).Error
+	if err != nil {
+		return fmt.Errorf("failed to track city: %w", err)
+	}
+	return nil
+}
+
+// deleteUnloadedCities deletes the cities of the dataset the load did not
+// write and counts them in report.
+func (dl *DataLoader) deleteUnloadedCities(report *ImportReport) error {
+	res := dl.db.Exec(deleteUnloadedCitiesSQL, dl.dataset.ID)
+	if res.Error != nil {
+		return fmt.Errorf("failed to delete previous cities: %w", res.Error)
+	}
+	report.Removed = res.RowsAffected
+	return nil
+}
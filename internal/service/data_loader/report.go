@@ -0,0 +1,261 @@
+package data_loader
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"settlements/internal/service/dedup"
+)
+
+// maxReportedIssues caps the number of issues kept in a report,
+// so a file where every row is broken does not exhaust memory.
+const maxReportedIssues = 10000
+
+// RowStatus is the outcome of a problematic row.
+type RowStatus string
+
+const (
+	StatusWarning RowStatus = "warning"
+	StatusSkipped RowStatus = "skipped"
+	StatusFailed  RowStatus = "failed"
+)
+
+// ReasonCode says why a row was reported. The reasons of the built-in
+// rules double as their names in the rule configuration.
+type ReasonCode string
+
+const (
+	ReasonNoHeader                 ReasonCode = "no_header"
+	ReasonInsufficientColumns      ReasonCode = "insufficient_columns"
+	ReasonParseError               ReasonCode = "parse_error"
+	ReasonEmptyName                ReasonCode = "empty_name"
+	ReasonLatitudeRange            ReasonCode = "latitude_out_of_range"
+	ReasonLongitudeRange           ReasonCode = "longitude_out_of_range"
+	ReasonZeroPopulation           ReasonCode = "zero_population"
+	ReasonChildrenExceedPopulation ReasonCode = "children_exceed_population"
+	ReasonUnknownType              ReasonCode = "unknown_type"
+	ReasonInvalidCode              ReasonCode = "invalid_code"
+	ReasonCodeMismatch             ReasonCode = "code_mismatch"
+	ReasonPersistFailed            ReasonCode = "persist_failed"
+)
+
+// Issue describes a single row that was not loaded, or was loaded with a warning.
+type Issue struct {
+	Line    int        `json:"line"`
+	Status  RowStatus  `json:"status"`
+	Reason  ReasonCode `json:"reason"`
+	Message string     `json:"message"`
+	Record  string     `json:"record"`
+}
+
+// ImportReport summarises a load. It is safe for concurrent use by the pipeline stages.
+type ImportReport struct {
+	// DatasetID and Dataset identify the dataset version the rows were loaded into.
+	DatasetID uint   `json:"datasetId"`
+	Dataset   string `json:"dataset"`
+	// LongitudeConvention names the convention the longitudes were stored in.
+	LongitudeConvention string `json:"longitudeConvention"`
+	// Year is the census year the populations were recorded as, zero when
+	// the load recorded no observations.
+	Year int `json:"year,omitempty"`
+	// Format, Encoding and Delimiter are what the source was read with, as
+	// configured or detected. Encoding is not set for XLSX sources and
+	// Delimiter is only set for CSV sources.
+	Format    string `json:"format"`
+	Encoding  string `json:"encoding,omitempty"`
+	Delimiter string `json:"delimiter,omitempty"`
+	Total     int    `json:"total"`
+	// Created, Updated and Unchanged count the cities written, by what the
+	// upsert did to the city with the same natural key.
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	// Merged counts rows added to another row of the same federal city.
+	Merged int `json:"merged"`
+	// Removed counts the cities a load with Options.Replace deleted because
+	// the source no longer holds them.
+	Removed int64 `json:"removed"`
+	// Warnings counts rule violations of rows that were loaded anyway.
+	Warnings int     `json:"warnings"`
+	Skipped  int     `json:"skipped"`
+	Failed   int     `json:"failed"`
+	Issues   []Issue `json:"issues"`
+	// DroppedIssues counts issues left out of Issues once maxReportedIssues was reached.
+	DroppedIssues int `json:"droppedIssues"`
+	// RolledBack is set when the load failed and none of its rows were kept.
+	RolledBack bool `json:"rolledBack"`
+	// DryRun is set when nothing was written; the counters and Preview say
+	// what the load would have done.
+	DryRun  bool     `json:"dryRun"`
+	Preview *Preview `json:"preview,omitempty"`
+	// Dedup lists the duplicates found after the load when Options.Dedup is
+	// set, and Deduplicated counts the settlements merged away.
+	Dedup        *dedup.Report `json:"dedup,omitempty"`
+	Deduplicated int64         `json:"deduplicated"`
+
+	mu sync.Mutex
+}
+
+// NewImportReport creates an empty report.
+func NewImportReport() *ImportReport {
+	return &ImportReport{Issues: []Issue{}}
+}
+
+func (r *ImportReport) addRow() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Total++
+}
+
+func (r *ImportReport) addOutcomes(created, updated, unchanged int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Created += created
+	r.Updated += updated
+	r.Unchanged += unchanged
+}
+
+// keepReplaced takes cities a replacing dry run found in the source off
+// the cities it would delete.
+func (r *ImportReport) keepReplaced(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Preview.Replaced -= int64(n)
+}
+
+func (r *ImportReport) addMerged() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Merged++
+}
+
+// record counts a successfully persisted row.
+func (r *ImportReport) record(res outcome) {
+	switch res {
+	case outcomeCreated:
+		r.addOutcomes(1, 0, 0)
+	case outcomeUpdated:
+		r.addOutcomes(0, 1, 0)
+	case outcomeUnchanged:
+		r.addOutcomes(0, 0, 1)
+	}
+}
+
+func (r *ImportReport) warn(line int, reason ReasonCode, message string, record []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Warnings++
+	r.addIssue(Issue{Line: line, Status: StatusWarning, Reason: reason, Message: message, Record: joinRecord(record)})
+}
+
+func (r *ImportReport) skip(line int, reason ReasonCode, message string, record []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Skipped++
+	r.addIssue(Issue{Line: line, Status: StatusSkipped, Reason: reason, Message: message, Record: joinRecord(record)})
+}
+
+func (r *ImportReport) fail(line int, reason ReasonCode, message string, record []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Failed++
+	r.addIssue(Issue{Line: line, Status: StatusFailed, Reason: reason, Message: message, Record: joinRecord(record)})
+}
+
+// addIssue appends an issue. The caller must hold r.mu.
+func (r *ImportReport) addIssue(issue Issue) {
+	if len(r.Issues) >= maxReportedIssues {
+		r.DroppedIssues++
+		return
+	}
+	r.Issues = append(r.Issues, issue)
+}
+
+// ErrorRate returns the share of failed rows among all data rows read.
+func (r *ImportReport) ErrorRate() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Total == 0 {
+		return 0
+	}
+	return float64(r.Failed) / float64(r.Total)
+}
+
+// Summary returns a one-line human readable summary of the counters.
+func (r *ImportReport) Summary() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := fmt.Sprintf("total=%d created=%d updated=%d unchanged=%d merged=%d warnings=%d skipped=%d failed=%d",
+		r.Total, r.Created, r.Updated, r.Unchanged, r.Merged, r.Warnings, r.Skipped, r.Failed)
+	if r.Removed > 0 {
+		res += fmt.Sprintf(" removed=%d", r.Removed)
+	}
+	if r.Dedup != nil {
+		res += fmt.Sprintf(" duplicates=%d deduplicated=%d", r.Dedup.Dropped(), r.Deduplicated)
+	}
+	return res
+}
+
+// WriteJSON writes the whole report as an indented JSON document.
+func (r *ImportReport) WriteJSON(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes one line per issue.
+func (r *ImportReport) WriteCSV(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"line", "status", "reason", "message", "record"})
+	for _, issue := range r.Issues {
+		cw.Write([]string{
+			strconv.Itoa(issue.Line),
+			string(issue.Status),
+			string(issue.Reason),
+			issue.Message,
+			issue.Record,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Thresholds are the limits a load report is checked against.
+// A negative value disables the corresponding check.
+type Thresholds struct {
+	MaxFailed  int
+	MaxSkipped int
+}
+
+// Check returns an error when the report exceeds any of the thresholds.
+func (r *ImportReport) Check(t Thresholds) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t.MaxFailed >= 0 && r.Failed > t.MaxFailed {
+		return fmt.Errorf("%d rows failed, limit is %d", r.Failed, t.MaxFailed)
+	}
+	if t.MaxSkipped >= 0 && r.Skipped > t.MaxSkipped {
+		return fmt.Errorf("%d rows skipped, limit is %d", r.Skipped, t.MaxSkipped)
+	}
+	return nil
+}
+
+func joinRecord(record []string) string {
+	var sb strings.Builder
+	cw := csv.NewWriter(&sb)
+	cw.Write(record)
+	cw.Flush()
+	return strings.TrimSuffix(sb.String(), "\n")
+}
@@ -0,0 +1,130 @@
+package data_loader
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+)
+
+func TestImportReportCounters(t *testing.T) {
+	report := NewImportReport()
+	for i := 0; i < 4; i++ {
+		report.addRow()
+	}
+	report.record(outcomeCreated)
+	report.addMerged()
+	report.skip(3, ReasonZeroPopulation, "population is zero", []string{"a", "b"})
+	report.fail(4, ReasonPersistFailed, "boom", []string{"c", "d,e"})
+
+	if report.Total != 4 || report.Created != 1 || report.Merged != 1 || report.Skipped != 1 || report.Failed != 1 {
+		t.Errorf("Unexpected counters: %s", report.Summary())
+	}
+
+	if report.ErrorRate() != 0.25 {
+		t.Errorf("Expected error rate 0.25, got %f", report.ErrorRate())
+	}
+
+	if report.Issues[1].Record != `c,"d,e"` {
+		t.Errorf("Expected CSV-encoded record, got %s", report.Issues[1].Record)
+	}
+}
+
+func TestImportReportRecordOutcomes(t *testing.T) {
+	report := NewImportReport()
+	report.record(outcomeCreated)
+	report.record(outcomeUpdated)
+	report.record(outcomeUpdated)
+	report.record(outcomeUnchanged)
+	report.addOutcomes(10, 5, 1)
+
+	if report.Created != 11 || report.Updated != 7 || report.Unchanged != 2 {
+		t.Errorf("Unexpected counters: %s", report.Summary())
+	}
+}
+
+func TestImportReportIssueCap(t *testing.T) {
+	report := NewImportReport()
+	for i := 0; i < maxReportedIssues+5; i++ {
+		report.fail(i, ReasonPersistFailed, "boom", nil)
+	}
+
+	if len(report.Issues) != maxReportedIssues {
+		t.Errorf("Expected %d issues, got %d", maxReportedIssues, len(report.Issues))
+	}
+
+	if report.DroppedIssues != 5 {
+		t.Errorf("Expected 5 dropped issues, got %d", report.DroppedIssues)
+	}
+}
+
+func TestImportReportWriteJSON(t *testing.T) {
+	report := NewImportReport()
+	report.addRow()
+	report.skip(2, ReasonInsufficientColumns, "short", []string{"x"})
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var decoded struct {
+		Total  int `json:"total"`
+		Issues []Issue
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Expected valid JSON, got %v", err)
+	}
+
+	if decoded.Total != 1 || len(decoded.Issues) != 1 || decoded.Issues[0].Reason != ReasonInsufficientColumns {
+		t.Errorf("Unexpected decoded report: %+v", decoded)
+	}
+}
+
+func TestImportReportWriteCSV(t *testing.T) {
+	report := NewImportReport()
+	report.fail(7, ReasonPersistFailed, "boom", []string{"x"})
+
+	var buf bytes.Buffer
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Expected valid CSV, got %v", err)
+	}
+
+	if len(rows) != 2 {
+		t.Fatalf("Expected header and one issue, got %d rows", len(rows))
+	}
+
+	if rows[1][0] != "7" || rows[1][2] != string(ReasonPersistFailed) {
+		t.Errorf("Unexpected issue row: %v", rows[1])
+	}
+}
+
+func TestImportReportCheck(t *testing.T) {
+	report := NewImportReport()
+	report.fail(1, ReasonPersistFailed, "boom", nil)
+	report.skip(2, ReasonZeroPopulation, "zero", nil)
+	report.skip(3, ReasonZeroPopulation, "zero", nil)
+
+	tests := []struct {
+		name       string
+		thresholds Thresholds
+		wantErr    bool
+	}{
+		{"disabled", Thresholds{MaxFailed: -1, MaxSkipped: -1}, false},
+		{"within limits", Thresholds{MaxFailed: 1, MaxSkipped: 2}, false},
+		{"too many failed", Thresholds{MaxFailed: 0, MaxSkipped: -1}, true},
+		{"too many skipped", Thresholds{MaxFailed: -1, MaxSkipped: 1}, true},
+	}
+
+	for _, test := range tests {
+		err := report.Check(test.thresholds)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: unexpected error state %v", test.name, err)
+		}
+	}
+}
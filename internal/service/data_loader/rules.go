@@ -0,0 +1,257 @@
+package data_loader
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"settlements/internal/models"
+)
+
+// Action is what the loader does with a row that breaks a rule.
+type Action string
+
+const (
+	// ActionWarn loads the row and records a warning.
+	ActionWarn Action = "warn"
+	// ActionSkip leaves the row out and records it as skipped.
+	ActionSkip Action = "skip"
+	// ActionFail leaves the row out and records it as failed, which counts
+	// towards the error rate of the load.
+	ActionFail Action = "fail"
+)
+
+// ParseAction converts a configuration value into an Action.
+func ParseAction(s string) (Action, error) {
+	switch Action(s) {
+	case ActionWarn, ActionSkip, ActionFail:
+		return Action(s), nil
+	}
+	return "", fmt.Errorf("unknown rule action %q (want %q, %q or %q)", s, ActionWarn, ActionSkip, ActionFail)
+}
+
+// Record is the view of a parsed row that rules check.
+type Record struct {
+	Line   int
+	Region string
+	// Municipality is the municipal district or okrug, empty when unknown.
+	Municipality string
+	Settlement   string
+	Type         string
+	// KnownType is set when the type dictionary has the Type abbreviation.
+	KnownType  bool
+	Population int
+	Children   int
+	Latitude   float64
+	Longitude  float64
+	// OKTMO and OKATO are the codes of the settlement, RegionOKTMO and
+	// RegionOKATO those of its region. Empty when the source has none.
+	OKTMO       string
+	OKATO       string
+	RegionOKTMO string
+	RegionOKATO string
+	// Invalid maps the fields whose values could not be parsed to those values.
+	// The numeric value of an invalid field is zero.
+	Invalid map[Field]string
+}
+
+// Rule is a check applied to every parsed row before it is persisted.
+type Rule struct {
+	// Reason identifies the rule in the configuration and in the report.
+	Reason ReasonCode
+	Action Action
+	// Check returns a message describing the problem, or "" when the record passes.
+	Check func(rec Record) string
+}
+
+// Latitude and longitude ranges accepted by the built-in rules. Longitudes
+// are accepted in both the [-180, 180] and the [0, 360] convention.
+const (
+	minLatitude  = -90
+	maxLatitude  = 90
+	minLongitude = -180
+	maxLongitude = 360
+)
+
+// DefaultRules returns the built-in rules with their default actions, in the
+// order they are checked.
+func DefaultRules() []Rule {
+	return []Rule{
+		{Reason: ReasonParseError, Action: ActionSkip, Check: checkParsed},
+		{Reason: ReasonEmptyName, Action: ActionSkip, Check: checkNames},
+		{Reason: ReasonLatitudeRange, Action: ActionSkip, Check: checkLatitude},
+		{Reason: ReasonLongitudeRange, Action: ActionSkip, Check: checkLongitude},
+		{Reason: ReasonZeroPopulation, Action: ActionSkip, Check: checkPopulation},
+		{Reason: ReasonChildrenExceedPopulation, Action: ActionWarn, Check: checkChildren},
+		{Reason: ReasonUnknownType, Action: ActionSkip, Check: checkType},
+		{Reason: ReasonInvalidCode, Action: ActionWarn, Check: checkCodes},
+		{Reason: ReasonCodeMismatch, Action: ActionWarn, Check: checkCodeSubjects},
+	}
+}
+
+// ConfigureRules returns a copy of rules with the actions overridden by spec,
+// a comma-separated list of reason=action pairs such as
+// "latitude_out_of_range=fail,unknown_type=warn".
+func ConfigureRules(rules []Rule, spec string) ([]Rule, error) {
+	res := append([]Rule(nil), rules...)
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		reason, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rule setting %q (want reason=action)", pair)
+		}
+		action, err := ParseAction(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+
+		found := false
+		for i := range res {
+			if res[i].Reason == ReasonCode(strings.TrimSpace(reason)) {
+				res[i].Action = action
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown rule %q (known: %s)", reason, strings.Join(ruleReasons(res), ", "))
+		}
+	}
+	return res, nil
+}
+
+func ruleReasons(rules []Rule) []string {
+	res := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		res = append(res, string(rule.Reason))
+	}
+	return res
+}
+
+// applyRules checks row against rules and records every broken rule in the
+// report. It returns false when the row must not be persisted.
+func applyRules(row settlementRow, rules []Rule, report *ImportReport) bool {
+	rec := row.record()
+	for _, rule := range rules {
+		msg := rule.Check(rec)
+		if msg == "" {
+			continue
+		}
+
+		switch rule.Action {
+		case ActionWarn:
+			report.warn(row.line, rule.Reason, msg, row.raw)
+		case ActionFail:
+			report.fail(row.line, rule.Reason, msg, row.raw)
+			return false
+		default:
+			report.skip(row.line, rule.Reason, msg, row.raw)
+			return false
+		}
+	}
+	return true
+}
+
+func checkParsed(rec Record) string {
+	if len(rec.Invalid) == 0 {
+		return ""
+	}
+
+	fields := make([]string, 0, len(rec.Invalid))
+	for field := range rec.Invalid {
+		fields = append(fields, string(field))
+	}
+	sort.Strings(fields)
+
+	parts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		parts = append(parts, fmt.Sprintf("invalid %s %q", field, rec.Invalid[Field(field)]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func checkNames(rec Record) string {
+	switch {
+	case rec.Region == "":
+		return "region is empty"
+	case rec.Settlement == "":
+		return "settlement name is empty"
+	}
+	return ""
+}
+
+func checkLatitude(rec Record) string {
+	if rec.Latitude < minLatitude || rec.Latitude > maxLatitude {
+		return fmt.Sprintf("latitude %g is outside [%d, %d]", rec.Latitude, minLatitude, maxLatitude)
+	}
+	return ""
+}
+
+func checkLongitude(rec Record) string {
+	if rec.Longitude < minLongitude || rec.Longitude > maxLongitude {
+		return fmt.Sprintf("longitude %g is outside [%d, %d]", rec.Longitude, minLongitude, maxLongitude)
+	}
+	return ""
+}
+
+func checkPopulation(rec Record) string {
+	if rec.Population == 0 {
+		return "population is zero"
+	}
+	return ""
+}
+
+func checkChildren(rec Record) string {
+	if rec.Children > rec.Population {
+		return fmt.Sprintf("children %d exceed population %d", rec.Children, rec.Population)
+	}
+	return ""
+}
+
+// checkType reports types missing from the dictionary. Such rows are
+// skipped by default: loaded with a warning, they add a type named after
+// the abbreviation to the dictionary.
+func checkType(rec Record) string {
+	if !rec.KnownType {
+		return fmt.Sprintf("unknown type abbreviation %q", rec.Type)
+	}
+	return ""
+}
+
+// checkCodes reports classifier codes that are not well-formed. The loader
+// does not store such codes; the rest of the row is loaded as usual.
+func checkCodes(rec Record) string {
+	var parts []string
+	check := func(field Field, scheme models.CodeScheme, code string) {
+		if code != "" && !scheme.Valid(code) {
+			parts = append(parts, fmt.Sprintf("invalid %s %q", field, code))
+		}
+	}
+
+	check(FieldOKTMO, models.SchemeOKTMO, rec.OKTMO)
+	check(FieldOKATO, models.SchemeOKATO, rec.OKATO)
+	check(FieldRegionOKTMO, models.SchemeOKTMO, rec.RegionOKTMO)
+	check(FieldRegionOKATO, models.SchemeOKATO, rec.RegionOKATO)
+	return strings.Join(parts, ", ")
+}
+
+// checkCodeSubjects reports settlement codes of another federal subject than
+// the code of the region the row claims. Codes that are not well-formed are
+// left to checkCodes.
+func checkCodeSubjects(rec Record) string {
+	var parts []string
+	check := func(scheme models.CodeScheme, code, region string) {
+		if scheme.Valid(code) && scheme.Valid(region) && !models.SameSubject(code, region) {
+			name := strings.ToUpper(string(scheme))
+			parts = append(parts, fmt.Sprintf("%s %s is outside region %q (%s %s)", name, code, rec.Region, name, region))
+		}
+	}
+
+	check(models.SchemeOKTMO, rec.OKTMO, rec.RegionOKTMO)
+	check(models.SchemeOKATO, rec.OKATO, rec.RegionOKATO)
+	return strings.Join(parts, ", ")
+}
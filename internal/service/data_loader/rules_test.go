@@ -0,0 +1,174 @@
+package data_loader
+
+import (
+	"strings"
+	"testing"
+)
+
+func validRow() settlementRow {
+	return settlementRow{
+		line:       2,
+		region:     "Тверская область",
+		settlement: "Эммаус",
+		typeShort:  "п",
+		typeName:   "поселок",
+		knownType:  true,
+		population: 2000,
+		childrens:  300,
+		latitude:   56.95,
+		longitude:  35.7,
+	}
+}
+
+func TestParseRecordInvalidValues(t *testing.T) {
+	input := "id,region,municipality,settlement,type,population,children,lat_dms,lon_dms,latitude,longitude\n" +
+		"1,Тверская область,Калининский,Эммаус,п,2 000,,,,56.95,east\n"
+	src := newTestSource(t, strings.NewReader(input))
+	records := collectRecords(t, strings.NewReader(input))
+	row := parseRecord(records[0], src.columns)
+
+	if row.invalid[FieldPopulation] != "2 000" || row.invalid[FieldLongitude] != "east" {
+		t.Errorf("Expected invalid population and longitude, got %v", row.invalid)
+	}
+
+	if _, ok := row.invalid[FieldChildren]; ok {
+		t.Errorf("Expected empty optional children to be valid")
+	}
+
+	if row.population != 0 || row.longitude != 0 || row.latitude != 56.95 {
+		t.Errorf("Expected invalid values zeroed, got %d/%f/%f", row.population, row.latitude, row.longitude)
+	}
+}
+
+func TestDefaultRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(row *settlementRow)
+		reason ReasonCode
+		status RowStatus
+	}{
+		{"parse error", func(r *settlementRow) { r.invalid = map[Field]string{FieldLatitude: "north"} }, ReasonParseError, StatusSkipped},
+		{"empty name", func(r *settlementRow) { r.settlement = "" }, ReasonEmptyName, StatusSkipped},
+		{"latitude", func(r *settlementRow) { r.latitude = 91 }, ReasonLatitudeRange, StatusSkipped},
+		{"longitude", func(r *settlementRow) { r.longitude = -181 }, ReasonLongitudeRange, StatusSkipped},
+		{"zero population", func(r *settlementRow) { r.population = 0; r.childrens = 0 }, ReasonZeroPopulation, StatusSkipped},
+		{"children", func(r *settlementRow) { r.childrens = 3000 }, ReasonChildrenExceedPopulation, StatusWarning},
+		{"unknown type", func(r *settlementRow) { r.typeShort, r.knownType = "xyz", false }, ReasonUnknownType, StatusSkipped},
+		{"invalid code", func(r *settlementRow) { r.oktmo = "2870100" }, ReasonInvalidCode, StatusWarning},
+		{"code mismatch", func(r *settlementRow) { r.oktmo, r.regionOKTMO = "45301000", "28000000" }, ReasonCodeMismatch, StatusWarning},
+	}
+
+	for _, test := range tests {
+		row := validRow()
+		test.modify(&row)
+		report := NewImportReport()
+
+		accepted := applyRules(row, DefaultRules(), report)
+
+		if accepted != (test.status == StatusWarning) {
+			t.Errorf("%s: unexpected accepted=%v", test.name, accepted)
+		}
+		if len(report.Issues) != 1 {
+			t.Fatalf("%s: expected 1 issue, got %+v", test.name, report.Issues)
+		}
+		if report.Issues[0].Reason != test.reason || report.Issues[0].Status != test.status {
+			t.Errorf("%s: expected %s/%s, got %s/%s", test.name, test.reason, test.status,
+				report.Issues[0].Reason, report.Issues[0].Status)
+		}
+	}
+}
+
+func TestCheckCodes(t *testing.T) {
+	rec := validRow().record()
+	rec.OKTMO, rec.OKATO, rec.RegionOKTMO = "28701000", "28401000000", "28000000"
+	if msg := checkCodes(rec); msg != "" {
+		t.Errorf("Expected well-formed codes to pass, got %q", msg)
+	}
+	if msg := checkCodeSubjects(rec); msg != "" {
+		t.Errorf("Expected codes of the same subject to pass, got %q", msg)
+	}
+
+	rec.OKATO, rec.RegionOKATO = "2840100000x", "45"
+	if msg := checkCodes(rec); !strings.Contains(msg, "okato") || strings.Contains(msg, "region_okato") {
+		t.Errorf("Expected only the settlement OKATO to be invalid, got %q", msg)
+	}
+	if msg := checkCodeSubjects(rec); msg != "" {
+		t.Errorf("Expected an invalid code not to be compared, got %q", msg)
+	}
+
+	rec.OKATO = "28401000000"
+	if msg := checkCodeSubjects(rec); !strings.Contains(msg, "OKATO 28401000000") {
+		t.Errorf("Expected the OKATO mismatch, got %q", msg)
+	}
+}
+
+func TestApplyRulesValidRow(t *testing.T) {
+	report := NewImportReport()
+
+	if !applyRules(validRow(), DefaultRules(), report) {
+		t.Errorf("Expected valid row to be accepted")
+	}
+
+	if len(report.Issues) != 0 {
+		t.Errorf("Expected no issues, got %+v", report.Issues)
+	}
+}
+
+func TestConfigureRules(t *testing.T) {
+	rules, err := ConfigureRules(DefaultRules(), "latitude_out_of_range=fail, unknown_type=warn")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	row := validRow()
+	row.latitude = -95
+	report := NewImportReport()
+	applyRules(row, rules, report)
+
+	if report.Failed != 1 || report.Issues[0].Reason != ReasonLatitudeRange {
+		t.Errorf("Expected latitude rule to fail the row, got %s", report.Summary())
+	}
+
+	row = validRow()
+	row.typeShort, row.knownType = "xyz", false
+	report = NewImportReport()
+	applyRules(row, rules, report)
+
+	if report.Skipped != 0 || report.Warnings != 1 {
+		t.Errorf("Expected unknown type to only warn, got %s", report.Summary())
+	}
+
+	if DefaultRules()[2].Action != ActionSkip {
+		t.Errorf("Expected ConfigureRules to leave the defaults untouched")
+	}
+}
+
+func TestConfigureRulesErrors(t *testing.T) {
+	for _, spec := range []string{"latitude_out_of_range", "latitude_out_of_range=ignore", "no_such_rule=warn"} {
+		if _, err := ConfigureRules(DefaultRules(), spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
+
+func TestCustomRule(t *testing.T) {
+	rules := append(DefaultRules(), Rule{
+		Reason: "too_far_north",
+		Action: ActionFail,
+		Check: func(rec Record) string {
+			if rec.Latitude > 56.9 {
+				return "too far north"
+			}
+			return ""
+		},
+	})
+	report := NewImportReport()
+
+	if applyRules(validRow(), rules, report) {
+		t.Errorf("Expected custom rule to reject the row")
+	}
+
+	if report.Failed != 1 || report.Issues[0].Reason != "too_far_north" {
+		t.Errorf("Expected custom rule failure, got %+v", report.Issues)
+	}
+}
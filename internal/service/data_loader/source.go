@@ -0,0 +1,207 @@
+package data_loader
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gzipMagic is the two-byte header every gzip stream starts with.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// OpenSource opens the dataset at path for reading. A path of "-" means stdin.
+func OpenSource(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	return file, nil
+}
+
+// decompress returns r unchanged unless it starts with a gzip header,
+// in which case it returns a reader over the decompressed stream.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	head, err := br.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+
+	if len(head) == len(gzipMagic) && head[0] == gzipMagic[0] && head[1] == gzipMagic[1] {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		return gz, nil
+	}
+
+	return io.NopCloser(br), nil
+}
+
+// Format is the format of a source file.
+type Format string
+
+const (
+	// FormatAuto picks the format by the extension of the source name.
+	FormatAuto Format = ""
+	FormatCSV  Format = "csv"
+	// FormatGeoJSON is a FeatureCollection of Point features whose
+	// properties hold the other fields.
+	FormatGeoJSON Format = "geojson"
+	// FormatNDJSON is one JSON object per line.
+	FormatNDJSON Format = "ndjson"
+	// FormatXLSX is a worksheet of an Excel workbook, read from
+	// Options.Sheet with the header after Options.HeaderOffset rows.
+	FormatXLSX Format = "xlsx"
+)
+
+// ParseFormat converts a configuration value into a Format.
+// "auto" and the empty string select detection by extension.
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case FormatAuto, FormatCSV, FormatGeoJSON, FormatNDJSON, FormatXLSX:
+		return Format(s), nil
+	case "auto":
+		return FormatAuto, nil
+	}
+	return "", fmt.Errorf("unknown format %q (want auto, %q, %q, %q or %q)", s, FormatCSV, FormatGeoJSON, FormatNDJSON, FormatXLSX)
+}
+
+// formatExtensions maps file extensions to the formats they name.
+var formatExtensions = map[string]Format{
+	".csv":     FormatCSV,
+	".tsv":     FormatCSV,
+	".geojson": FormatGeoJSON,
+	".json":    FormatGeoJSON,
+	".ndjson":  FormatNDJSON,
+	".jsonl":   FormatNDJSON,
+	".xlsx":    FormatXLSX,
+}
+
+// DetectFormat picks the format of the source named name by its extension,
+// ignoring a trailing ".gz". Unknown extensions and stdin are read as CSV.
+func DetectFormat(name string) Format {
+	ext := strings.ToLower(filepath.Ext(strings.TrimSuffix(strings.ToLower(name), ".gz")))
+	if f, ok := formatExtensions[ext]; ok {
+		return f
+	}
+	return FormatCSV
+}
+
+// withFormatOf returns opts with an automatic format resolved for the source name.
+func (opts Options) withFormatOf(name string) Options {
+	if opts.Format == FormatAuto {
+		opts.Format = DetectFormat(name)
+	}
+	return opts
+}
+
+// recordSource yields the raw records of one source format. Every format
+// binds its records to the profile, so all of them parse into the same
+// settlement rows.
+type recordSource interface {
+	// next returns the next record, or io.EOF after the last one.
+	next() (sourceRecord, error)
+	// mapping locates the settlement fields in the records.
+	mapping() *columnMap
+}
+
+// openSource prepares r for reading in the format of opts: it is
+// decompressed, transcoded to UTF-8 from the configured or detected encoding
+// and bound to the profile. CSV sources also detect their delimiter unless
+// one is configured. XLSX workbooks are binary and are not transcoded.
+// The format, encoding and delimiter are recorded in report. The returned
+// closer releases the decompressor and the source and must be closed once
+// reading is done.
+func openSource(r io.Reader, opts Options, report *ImportReport) (recordSource, io.Closer, error) {
+	input, err := decompress(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	src, err := newRecordSource(input, opts, report)
+	if err != nil {
+		input.Close()
+		return nil, nil, err
+	}
+	if c, ok := src.(io.Closer); ok {
+		return src, closers{c, input}, nil
+	}
+	return src, input, nil
+}
+
+// closers closes all of its elements in order and returns their errors joined.
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	var errs []error
+	for _, c := range cs {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func newRecordSource(input io.Reader, opts Options, report *ImportReport) (recordSource, error) {
+	format := opts.Format
+	if format == FormatAuto {
+		format = FormatCSV
+	}
+	report.Format = string(format)
+
+	if format == FormatXLSX {
+		src, err := newXLSXSource(input, opts)
+		if err != nil {
+			return nil, err
+		}
+		warnHeaderless(src.first, report)
+		return src, nil
+	}
+
+	text, enc, err := decode(input, opts.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	report.Encoding = string(enc)
+
+	switch format {
+	case FormatGeoJSON:
+		return newGeoJSONSource(text, opts.Profile)
+	case FormatNDJSON:
+		return newNDJSONSource(text, opts.Profile), nil
+	}
+
+	comma := opts.Delimiter
+	if comma == 0 {
+		if text, comma, err = peekDelimiter(text); err != nil {
+			return nil, err
+		}
+	}
+	report.Delimiter = delimiterName(comma)
+
+	src, err := newCSVSource(text, opts.Profile, comma)
+	if err != nil {
+		return nil, err
+	}
+	warnHeaderless(src.first, report)
+	return src, nil
+}
+
+// warnHeaderless reports the first row of a source read without a header,
+// whose columns are taken by position: a file with an unexpected header ends
+// up read this way too.
+func warnHeaderless(first *sourceRecord, report *ImportReport) {
+	if first != nil {
+		report.warn(first.line, ReasonNoHeader, "the first row matches no column name of the profile, columns are read by position", first.fields)
+	}
+}
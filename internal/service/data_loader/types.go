@@ -0,0 +1,294 @@
+package data_loader
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"settlements/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// typeNames maps settlement type abbreviations to type names.
+type typeNames map[string]string
+
+// expand returns the type name of an abbreviation and whether the abbreviation
+// is known. Unknown abbreviations are kept as is.
+func (t typeNames) expand(short string) (string, bool) {
+	if v, ok := t[short]; ok {
+		return v, true
+	}
+	return short, false
+}
+
+// TypeEntry is a settlement type with its abbreviations.
+type TypeEntry struct {
+	ID            uint     `json:"id"`
+	Name          string   `json:"name"`
+	Abbreviations []string `json:"abbreviations"`
+	Cities        int64    `json:"cities"`
+}
+
+// MergeResult says what merging two types changed.
+type MergeResult struct {
+	// Moved counts the cities re-pointed to the surviving type.
+	Moved int64 `json:"moved"`
+	// Merged counts the cities deleted because the surviving type already
+	// had the same city (same dataset, district, name and coordinates).
+	// Their population, childrens and observations were added to that city.
+	Merged int64 `json:"merged"`
+}
+
+// ErrTypeNotFound is returned when an abbreviation or type name is not in the dictionary.
+var ErrTypeNotFound = errors.New("type not found")
+
+// TypeDictionary manages the settlement type abbreviations stored in the
+// database. It is seeded with the built-in abbreviations on first use.
+type TypeDictionary struct {
+	db *gorm.DB
+}
+
+func NewTypeDictionary(db *gorm.DB) *TypeDictionary {
+	return &TypeDictionary{db: db}
+}
+
+// Seed stores the built-in abbreviations when the dictionary is empty.
+// Types that already exist under the same name are linked, not duplicated.
+func (d *TypeDictionary) Seed() error {
+	var count int64
+	if err := d.db.Model(&models.TypeAbbreviation{}).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to count type abbreviations: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	abbreviations := make([]string, 0, len(settlementsTypes))
+	for short := range settlementsTypes {
+		abbreviations = append(abbreviations, short)
+	}
+	sort.Strings(abbreviations)
+
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		for _, short := range abbreviations {
+			if err := NewTypeDictionary(tx).Add(short, settlementsTypes[short]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// List returns every type with its abbreviations and number of cities, by name.
+func (d *TypeDictionary) List() ([]TypeEntry, error) {
+	var types []models.Type
+	if err := d.db.Preload("Abbreviations").Order("name, id").Find(&types).Error; err != nil {
+		return nil, fmt.Errorf("failed to list types: %w", err)
+	}
+
+	var counts []struct {
+		TypeID uint
+		Cities int64
+	}
+	err := d.db.Model(&models.City{}).Select("type_id, COUNT(*) AS cities").Group("type_id").Scan(&counts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count cities per type: %w", err)
+	}
+	cities := map[uint]int64{}
+	for _, c := range counts {
+		cities[c.TypeID] = c.Cities
+	}
+
+	res := make([]TypeEntry, 0, len(types))
+	for _, t := range types {
+		entry := TypeEntry{ID: t.ID, Name: t.Name, Abbreviations: []string{}, Cities: cities[t.ID]}
+		for _, a := range t.Abbreviations {
+			entry.Abbreviations = append(entry.Abbreviations, a.Abbreviation)
+		}
+		sort.Strings(entry.Abbreviations)
+		res = append(res, entry)
+	}
+	return res, nil
+}
+
+// Add links an abbreviation to the type with the given name, creating the
+// type when there is none. Re-adding an existing pair is a no-op.
+func (d *TypeDictionary) Add(abbreviation, name string) error {
+	abbreviation, name = strings.TrimSpace(abbreviation), strings.TrimSpace(name)
+	if abbreviation == "" || name == "" {
+		return fmt.Errorf("abbreviation and type name must not be empty")
+	}
+
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		var typeM models.Type
+		if err := tx.Where("name = ?", name).Order("id").FirstOrCreate(&typeM, models.Type{Name: name}).Error; err != nil {
+			return fmt.Errorf("failed to create/find type: %w", err)
+		}
+		return link(tx, abbreviation, typeM)
+	})
+}
+
+// Alias links a new abbreviation to an existing type, given by one of its
+// abbreviations or by its name.
+func (d *TypeDictionary) Alias(alias, target string) error {
+	alias = strings.TrimSpace(alias)
+	if alias == "" {
+		return fmt.Errorf("alias must not be empty")
+	}
+
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		typeM, err := findType(tx, target)
+		if err != nil {
+			return err
+		}
+		return link(tx, alias, typeM)
+	})
+}
+
+// sameCityOfTypes matches a city s of one type with the city t of another
+// type it would collide with under the natural key.
+const sameCityOfTypes = `s.type_id = ? AND t.type_id = ?
+	AND s.dataset_id = t.dataset_id
+	AND s.district_id = t.district_id
+	AND s.name = t.name
+	AND s.latitude_key = t.latitude_key
+	AND s.longitude_key = t.longitude_key`
+
+// mergeTypeObservationsSQL adds the population observations of the colliding
+// cities of a type, year by year, to those of their counterparts.
+const mergeTypeObservationsSQL = `
+INSERT INTO population_observations (city_id, year, population, childrens, source)
+SELECT t.id, o.year, o.population, o.childrens, o.source
+FROM cities s
+JOIN cities t ON ` + sameCityOfTypes + `
+JOIN population_observations o ON o.city_id = s.id
+ON CONFLICT (city_id, year) DO UPDATE
+SET population = population_observations.population + EXCLUDED.population,
+	childrens = population_observations.childrens + EXCLUDED.childrens`
+
+// mergeTypeCitiesSQL adds the population and childrens of the colliding
+// cities of a type to their counterparts.
+const mergeTypeCitiesSQL = `
+UPDATE cities t
+SET population = t.population + s.population,
+	childrens = t.childrens + s.childrens
+FROM cities s
+WHERE ` + sameCityOfTypes
+
+// Merge folds the type from into the type into, both given by an abbreviation
+// or a name: cities and abbreviations of from are re-pointed to into, and
+// from is deleted. A city of from that into already has is merged into it
+// the way dedup merges duplicates: its numbers are summed into the city of
+// into, per year for the observations, and it is deleted.
+func (d *TypeDictionary) Merge(from, into string) (MergeResult, error) {
+	var res MergeResult
+
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		source, err := findType(tx, from)
+		if err != nil {
+			return err
+		}
+		target, err := findType(tx, into)
+		if err != nil {
+			return err
+		}
+		if source.ID == target.ID {
+			return fmt.Errorf("%q and %q are the same type", from, into)
+		}
+
+		// Cities present under both types would break the natural key once
+		// re-pointed, so they are folded into the city of the surviving type.
+		folds := []struct{ what, sql string }{
+			{"population history", mergeTypeObservationsSQL},
+			{"population", mergeTypeCitiesSQL},
+		}
+		for _, f := range folds {
+			if err := tx.Exec(f.sql, source.ID, target.ID).Error; err != nil {
+				return fmt.Errorf("failed to merge the %s of duplicate cities: %w", f.what, err)
+			}
+		}
+
+		merged := tx.Exec(`DELETE FROM cities s USING cities t
+			WHERE `+sameCityOfTypes, source.ID, target.ID)
+		if merged.Error != nil {
+			return fmt.Errorf("failed to delete duplicate cities: %w", merged.Error)
+		}
+		res.Merged = merged.RowsAffected
+
+		moved := tx.Model(&models.City{}).Where("type_id = ?", source.ID).Update("type_id", target.ID)
+		if moved.Error != nil {
+			return fmt.Errorf("failed to re-point cities: %w", moved.Error)
+		}
+		res.Moved = moved.RowsAffected
+
+		err = tx.Model(&models.TypeAbbreviation{}).Where("type_id = ?", source.ID).Update("type_id", target.ID).Error
+		if err != nil {
+			return fmt.Errorf("failed to move abbreviations: %w", err)
+		}
+
+		if err := tx.Delete(&models.Type{}, source.ID).Error; err != nil {
+			return fmt.Errorf("failed to delete type: %w", err)
+		}
+		return nil
+	})
+
+	return res, err
+}
+
+// Names returns the abbreviation to type name mapping used by loads.
+func (d *TypeDictionary) Names() (map[string]string, error) {
+	var abbreviations []models.TypeAbbreviation
+	if err := d.db.Preload("Type").Find(&abbreviations).Error; err != nil {
+		return nil, fmt.Errorf("failed to read type abbreviations: %w", err)
+	}
+
+	res := make(map[string]string, len(abbreviations))
+	for _, a := range abbreviations {
+		res[a.Abbreviation] = a.Type.Name
+	}
+	return res, nil
+}
+
+// link points abbreviation at typeM. It fails when the abbreviation already
+// names another type.
+func link(tx *gorm.DB, abbreviation string, typeM models.Type) error {
+	abbr := models.TypeAbbreviation{Abbreviation: abbreviation, TypeID: typeM.ID}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&abbr).Error; err != nil {
+		return fmt.Errorf("failed to add abbreviation: %w", err)
+	}
+
+	var existing models.TypeAbbreviation
+	if err := tx.Preload("Type").Where("abbreviation = ?", abbreviation).First(&existing).Error; err != nil {
+		return fmt.Errorf("failed to read abbreviation: %w", err)
+	}
+	if existing.TypeID != typeM.ID {
+		return fmt.Errorf("abbreviation %q already names type %q", abbreviation, existing.Type.Name)
+	}
+	return nil
+}
+
+// findType looks a type up by abbreviation, then by name.
+func findType(tx *gorm.DB, ref string) (models.Type, error) {
+	ref = strings.TrimSpace(ref)
+
+	var abbr models.TypeAbbreviation
+	err := tx.Preload("Type").Where("abbreviation = ?", ref).Limit(1).Find(&abbr).Error
+	if err != nil {
+		return models.Type{}, fmt.Errorf("failed to find type: %w", err)
+	}
+	if abbr.ID != 0 {
+		return abbr.Type, nil
+	}
+
+	var types []models.Type
+	if err := tx.Where("name = ?", ref).Order("id").Limit(1).Find(&types).Error; err != nil {
+		return models.Type{}, fmt.Errorf("failed to find type: %w", err)
+	}
+	if len(types) == 0 {
+		return models.Type{}, fmt.Errorf("%w: %q", ErrTypeNotFound, ref)
+	}
+	return types[0], nil
+}
@@ -0,0 +1,82 @@
+package data_loader
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
+)
+
+// idCache resolves names to database IDs once per load. Concurrent lookups
+// of the same name share a single call of resolve, so two workers never
+// create the same type or district twice.
+type idCache struct {
+	ids     sync.Map
+	group   singleflight.Group
+	resolve func(name string) (uint, error)
+}
+
+func newIDCache(resolve func(name string) (uint, error)) *idCache {
+	return &idCache{resolve: resolve}
+}
+
+func (c *idCache) get(name string) (uint, error) {
+	if id, ok := c.ids.Load(name); ok {
+		return id.(uint), nil
+	}
+
+	id, err, _ := c.group.Do(name, func() (any, error) {
+		if id, ok := c.ids.Load(name); ok {
+			return id, nil
+		}
+
+		id, err := c.resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		c.ids.Store(name, id)
+		return id, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id.(uint), nil
+}
+
+// shardOf picks the persist worker of a row among n. Rows with the same
+// natural key always go to the same worker, so the rows of a federal city
+// are summed by one worker and repeated keys are never written concurrently.
+func shardOf(row settlementRow, n int) int {
+	if n <= 1 {
+		return 0
+	}
+
+	key := rowKey(row)
+	h := fnv.New32a()
+	for _, s := range []string{key.region, key.settlement, key.typeName} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	h.Write(strconv.AppendInt(nil, key.latitudeKey, 10))
+	h.Write(strconv.AppendInt(nil, key.longitudeKey, 10))
+	return int(h.Sum32() % uint32(n))
+}
+
+// runStage runs fn in n goroutines of g and calls done once all of them have returned.
+func runStage(g *errgroup.Group, n int, done func(), fn func() error) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		g.Go(func() error {
+			defer wg.Done()
+			return fn()
+		})
+	}
+	g.Go(func() error {
+		wg.Wait()
+		done()
+		return nil
+	})
+}
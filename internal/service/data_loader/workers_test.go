@@ -0,0 +1,125 @@
+package data_loader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func TestIDCacheResolvesOnce(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	cache := newIDCache(func(name string) (uint, error) {
+		calls.Add(1)
+		<-release
+		return 7, nil
+	})
+
+	var wg sync.WaitGroup
+	ids := make([]uint, 8)
+	for i := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids[i], _ = cache.get("Тверская область")
+		}()
+	}
+	close(release)
+	wg.Wait()
+
+	if id, _ := cache.get("Тверская область"); id != 7 {
+		t.Errorf("Expected cached ID 7, got %d", id)
+	}
+	for _, id := range ids {
+		if id != 7 {
+			t.Errorf("Expected every worker to get ID 7, got %v", ids)
+			break
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Expected the name to be resolved once, got %d calls", calls.Load())
+	}
+}
+
+func TestIDCacheRetriesErrors(t *testing.T) {
+	fail := true
+	cache := newIDCache(func(name string) (uint, error) {
+		if fail {
+			return 0, errors.New("connection lost")
+		}
+		return 3, nil
+	})
+
+	if _, err := cache.get("город"); err == nil {
+		t.Fatal("Expected the resolve error")
+	}
+	fail = false
+	if id, err := cache.get("город"); err != nil || id != 3 {
+		t.Errorf("Expected a failed lookup not to be cached, got %d, %v", id, err)
+	}
+}
+
+func TestShardOf(t *testing.T) {
+	moscow := settlementRow{region: "Москва", settlement: "Москва", typeName: "город", latitude: 55.75, longitude: 37.62}
+	again := moscow
+	again.population = 1000
+
+	if shardOf(moscow, 1) != 0 {
+		t.Error("Expected a single worker to get every row")
+	}
+
+	for n := 2; n <= 8; n++ {
+		s := shardOf(moscow, n)
+		if s < 0 || s >= n {
+			t.Errorf("Expected a shard in [0, %d), got %d", n, s)
+		}
+		if shardOf(again, n) != s {
+			t.Errorf("Expected rows with the same key to share a shard of %d", n)
+		}
+	}
+}
+
+func TestRunStage(t *testing.T) {
+	g, _ := errgroup.WithContext(context.Background())
+
+	var ran atomic.Int32
+	done := make(chan struct{})
+	runStage(g, 4, func() { close(done) }, func() error {
+		ran.Add(1)
+		return nil
+	})
+
+	if err := g.Wait(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	<-done
+	if ran.Load() != 4 {
+		t.Errorf("Expected 4 goroutines, got %d", ran.Load())
+	}
+}
+
+func TestPersistKeepsLatestLine(t *testing.T) {
+	report := NewImportReport()
+	dl := newTestPlanWriter(report, map[naturalKey]cityValues{}).dl
+	dl.opts.DryRun = true
+
+	row := settlementRow{region: "Тверская область", settlement: "Тверь", typeName: "город", population: 400000, latitude: 56.85, longitude: 35.9}
+	later, earlier := row, row
+	later.line, earlier.line = 5, 3
+
+	in := make(chan settlementRow, 2)
+	in <- later
+	in <- earlier
+	close(in)
+
+	if err := dl.persist(context.Background(), in, map[naturalKey]cityValues{}, report); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if report.Created != 1 || report.Updated != 1 {
+		t.Errorf("Expected the earlier line to count as overwritten, got %s", report.Summary())
+	}
+}
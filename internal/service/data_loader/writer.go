@@ -0,0 +1,323 @@
+package data_loader
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/gorm"
+)
+
+// upsertCitySQL inserts a city or updates the one with the same natural key.
+// It returns one row telling whether the city was inserted, or no row at all
+// when the existing city already had the same values.
+const upsertCitySQL = `
+INSERT INTO cities (dataset_id, name, type_id, district_id, population, childrens, latitude, longitude, latitude_key, longitude_key, admin_unit_id, oktmo, okato)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (dataset_id, district_id, name, type_id, latitude_key, longitude_key) DO UPDATE
+SET population = EXCLUDED.population,
+	childrens = EXCLUDED.childrens,
+	latitude = EXCLUDED.latitude,
+	longitude = EXCLUDED.longitude,
+	admin_unit_id = EXCLUDED.admin_unit_id,
+	oktmo = EXCLUDED.oktmo,
+	okato = EXCLUDED.okato
+WHERE (cities.population, cities.childrens, cities.latitude, cities.longitude, cities.admin_unit_id, cities.oktmo, cities.okato)
+	IS DISTINCT FROM (EXCLUDED.population, EXCLUDED.childrens, EXCLUDED.latitude, EXCLUDED.longitude, EXCLUDED.admin_unit_id, EXCLUDED.oktmo, EXCLUDED.okato)
+RETURNING (xmax = 0) AS inserted`
+
+// stagingTable receives COPY batches in bulk mode before they are upserted into cities.
+const stagingTable = "city_staging"
+
+// stagingColumns are the columns of the staging table written by COPY.
+// seq keeps the source order, so the last row wins when a batch repeats a key.
+var stagingColumns = []string{
+	"seq", "dataset_id", "name", "type_id", "district_id", "population", "childrens",
+	"latitude", "longitude", "latitude_key", "longitude_key", "admin_unit_id", "oktmo", "okato",
+}
+
+const createStagingSQL = `
+CREATE TEMP TABLE IF NOT EXISTS ` + stagingTable + ` (
+	seq bigint NOT NULL,
+	dataset_id bigint NOT NULL,
+	name text NOT NULL,
+	type_id bigint NOT NULL,
+	district_id bigint NOT NULL,
+	population integer NOT NULL,
+	childrens integer NOT NULL,
+	latitude double precision NOT NULL,
+	longitude double precision NOT NULL,
+	latitude_key bigint NOT NULL,
+	longitude_key bigint NOT NULL,
+	admin_unit_id bigint,
+	oktmo text NOT NULL,
+	okato text NOT NULL
+) ON COMMIT DROP`
+
+const mergeStagingSQL = `
+INSERT INTO cities (dataset_id, name, type_id, district_id, population, childrens, latitude, longitude, latitude_key, longitude_key, admin_unit_id, oktmo, okato)
+SELECT DISTINCT ON (dataset_id, district_id, name, type_id, latitude_key, longitude_key)
+	dataset_id, name, type_id, district_id, population, childrens, latitude, longitude, latitude_key, longitude_key, admin_unit_id, oktmo, okato
+FROM ` + stagingTable + `
+ORDER BY dataset_id, district_id, name, type_id, latitude_key, longitude_key, seq DESC
+ON CONFLICT (dataset_id, district_id, name, type_id, latitude_key, longitude_key) DO UPDATE
+SET population = EXCLUDED.population,
+	childrens = EXCLUDED.childrens,
+	latitude = EXCLUDED.latitude,
+	longitude = EXCLUDED.longitude,
+	admin_unit_id = EXCLUDED.admin_unit_id,
+	oktmo = EXCLUDED.oktmo,
+	okato = EXCLUDED.okato
+WHERE (cities.population, cities.childrens, cities.latitude, cities.longitude, cities.admin_unit_id, cities.oktmo, cities.okato)
+	IS DISTINCT FROM (EXCLUDED.population, EXCLUDED.childrens, EXCLUDED.latitude, EXCLUDED.longitude, EXCLUDED.admin_unit_id, EXCLUDED.oktmo, EXCLUDED.okato)
+RETURNING (xmax = 0) AS inserted`
+
+// writer is the persist stage of the pipeline.
+type writer interface {
+	// write persists a single row or buffers it for a later flush.
+	write(ctx context.Context, row settlementRow) error
+	// flush persists everything still buffered. It is called once, after the last row.
+	flush(ctx context.Context) error
+}
+
+// newWriter creates the writer of one persist worker for the configured mode,
+// or the planning writer of a dry run, which compares rows with the stored
+// cities. Writers count the rows they persist in report.
+func (dl *DataLoader) newWriter(report *ImportReport, stored map[naturalKey]cityValues) writer {
+	switch {
+	case dl.opts.DryRun:
+		return newPlanWriter(dl, report, stored)
+	case dl.opts.Mode == ModeBulk:
+		return newBulkWriter(dl, report)
+	default:
+		return &rowWriter{dl: dl, report: report, merges: newMergeBuffer()}
+	}
+}
+
+// mergeBuffer sums the rows of federal cities, which the source splits into
+// several rows. Writing the sum once at the end keeps re-loads idempotent.
+type mergeBuffer struct {
+	rows  map[naturalKey]*settlementRow
+	order []naturalKey
+}
+
+func newMergeBuffer() *mergeBuffer {
+	return &mergeBuffer{rows: map[naturalKey]*settlementRow{}}
+}
+
+// add folds row into the buffer. It returns true when the row was added
+// to a city already in the buffer.
+func (b *mergeBuffer) add(row settlementRow) bool {
+	key := rowKey(row)
+	if acc, ok := b.rows[key]; ok {
+		acc.population += row.population
+		acc.childrens += row.childrens
+		return true
+	}
+
+	b.rows[key] = &row
+	b.order = append(b.order, key)
+	return false
+}
+
+// writeMerged persists the summed federal cities row by row.
+func writeMerged(dl *DataLoader, b *mergeBuffer, report *ImportReport) {
+	for _, key := range b.order {
+		row := b.rows[key]
+		res, err := dl.processRow(*row)
+		if err != nil {
+			report.fail(row.line, ReasonPersistFailed, err.Error(), row.raw)
+			continue
+		}
+		report.record(res)
+	}
+}
+
+// rowWriter persists every row as soon as it arrives.
+type rowWriter struct {
+	dl     *DataLoader
+	report *ImportReport
+	merges *mergeBuffer
+}
+
+func (w *rowWriter) write(_ context.Context, row settlementRow) error {
+	if isMergedRow(row) {
+		if w.merges.add(row) {
+			w.report.addMerged()
+		}
+		return nil
+	}
+
+	res, err := w.dl.processRow(row)
+	if err != nil {
+		return err
+	}
+	w.report.record(res)
+	return nil
+}
+
+func (w *rowWriter) flush(context.Context) error {
+	writeMerged(w.dl, w.merges, w.report)
+	return nil
+}
+
+// bulkWriter writes cities in batches: COPY into a temporary staging table,
+// then one upsert per batch. Everything runs on the connection of the load;
+// a batch is copied and upserted while holding the statement lock, in a
+// savepoint, so a failed batch fails its own rows and not the whole load.
+type bulkWriter struct {
+	dl     *DataLoader
+	report *ImportReport
+	merges *mergeBuffer
+	batch  [][]any
+	// rows are the source rows of batch, to report them when it fails.
+	rows []settlementRow
+	// upsert writes a batch and returns whether each written city was
+	// inserted. It is copyBatch; tests replace it.
+	upsert func(ctx context.Context, batch [][]any) ([]bool, error)
+	// staging is set once the writer made sure the staging table exists.
+	staging bool
+}
+
+func newBulkWriter(dl *DataLoader, report *ImportReport) *bulkWriter {
+	w := &bulkWriter{
+		dl:     dl,
+		report: report,
+		merges: newMergeBuffer(),
+		batch:  make([][]any, 0, dl.opts.BatchSize),
+		rows:   make([]settlementRow, 0, dl.opts.BatchSize),
+	}
+	w.upsert = w.copyBatch
+	return w
+}
+
+func (w *bulkWriter) write(ctx context.Context, row settlementRow) error {
+	if isMergedRow(row) {
+		if w.merges.add(row) {
+			w.report.addMerged()
+		}
+		return nil
+	}
+
+	typeID, err := w.dl.typeIDs.get(row.typeName)
+	if err != nil {
+		return err
+	}
+
+	districtID, err := w.dl.districtIDs.get(row.region)
+	if err != nil {
+		return err
+	}
+
+	adminUnitID, err := w.dl.adminUnitIDs.get(adminUnitKey(row))
+	if err != nil {
+		return err
+	}
+
+	city := w.dl.newCity(row, typeID, districtID, adminUnitID)
+	var adminUnit *int64
+	if city.AdminUnitID != nil {
+		id := int64(*city.AdminUnitID)
+		adminUnit = &id
+	}
+	w.batch = append(w.batch, []any{
+		int64(row.line), int64(city.DatasetID), city.Name, int64(city.TypeID), int64(city.DistrictID), city.Population, city.Childrens,
+		city.Latitude, city.Longitude, city.LatitudeKey, city.LongitudeKey, adminUnit, city.OKTMO, city.OKATO,
+	})
+	w.rows = append(w.rows, row)
+
+	if len(w.batch) >= w.dl.opts.BatchSize {
+		w.flushBatch(ctx)
+	}
+	return nil
+}
+
+func (w *bulkWriter) flush(ctx context.Context) error {
+	w.flushBatch(ctx)
+	writeMerged(w.dl, w.merges, w.report)
+	return nil
+}
+
+// flushBatch copies the buffered cities into the staging table and upserts
+// them. When the batch fails, none of its rows is written and every one of
+// them is reported as failed.
+func (w *bulkWriter) flushBatch(ctx context.Context) {
+	if len(w.batch) == 0 {
+		return
+	}
+
+	var inserted []bool
+	err := w.dl.locked(func() error {
+		var err error
+		inserted, err = w.upsert(ctx, w.batch)
+		return err
+	})
+	if err != nil {
+		for _, row := range w.rows {
+			w.report.fail(row.line, ReasonPersistFailed, err.Error(), row.raw)
+		}
+	} else {
+		created := 0
+		for _, ok := range inserted {
+			if ok {
+				created++
+			}
+		}
+		w.report.addOutcomes(created, len(inserted)-created, len(w.batch)-len(inserted))
+	}
+
+	w.batch = w.batch[:0]
+	w.rows = w.rows[:0]
+}
+
+// copyBatch runs the statements of flushBatch in a savepoint and returns
+// whether each written city was inserted. The caller must hold the
+// statement lock.
+func (w *bulkWriter) copyBatch(ctx context.Context, batch [][]any) ([]bool, error) {
+	// The staging table is created outside of the savepoint, so a failed
+	// batch cannot roll it back
+	if !w.staging {
+		if err := w.dl.db.Exec(createStagingSQL).Error; err != nil {
+			return nil, fmt.Errorf("failed to create staging table: %w", err)
+		}
+		w.staging = true
+	}
+
+	var inserted []bool
+	err := w.dl.db.Transaction(func(tx *gorm.DB) error {
+		txl := w.dl.withDB(tx)
+
+		err := txl.conn.Raw(func(driverConn any) error {
+			conn, ok := driverConn.(*stdlib.Conn)
+			if !ok {
+				return fmt.Errorf("bulk mode requires the pgx driver, got %T", driverConn)
+			}
+
+			_, err := conn.Conn().CopyFrom(ctx, pgx.Identifier{stagingTable}, stagingColumns, pgx.CopyFromRows(batch))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to copy %d cities: %w", len(batch), err)
+		}
+
+		if err := tx.Raw(mergeStagingSQL).Scan(&inserted).Error; err != nil {
+			return fmt.Errorf("failed to upsert %d cities: %w", len(batch), err)
+		}
+		if txl.opts.Replace {
+			if err := tx.Exec(markLoadedStagingSQL).Error; err != nil {
+				return fmt.Errorf("failed to track %d cities: %w", len(batch), err)
+			}
+		}
+		if txl.observes() {
+			if err := txl.observeStaging(); err != nil {
+				return err
+			}
+		}
+		if err := tx.Exec("TRUNCATE " + stagingTable).Error; err != nil {
+			return fmt.Errorf("failed to clear staging table: %w", err)
+		}
+		return nil
+	})
+	return inserted, err
+}
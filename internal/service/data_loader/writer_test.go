@@ -0,0 +1,59 @@
+package data_loader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"settlements/internal/models"
+)
+
+func newTestBulkWriter(report *ImportReport, batchSize int) *bulkWriter {
+	dl := &DataLoader{
+		opts:    Options{Mode: ModeBulk, BatchSize: batchSize}.withDefaults(),
+		dataset: &models.Dataset{ID: 1},
+		mu:      &sync.Mutex{},
+	}
+	resolve := func(string) (uint, error) { return 1, nil }
+	dl.typeIDs, dl.districtIDs, dl.adminUnitIDs = newIDCache(resolve), newIDCache(resolve), newIDCache(resolve)
+	return newBulkWriter(dl, report)
+}
+
+func TestBulkWriterFailedBatch(t *testing.T) {
+	report := NewImportReport()
+	w := newTestBulkWriter(report, 2)
+
+	batches := 0
+	w.upsert = func(_ context.Context, batch [][]any) ([]bool, error) {
+		batches++
+		if batches == 1 {
+			return nil, errors.New("invalid input syntax for type integer")
+		}
+		inserted := make([]bool, len(batch))
+		for i := range inserted {
+			inserted[i] = true
+		}
+		return inserted, nil
+	}
+
+	for line := 2; line <= 4; line++ {
+		row := settlementRow{line: line, region: "Тверская область", settlement: "Тверь", typeName: "город", latitude: float64(line)}
+		if err := w.write(context.Background(), row); err != nil {
+			t.Fatalf("Expected a failed batch not to stop the load, got %v", err)
+		}
+	}
+	if err := w.flush(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if report.Failed != 2 || report.Created != 1 {
+		t.Errorf("Expected the 2 rows of the failed batch to fail and 1 to be created, got %s", report.Summary())
+	}
+	if len(report.Issues) != 2 || report.Issues[0].Line != 2 || report.Issues[1].Line != 3 {
+		t.Errorf("Expected issues on lines 2 and 3, got %+v", report.Issues)
+	}
+	if len(w.batch) != 0 || len(w.rows) != 0 {
+		t.Errorf("Expected the batch to be cleared, got %d rows", len(w.batch))
+	}
+}
@@ -0,0 +1,186 @@
+package data_loader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// xlsxSource reads the rows of one worksheet of an XLSX workbook. The header
+// is the first row after Options.HeaderOffset rows; record lines are the row
+// numbers of the sheet. Merged cells, which statistical tables use for
+// multi-row headers and for regions spanning many settlements, read as their
+// value in every cell they cover. Empty rows are ignored.
+type xlsxSource struct {
+	file    *excelize.File
+	rows    *excelize.Rows
+	columns *columnMap
+	// merged maps row and column numbers of the cells covered by a merged
+	// range, other than its top-left cell, to the value of the range.
+	merged map[int]map[int]string
+	line   int
+	// first is the first row of a sheet without a header, read before the others.
+	first *sourceRecord
+}
+
+// newXLSXSource opens the workbook in r and binds the header of the sheet
+// chosen by opts to the profile.
+func newXLSXSource(r io.Reader, opts Options) (*xlsxSource, error) {
+	file, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open XLSX workbook: %w", err)
+	}
+
+	src, err := bindXLSXSheet(file, opts)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return src, nil
+}
+
+func bindXLSXSheet(file *excelize.File, opts Options) (*xlsxSource, error) {
+	sheets := file.GetSheetList()
+	if len(sheets) == 0 {
+		return nil, fmt.Errorf("XLSX workbook has no sheets")
+	}
+
+	sheet := opts.Sheet
+	if sheet == "" {
+		sheet = sheets[0]
+	} else if idx, err := file.GetSheetIndex(sheet); err != nil || idx < 0 {
+		return nil, fmt.Errorf("XLSX workbook has no sheet %q (sheets: %s)", sheet, strings.Join(sheets, ", "))
+	}
+
+	merged, err := mergedCells(file, sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := file.Rows(sheet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sheet %q: %w", sheet, err)
+	}
+	src := &xlsxSource{file: file, rows: rows, merged: merged}
+
+	for src.line < opts.HeaderOffset {
+		if !rows.Next() {
+			rows.Close()
+			return nil, fmt.Errorf("sheet %q has fewer than %d rows before the header", sheet, opts.HeaderOffset)
+		}
+		src.line++
+	}
+
+	header, err := src.next()
+	if err != nil {
+		rows.Close()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("sheet %q is empty or has no data rows", sheet)
+		}
+		return nil, fmt.Errorf("failed to read XLSX header: %w", err)
+	}
+
+	if src.columns, err = opts.Profile.bind(header.fields); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	if src.columns.headerless {
+		src.first = &header
+	}
+	return src, nil
+}
+
+// mergedCells returns the covered cells of every merged range of sheet,
+// by row and column number.
+func mergedCells(file *excelize.File, sheet string) (map[int]map[int]string, error) {
+	ranges, err := file.GetMergeCells(sheet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read merged cells of sheet %q: %w", sheet, err)
+	}
+
+	res := map[int]map[int]string{}
+	for _, rng := range ranges {
+		startCol, startRow, err := excelize.CellNameToCoordinates(rng.GetStartAxis())
+		if err != nil {
+			return nil, fmt.Errorf("invalid merged range in sheet %q: %w", sheet, err)
+		}
+		endCol, endRow, err := excelize.CellNameToCoordinates(rng.GetEndAxis())
+		if err != nil {
+			return nil, fmt.Errorf("invalid merged range in sheet %q: %w", sheet, err)
+		}
+
+		for row := startRow; row <= endRow; row++ {
+			for col := startCol; col <= endCol; col++ {
+				if row == startRow && col == startCol {
+					continue
+				}
+				if res[row] == nil {
+					res[row] = map[int]string{}
+				}
+				res[row][col] = rng.GetCellValue()
+			}
+		}
+	}
+	return res, nil
+}
+
+func (s *xlsxSource) next() (sourceRecord, error) {
+	if s.first != nil {
+		rec := *s.first
+		s.first = nil
+		return rec, nil
+	}
+
+	for s.rows.Next() {
+		s.line++
+
+		fields, err := s.rows.Columns(excelize.Options{RawCellValue: true})
+		if err != nil {
+			return sourceRecord{}, fmt.Errorf("failed to read XLSX row %d: %w", s.line, err)
+		}
+		fields = s.fillMerged(fields)
+		if isEmptyRow(fields) {
+			continue
+		}
+		return sourceRecord{line: s.line, fields: fields}, nil
+	}
+
+	if err := s.rows.Error(); err != nil {
+		return sourceRecord{}, fmt.Errorf("failed to read XLSX: %w", err)
+	}
+	return sourceRecord{}, io.EOF
+}
+
+// fillMerged sets the cells of the current row covered by merged ranges.
+func (s *xlsxSource) fillMerged(fields []string) []string {
+	for col, value := range s.merged[s.line] {
+		for len(fields) < col {
+			fields = append(fields, "")
+		}
+		if fields[col-1] == "" {
+			fields[col-1] = value
+		}
+	}
+	return fields
+}
+
+func (s *xlsxSource) mapping() *columnMap {
+	return s.columns
+}
+
+// Close releases the worksheet reader and the workbook.
+func (s *xlsxSource) Close() error {
+	return errors.Join(s.rows.Close(), s.file.Close())
+}
+
+func isEmptyRow(fields []string) bool {
+	for _, f := range fields {
+		if strings.TrimSpace(f) != "" {
+			return false
+		}
+	}
+	return true
+}
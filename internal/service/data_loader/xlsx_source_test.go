@@ -0,0 +1,115 @@
+package data_loader
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// newTestWorkbook builds a statistical table: a title row, a header whose
+// region column is merged over two header rows, and a region merged over
+// its settlements.
+func newTestWorkbook(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	f := excelize.NewFile()
+	defer f.Close()
+
+	if _, err := f.NewSheet("Поселения"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	rows := [][]any{
+		{"Численность населения по населенным пунктам"},
+		{"Регион", "Населенный пункт", "Тип", "Население", "Широта", "Долгота"},
+		{nil, nil, nil, "чел.", nil, nil},
+		{"Тверская область", "Тверь", "г", 400000, 56.85, 35.9},
+		{nil, "Эммаус", "п", 2000, 56.95, 35.7},
+		{},
+		{"Москва", "Москва", "г", 1000, 55.75, 37.62},
+	}
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		if err := f.SetSheetRow("Поселения", cell, &row); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	f.MergeCell("Поселения", "A2", "A3")
+	f.MergeCell("Поселения", "A4", "A5")
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return buf
+}
+
+func TestReadCitiesXLSX(t *testing.T) {
+	opts := Options{Format: FormatXLSX, Sheet: "Поселения", HeaderOffset: 1, Profile: builtinProfiles["generic"]}
+
+	cities, report, err := ReadCities(newTestWorkbook(t), opts, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The second header row is read as a data row and skipped.
+	if report.Format != string(FormatXLSX) || report.Skipped != 1 {
+		t.Errorf("Expected an xlsx report with 1 skipped row, got %s (%s)", report.Format, report.Summary())
+	}
+	if len(cities) != 3 {
+		t.Fatalf("Expected 3 cities, got %d", len(cities))
+	}
+
+	emmaus := cities[1]
+	if emmaus.Name != "Эммаус" || emmaus.District != "Тверская область" {
+		t.Errorf("Expected Эммаус in the merged region, got %+v", emmaus)
+	}
+	if emmaus.Population != 2000 || emmaus.Latitude != 56.95 || emmaus.Longitude != 35.7 {
+		t.Errorf("Expected raw cell values, got %+v", emmaus)
+	}
+}
+
+func TestXLSXSourceLines(t *testing.T) {
+	opts := Options{Sheet: "Поселения", HeaderOffset: 1, Profile: builtinProfiles["generic"]}
+	src, err := newXLSXSource(newTestWorkbook(t), opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer src.Close()
+
+	lines := []int{}
+	for {
+		rec, err := src.next()
+		if err != nil {
+			break
+		}
+		lines = append(lines, rec.line)
+	}
+
+	if len(lines) != 4 || lines[0] != 3 || lines[3] != 7 {
+		t.Errorf("Expected sheet rows 3, 4, 5 and 7, got %v", lines)
+	}
+}
+
+func TestXLSXSourceErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"unknown sheet", Options{Sheet: "Лист9", Profile: builtinProfiles["generic"]}, "no sheet"},
+		{"first sheet is empty", Options{Profile: builtinProfiles["generic"]}, "empty"},
+		{"offset past the end", Options{Sheet: "Поселения", HeaderOffset: 100, Profile: builtinProfiles["generic"]}, "fewer than"},
+		{"header without required columns", Options{Sheet: "Поселения", Profile: builtinProfiles["generic"]}, "required columns"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newXLSXSource(newTestWorkbook(t), tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package dataset_diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+
+	"settlements/internal/dto"
+	"settlements/internal/geo"
+)
+
+// DefaultToleranceKm is the coordinate move below which a settlement is not reported as moved.
+const DefaultToleranceKm = 1.0
+
+// Settlement is a settlement as it appears on one side of a diff.
+type Settlement struct {
+	District   string  `json:"district"`
+	Name       string  `json:"name"`
+	Type       string  `json:"type"`
+	Population int     `json:"population"`
+	Childrens  int     `json:"childrens"`
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+}
+
+// Change describes a settlement present in both datasets whose data differ.
+type Change struct {
+	District         string  `json:"district"`
+	Name             string  `json:"name"`
+	PopulationBefore int     `json:"populationBefore"`
+	PopulationAfter  int     `json:"populationAfter"`
+	ChildrensBefore  int     `json:"childrensBefore"`
+	ChildrensAfter   int     `json:"childrensAfter"`
+	TypeBefore       string  `json:"typeBefore"`
+	TypeAfter        string  `json:"typeAfter"`
+	MovedKm          float64 `json:"movedKm"`
+	// Moved is set when MovedKm exceeds the tolerance of the diff.
+	Moved bool `json:"moved"`
+}
+
+// Reclassified reports whether the settlement changed its type.
+func (c Change) Reclassified() bool {
+	return c.TypeBefore != c.TypeAfter
+}
+
+// DistrictChange compares the totals of one district.
+type DistrictChange struct {
+	District         string `json:"district"`
+	PopulationBefore int    `json:"populationBefore"`
+	PopulationAfter  int    `json:"populationAfter"`
+	ChildrensBefore  int    `json:"childrensBefore"`
+	ChildrensAfter   int    `json:"childrensAfter"`
+}
+
+// Report is the result of comparing two datasets.
+type Report struct {
+	From        string           `json:"from"`
+	To          string           `json:"to"`
+	ToleranceKm float64          `json:"toleranceKm"`
+	Added       []Settlement     `json:"added"`
+	Removed     []Settlement     `json:"removed"`
+	Changed     []Change         `json:"changed"`
+	Districts   []DistrictChange `json:"districts"`
+}
+
+// Options configures a comparison.
+type Options struct {
+	// FromLabel and ToLabel name the two sides in the report.
+	FromLabel string
+	ToLabel   string
+	// ToleranceKm is the coordinate move below which settlements are not reported as moved.
+	ToleranceKm float64
+}
+
+// FromCities converts repository rows into diff settlements.
+func FromCities(cities []dto.CityDTO) []Settlement {
+	res := make([]Settlement, 0, len(cities))
+	for _, c := range cities {
+		res = append(res, Settlement{
+			District:   c.District,
+			Name:       c.Name,
+			Type:       c.Type,
+			Population: c.Population,
+			Childrens:  c.Childrens,
+			Latitude:   c.Latitude,
+			Longitude:  c.Longitude,
+		})
+	}
+	return res
+}
+
+// groupKey groups settlements that may be the same place in both datasets.
+// Type and coordinates are left out because they are what the diff reports on.
+type groupKey struct {
+	district string
+	name     string
+}
+
+// Compare diffs two datasets. Settlements are matched by district and name;
+// when a district has several settlements of the same name, the closest
+// ones are paired first.
+func Compare(from, to []Settlement, opts Options) *Report {
+	if opts.ToleranceKm <= 0 {
+		opts.ToleranceKm = DefaultToleranceKm
+	}
+
+	report := &Report{
+		From:        opts.FromLabel,
+		To:          opts.ToLabel,
+		ToleranceKm: opts.ToleranceKm,
+		Added:       []Settlement{},
+		Removed:     []Settlement{},
+		Changed:     []Change{},
+		Districts:   compareDistricts(from, to),
+	}
+
+	fromGroups := group(from)
+	toGroups := group(to)
+
+	for key, before := range fromGroups {
+		after := toGroups[key]
+		pairs, removed, added := match(before, after)
+
+		report.Removed = append(report.Removed, removed...)
+		report.Added = append(report.Added, added...)
+
+		for _, p := range pairs {
+			change := newChange(p[0], p[1], opts.ToleranceKm)
+			if change.PopulationBefore != change.PopulationAfter ||
+				change.ChildrensBefore != change.ChildrensAfter ||
+				change.Reclassified() || change.Moved {
+				report.Changed = append(report.Changed, change)
+			}
+		}
+	}
+
+	for key, after := range toGroups {
+		if _, ok := fromGroups[key]; !ok {
+			report.Added = append(report.Added, after...)
+		}
+	}
+
+	sortSettlements(report.Added)
+	sortSettlements(report.Removed)
+	sort.Slice(report.Changed, func(i, j int) bool {
+		a, b := report.Changed[i], report.Changed[j]
+		if a.District != b.District {
+			return a.District < b.District
+		}
+		return a.Name < b.Name
+	})
+
+	return report
+}
+
+func group(settlements []Settlement) map[groupKey][]Settlement {
+	res := map[groupKey][]Settlement{}
+	for _, s := range settlements {
+		key := groupKey{district: s.District, name: s.Name}
+		res[key] = append(res[key], s)
+	}
+	return res
+}
+
+// match pairs settlements of one group greedily by distance.
+// It returns the pairs and the settlements left over on each side.
+func match(before, after []Settlement) (pairs [][2]Settlement, removed, added []Settlement) {
+	type candidate struct {
+		i, j int
+		dist float64
+	}
+
+	candidates := make([]candidate, 0, len(before)*len(after))
+	for i, b := range before {
+		for j, a := range after {
+			candidates = append(candidates, candidate{i, j, geo.DistanceKm(b.Latitude, b.Longitude, a.Latitude, a.Longitude)})
+		}
+	}
+	sort.SliceStable(candidates, func(x, y int) bool { return candidates[x].dist < candidates[y].dist })
+
+	usedBefore := make([]bool, len(before))
+	usedAfter := make([]bool, len(after))
+	for _, c := range candidates {
+		if usedBefore[c.i] || usedAfter[c.j] {
+			continue
+		}
+		usedBefore[c.i], usedAfter[c.j] = true, true
+		pairs = append(pairs, [2]Settlement{before[c.i], after[c.j]})
+	}
+
+	for i, used := range usedBefore {
+		if !used {
+			removed = append(removed, before[i])
+		}
+	}
+	for j, used := range usedAfter {
+		if !used {
+			added = append(added, after[j])
+		}
+	}
+	return pairs, removed, added
+}
+
+func newChange(before, after Settlement, toleranceKm float64) Change {
+	moved := geo.DistanceKm(before.Latitude, before.Longitude, after.Latitude, after.Longitude)
+	return Change{
+		District:         before.District,
+		Name:             before.Name,
+		PopulationBefore: before.Population,
+		PopulationAfter:  after.Population,
+		ChildrensBefore:  before.Childrens,
+		ChildrensAfter:   after.Childrens,
+		TypeBefore:       before.Type,
+		TypeAfter:        after.Type,
+		MovedKm:          math.Round(moved*1000) / 1000,
+		Moved:            moved > toleranceKm,
+	}
+}
+
+func compareDistricts(from, to []Settlement) []DistrictChange {
+	totals := map[string]*DistrictChange{}
+	get := func(name string) *DistrictChange {
+		if d, ok := totals[name]; ok {
+			return d
+		}
+		d := &DistrictChange{District: name}
+		totals[name] = d
+		return d
+	}
+
+	for _, s := range from {
+		d := get(s.District)
+		d.PopulationBefore += s.Population
+		d.ChildrensBefore += s.Childrens
+	}
+	for _, s := range to {
+		d := get(s.District)
+		d.PopulationAfter += s.Population
+		d.ChildrensAfter += s.Childrens
+	}
+
+	res := []DistrictChange{}
+	for _, d := range totals {
+		if d.PopulationBefore != d.PopulationAfter || d.ChildrensBefore != d.ChildrensAfter {
+			res = append(res, *d)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].District < res[j].District })
+	return res
+}
+
+func sortSettlements(s []Settlement) {
+	sort.Slice(s, func(i, j int) bool {
+		if s[i].District != s[j].District {
+			return s[i].District < s[j].District
+		}
+		return s[i].Name < s[j].Name
+	})
+}
+
+// WriteJSON writes the report as an indented JSON document.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteTable writes the report as aligned plain-text tables.
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "%s -> %s: %d added, %d removed, %d changed, %d districts changed\n\n",
+		r.From, r.To, len(r.Added), len(r.Removed), len(r.Changed), len(r.Districts))
+
+	if len(r.Added) > 0 {
+		fmt.Fprintln(tw, "ADDED\nDISTRICT\tNAME\tTYPE\tPOPULATION\tCHILDREN\tLATITUDE\tLONGITUDE")
+		for _, s := range r.Added {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%.5f\t%.5f\n", s.District, s.Name, s.Type, s.Population, s.Childrens, s.Latitude, s.Longitude)
+		}
+		fmt.Fprintln(tw)
+	}
+
+	if len(r.Removed) > 0 {
+		fmt.Fprintln(tw, "REMOVED\nDISTRICT\tNAME\tTYPE\tPOPULATION\tCHILDREN\tLATITUDE\tLONGITUDE")
+		for _, s := range r.Removed {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%.5f\t%.5f\n", s.District, s.Name, s.Type, s.Population, s.Childrens, s.Latitude, s.Longitude)
+		}
+		fmt.Fprintln(tw)
+	}
+
+	if len(r.Changed) > 0 {
+		fmt.Fprintln(tw, "CHANGED\nDISTRICT\tNAME\tPOPULATION\tCHILDREN\tTYPE\tMOVED KM")
+		for _, c := range r.Changed {
+			typ := c.TypeAfter
+			if c.Reclassified() {
+				typ = c.TypeBefore + " -> " + c.TypeAfter
+			}
+			moved := ""
+			if c.Moved {
+				moved = fmt.Sprintf("%.3f", c.MovedKm)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", c.District, c.Name,
+				formatDelta(c.PopulationBefore, c.PopulationAfter), formatDelta(c.ChildrensBefore, c.ChildrensAfter), typ, moved)
+		}
+		fmt.Fprintln(tw)
+	}
+
+	if len(r.Districts) > 0 {
+		fmt.Fprintln(tw, "DISTRICTS\nDISTRICT\tPOPULATION\tCHILDREN")
+		for _, d := range r.Districts {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", d.District,
+				formatDelta(d.PopulationBefore, d.PopulationAfter), formatDelta(d.ChildrensBefore, d.ChildrensAfter))
+		}
+	}
+
+	return tw.Flush()
+}
+
+// formatDelta renders a before/after pair, e.g. "1200 -> 1150 (-50)".
+func formatDelta(before, after int) string {
+	if before == after {
+		return fmt.Sprintf("%d", after)
+	}
+	return fmt.Sprintf("%d -> %d (%+d)", before, after, after-before)
+}
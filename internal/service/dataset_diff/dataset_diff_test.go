@@ -0,0 +1,137 @@
+package dataset_diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func sampleFrom() []Settlement {
+	return []Settlement{
+		{District: "Тверская область", Name: "Тверь", Type: "Город", Population: 400000, Childrens: 60000, Latitude: 56.85, Longitude: 35.9},
+		{District: "Тверская область", Name: "Эммаус", Type: "Поселок", Population: 2000, Childrens: 300, Latitude: 56.95, Longitude: 35.7},
+		{District: "Тверская область", Name: "Старое", Type: "Деревня", Population: 10, Childrens: 0, Latitude: 57.0, Longitude: 35.0},
+	}
+}
+
+func sampleTo() []Settlement {
+	return []Settlement{
+		{District: "Тверская область", Name: "Тверь", Type: "Город", Population: 410000, Childrens: 61000, Latitude: 56.85, Longitude: 35.9},
+		{District: "Тверская область", Name: "Эммаус", Type: "Село", Population: 2000, Childrens: 300, Latitude: 57.05, Longitude: 35.7},
+		{District: "Тверская область", Name: "Новое", Type: "Деревня", Population: 20, Childrens: 5, Latitude: 57.1, Longitude: 35.1},
+	}
+}
+
+func TestCompareAddedRemoved(t *testing.T) {
+	report := Compare(sampleFrom(), sampleTo(), Options{})
+
+	if len(report.Added) != 1 || report.Added[0].Name != "Новое" {
+		t.Errorf("Expected 'Новое' added, got %+v", report.Added)
+	}
+
+	if len(report.Removed) != 1 || report.Removed[0].Name != "Старое" {
+		t.Errorf("Expected 'Старое' removed, got %+v", report.Removed)
+	}
+}
+
+func TestCompareChanges(t *testing.T) {
+	report := Compare(sampleFrom(), sampleTo(), Options{ToleranceKm: 1})
+
+	if len(report.Changed) != 2 {
+		t.Fatalf("Expected 2 changed settlements, got %d", len(report.Changed))
+	}
+
+	tver := report.Changed[0]
+	if tver.Name != "Тверь" || tver.PopulationBefore != 400000 || tver.PopulationAfter != 410000 {
+		t.Errorf("Expected Тверь population change, got %+v", tver)
+	}
+	if tver.Reclassified() || tver.Moved {
+		t.Errorf("Expected Тверь neither reclassified nor moved, got %+v", tver)
+	}
+
+	emmaus := report.Changed[1]
+	if !emmaus.Reclassified() || emmaus.TypeBefore != "Поселок" || emmaus.TypeAfter != "Село" {
+		t.Errorf("Expected Эммаус reclassified, got %+v", emmaus)
+	}
+	if !emmaus.Moved || emmaus.MovedKm < 11 || emmaus.MovedKm > 11.2 {
+		t.Errorf("Expected Эммаус moved about 11.1 km, got %+v", emmaus)
+	}
+}
+
+func TestCompareTolerance(t *testing.T) {
+	report := Compare(sampleFrom(), sampleTo(), Options{ToleranceKm: 20})
+
+	for _, c := range report.Changed {
+		if c.Moved {
+			t.Errorf("Expected no moves within 20 km, got %+v", c)
+		}
+	}
+}
+
+func TestCompareDistricts(t *testing.T) {
+	report := Compare(sampleFrom(), sampleTo(), Options{})
+
+	if len(report.Districts) != 1 {
+		t.Fatalf("Expected 1 changed district, got %d", len(report.Districts))
+	}
+
+	d := report.Districts[0]
+	if d.PopulationBefore != 402010 || d.PopulationAfter != 412020 {
+		t.Errorf("Expected population 402010 -> 412020, got %d -> %d", d.PopulationBefore, d.PopulationAfter)
+	}
+	if d.ChildrensBefore != 60300 || d.ChildrensAfter != 61305 {
+		t.Errorf("Expected children 60300 -> 61305, got %d -> %d", d.ChildrensBefore, d.ChildrensAfter)
+	}
+}
+
+func TestCompareSameNameMatchesNearest(t *testing.T) {
+	from := []Settlement{
+		{District: "D", Name: "Ивановка", Population: 100, Latitude: 50, Longitude: 40},
+		{District: "D", Name: "Ивановка", Population: 200, Latitude: 51, Longitude: 41},
+	}
+	to := []Settlement{
+		{District: "D", Name: "Ивановка", Population: 200, Latitude: 51, Longitude: 41},
+		{District: "D", Name: "Ивановка", Population: 100, Latitude: 50, Longitude: 40},
+	}
+
+	report := Compare(from, to, Options{})
+
+	if len(report.Changed) != 0 || len(report.Added) != 0 || len(report.Removed) != 0 {
+		t.Errorf("Expected no differences, got %+v", report)
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	report := Compare(sampleFrom(), sampleTo(), Options{FromLabel: "2020", ToLabel: "2021"})
+
+	var buf bytes.Buffer
+	if err := report.WriteTable(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{"2020 -> 2021", "ADDED", "REMOVED", "400000 -> 410000 (+10000)", "Поселок -> Село"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected table to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	report := Compare(sampleFrom(), sampleTo(), Options{})
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var decoded Report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Expected valid JSON, got %v", err)
+	}
+
+	if len(decoded.Added) != 1 || len(decoded.Changed) != 2 {
+		t.Errorf("Expected 1 added and 2 changed, got %d and %d", len(decoded.Added), len(decoded.Changed))
+	}
+}
@@ -0,0 +1,294 @@
+package dedup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+
+	"settlements/internal/dto"
+	"settlements/internal/geo"
+)
+
+// Defaults of Options.
+const (
+	DefaultMinSimilarity = 0.85
+	DefaultMaxDistanceKm = 1.0
+)
+
+// kmPerDegree is the length of one degree of latitude, used to skip pairs
+// that are too far apart to be compared.
+const kmPerDegree = 111.0
+
+// Options configures the search for duplicates.
+type Options struct {
+	// MinSimilarity is the lowest similarity of normalised names, from 0 to 1,
+	// at which two settlements are considered the same.
+	MinSimilarity float64
+	// MaxDistanceKm is the largest distance between duplicates.
+	MaxDistanceKm float64
+}
+
+// DefaultOptions returns the options used when none are configured.
+func DefaultOptions() Options {
+	return Options{MinSimilarity: DefaultMinSimilarity, MaxDistanceKm: DefaultMaxDistanceKm}
+}
+
+// Candidate is a pair of settlements of one district that are likely the same.
+type Candidate struct {
+	District   string      `json:"district"`
+	A          dto.CityDTO `json:"a"`
+	B          dto.CityDTO `json:"b"`
+	Similarity float64     `json:"similarity"`
+	DistanceKm float64     `json:"distanceKm"`
+}
+
+// Group is a set of duplicates merged into one settlement. Every dropped
+// settlement is a candidate pair with the kept one, so a group may hold more
+// than two settlements but never one merely linked to Keep through another.
+type Group struct {
+	District string `json:"district"`
+	// Keep is the settlement that survives the merge: the most populous one.
+	Keep dto.CityDTO `json:"keep"`
+	// Drop are the settlements merged into Keep and deleted.
+	Drop []dto.CityDTO `json:"drop"`
+	// Population and Childrens are the sums over the whole group, which Keep gets.
+	Population int `json:"population"`
+	Childrens  int `json:"childrens"`
+}
+
+// Report lists the duplicates found among a set of settlements.
+type Report struct {
+	Options    Options     `json:"options"`
+	Candidates []Candidate `json:"candidates"`
+	Groups     []Group     `json:"groups"`
+}
+
+// Dropped counts the settlements merging the groups deletes.
+func (r *Report) Dropped() int {
+	n := 0
+	for _, g := range r.Groups {
+		n += len(g.Drop)
+	}
+	return n
+}
+
+// typePrefixes are the type words and abbreviations written in front of
+// settlement names, as in "пос. Южный". They are left out of normalised names.
+var typePrefixes = map[string]bool{
+	"г": true, "город": true,
+	"п": true, "пос": true, "поселок": true,
+	"пгт": true, "рп": true, "кп": true, "дп": true,
+	"с": true, "село": true,
+	"д": true, "дер": true, "деревня": true,
+	"х": true, "хут": true, "хутор": true,
+	"ст": true, "ст-ца": true, "станица": true,
+	"сл": true, "слобода": true,
+	"аул": true, "нп": true, "мкр": true,
+}
+
+// NormalizeName reduces a settlement name to the form duplicates are compared
+// in: lower case, "ё" read as "е", punctuation dropped and leading type words
+// such as "пос." removed.
+func NormalizeName(name string) string {
+	name = strings.ReplaceAll(strings.ToLower(name), "ё", "е")
+
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-'
+	})
+	for len(words) > 1 && typePrefixes[words[0]] {
+		words = words[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// Similarity returns how alike two normalised names are, from 0 for nothing
+// in common to 1 for equal names, based on their edit distance.
+func Similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// Find looks for duplicates among cities. Only settlements of the same
+// district are compared.
+func Find(cities []dto.CityDTO, opts Options) *Report {
+	report := &Report{Options: opts, Candidates: []Candidate{}, Groups: []Group{}}
+
+	byDistrict := map[string][]dto.CityDTO{}
+	for _, c := range cities {
+		byDistrict[c.District] = append(byDistrict[c.District], c)
+	}
+	districts := make([]string, 0, len(byDistrict))
+	for d := range byDistrict {
+		districts = append(districts, d)
+	}
+	sort.Strings(districts)
+
+	for _, district := range districts {
+		candidates := findInDistrict(byDistrict[district], opts)
+		report.Candidates = append(report.Candidates, candidates...)
+		report.Groups = append(report.Groups, groupCandidates(district, candidates)...)
+	}
+	return report
+}
+
+// findInDistrict compares the cities of one district sorted by latitude,
+// so only pairs within the distance limit in latitude are measured.
+func findInDistrict(cities []dto.CityDTO, opts Options) []Candidate {
+	sort.Slice(cities, func(i, j int) bool {
+		if cities[i].Latitude != cities[j].Latitude {
+			return cities[i].Latitude < cities[j].Latitude
+		}
+		return cities[i].ID < cities[j].ID
+	})
+
+	names := make([]string, len(cities))
+	for i, c := range cities {
+		names[i] = NormalizeName(c.Name)
+	}
+
+	window := opts.MaxDistanceKm / kmPerDegree
+	res := []Candidate{}
+	for i := range cities {
+		for j := i + 1; j < len(cities) && cities[j].Latitude-cities[i].Latitude <= window; j++ {
+			a, b := cities[i], cities[j]
+			distance := geo.DistanceKm(a.Latitude, a.Longitude, b.Latitude, b.Longitude)
+			if distance > opts.MaxDistanceKm {
+				continue
+			}
+			similarity := Similarity(names[i], names[j])
+			if similarity < opts.MinSimilarity {
+				continue
+			}
+			if b.ID < a.ID {
+				a, b = b, a
+			}
+			res = append(res, Candidate{District: a.District, A: a, B: b, Similarity: similarity, DistanceKm: distance})
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].A.ID != res[j].A.ID {
+			return res[i].A.ID < res[j].A.ID
+		}
+		return res[i].B.ID < res[j].B.ID
+	})
+	return res
+}
+
+// groupCandidates groups candidate pairs around the settlements they keep.
+// The most populous settlement of a pair is kept and only the settlements
+// paired with it directly are merged into it, so every dropped settlement
+// is within the limits of the kept one. A chain of pairs is not merged as a
+// whole: its far end is left for a settlement it matches itself.
+func groupCandidates(district string, candidates []Candidate) []Group {
+	cities := map[uint]dto.CityDTO{}
+	paired := map[uint][]uint{}
+	for _, c := range candidates {
+		cities[c.A.ID], cities[c.B.ID] = c.A, c.B
+		paired[c.A.ID] = append(paired[c.A.ID], c.B.ID)
+		paired[c.B.ID] = append(paired[c.B.ID], c.A.ID)
+	}
+
+	order := make([]dto.CityDTO, 0, len(cities))
+	for _, city := range cities {
+		order = append(order, city)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if order[i].Population != order[j].Population {
+			return order[i].Population > order[j].Population
+		}
+		return order[i].ID < order[j].ID
+	})
+
+	used := map[uint]bool{}
+	res := []Group{}
+	for _, keep := range order {
+		if used[keep.ID] {
+			continue
+		}
+
+		g := Group{District: district, Keep: keep, Population: keep.Population, Childrens: keep.Childrens}
+		for _, id := range paired[keep.ID] {
+			if used[id] {
+				continue
+			}
+			used[id] = true
+			g.Drop = append(g.Drop, cities[id])
+			g.Population += cities[id].Population
+			g.Childrens += cities[id].Childrens
+		}
+		if len(g.Drop) == 0 {
+			continue
+		}
+		used[keep.ID] = true
+
+		sort.Slice(g.Drop, func(i, j int) bool {
+			if g.Drop[i].Population != g.Drop[j].Population {
+				return g.Drop[i].Population > g.Drop[j].Population
+			}
+			return g.Drop[i].ID < g.Drop[j].ID
+		})
+		res = append(res, g)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Keep.ID < res[j].Keep.ID })
+	return res
+}
+
+// WriteJSON writes the report as an indented JSON document.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteTable writes the merge groups as an aligned plain-text table.
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "%d candidate pairs in %d groups, %d settlements to merge (similarity >= %.2f, within %.3f km)\n\n",
+		len(r.Candidates), len(r.Groups), r.Dropped(), r.Options.MinSimilarity, r.Options.MaxDistanceKm)
+
+	if len(r.Groups) > 0 {
+		fmt.Fprintln(tw, "DISTRICT\tKEEP\tMERGE\tPOPULATION\tCHILDREN")
+		for _, g := range r.Groups {
+			drop := make([]string, 0, len(g.Drop))
+			for _, c := range g.Drop {
+				drop = append(drop, fmt.Sprintf("%s (#%d, %d)", c.Name, c.ID, c.Population))
+			}
+			fmt.Fprintf(tw, "%s\t%s (#%d, %d)\t%s\t%d\t%d\n", g.District, g.Keep.Name, g.Keep.ID, g.Keep.Population,
+				strings.Join(drop, ", "), g.Population, g.Childrens)
+		}
+	}
+
+	return tw.Flush()
+}
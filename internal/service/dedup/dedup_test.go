@@ -0,0 +1,127 @@
+package dedup
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"settlements/internal/dto"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := map[string]string{
+		"Пос. Южный":       "южный",
+		"Южный":            "южный",
+		"п. Южный":         "южный",
+		"Озёрный":          "озерный",
+		"д.Берёзовка":      "березовка",
+		"Ростов-на-Дону":   "ростов-на-дону",
+		"Село":             "село",
+		"  с.  Покровское": "покровское",
+	}
+
+	for in, want := range tests {
+		if got := NormalizeName(in); got != want {
+			t.Errorf("NormalizeName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	if s := Similarity("южный", "южный"); s != 1 {
+		t.Errorf("Expected equal names to be 1, got %f", s)
+	}
+	if s := Similarity("ивановка", "ивановкa"); s < 0.85 {
+		t.Errorf("Expected one typo to stay similar, got %f", s)
+	}
+	if s := Similarity("новое", "старое"); s >= DefaultMinSimilarity {
+		t.Errorf("Expected different names to be dissimilar, got %f", s)
+	}
+}
+
+func TestFind(t *testing.T) {
+	cities := []dto.CityDTO{
+		{ID: 1, Name: "Южный", District: "Тверская область", Population: 500, Childrens: 50, Latitude: 56.85, Longitude: 35.9},
+		{ID: 2, Name: "Пос. Южный", District: "Тверская область", Population: 1500, Childrens: 100, Latitude: 56.8501, Longitude: 35.9001},
+		{ID: 3, Name: "Южный", District: "Тверская область", Population: 10, Childrens: 1, Latitude: 56.8502, Longitude: 35.9},
+		// Too far away from the others.
+		{ID: 4, Name: "Южный", District: "Тверская область", Population: 700, Latitude: 57.5, Longitude: 35.9},
+		// Same place, other district.
+		{ID: 5, Name: "Южный", District: "Московская область", Population: 300, Latitude: 56.85, Longitude: 35.9},
+		{ID: 6, Name: "Озёрный", District: "Московская область", Population: 200, Latitude: 55, Longitude: 37},
+		{ID: 7, Name: "Озерный", District: "Московская область", Population: 100, Childrens: 5, Latitude: 55, Longitude: 37.001},
+	}
+
+	report := Find(cities, DefaultOptions())
+
+	if len(report.Candidates) != 4 {
+		t.Fatalf("Expected 4 candidate pairs, got %+v", report.Candidates)
+	}
+	if len(report.Groups) != 2 || report.Dropped() != 3 {
+		t.Fatalf("Expected 2 groups dropping 3 settlements, got %+v", report.Groups)
+	}
+
+	tver := report.Groups[1]
+	if tver.Keep.ID != 2 || len(tver.Drop) != 2 {
+		t.Errorf("Expected the most populous settlement to be kept, got %+v", tver)
+	}
+	if tver.Population != 2010 || tver.Childrens != 151 {
+		t.Errorf("Expected summed population 2010 and children 151, got %d and %d", tver.Population, tver.Childrens)
+	}
+
+	moscow := report.Groups[0]
+	if moscow.District != "Московская область" || moscow.Keep.ID != 6 || moscow.Drop[0].ID != 7 {
+		t.Errorf("Expected Озёрный and Озерный to be merged, got %+v", moscow)
+	}
+}
+
+func TestFindRespectsOptions(t *testing.T) {
+	cities := []dto.CityDTO{
+		{ID: 1, Name: "Южный", District: "Тверская область", Latitude: 56.85, Longitude: 35.9},
+		{ID: 2, Name: "Южный", District: "Тверская область", Latitude: 56.86, Longitude: 35.9},
+	}
+
+	if r := Find(cities, Options{MinSimilarity: 0.85, MaxDistanceKm: 0.5}); len(r.Candidates) != 0 {
+		t.Errorf("Expected settlements 1.1 km apart not to match within 0.5 km, got %+v", r.Candidates)
+	}
+	if r := Find(cities, Options{MinSimilarity: 0.85, MaxDistanceKm: 2}); len(r.Candidates) != 1 {
+		t.Errorf("Expected settlements 1.1 km apart to match within 2 km, got %+v", r.Candidates)
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	report := Find([]dto.CityDTO{
+		{ID: 1, Name: "Южный", District: "Тверская область", Population: 500, Latitude: 56.85, Longitude: 35.9},
+		{ID: 2, Name: "Пос. Южный", District: "Тверская область", Population: 1500, Latitude: 56.85, Longitude: 35.9},
+	}, DefaultOptions())
+
+	var buf bytes.Buffer
+	if err := report.WriteTable(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.Contains(buf.String(), "Пос. Южный (#2, 1500)") || !strings.Contains(buf.String(), "Южный (#1, 500)") {
+		t.Errorf("Expected the group in the table, got:\n%s", buf.String())
+	}
+}
+
+func TestFindDoesNotMergeChains(t *testing.T) {
+	// 1 and 3 are each 0.8 km from 2, but 1.6 km from each other
+	cities := []dto.CityDTO{
+		{ID: 1, Name: "Ивановка", District: "Тверская область", Population: 1000, Latitude: 56.85, Longitude: 35.9},
+		{ID: 2, Name: "Ивановка", District: "Тверская область", Population: 500, Latitude: 56.8572, Longitude: 35.9},
+		{ID: 3, Name: "Ивановка", District: "Тверская область", Population: 100, Latitude: 56.8644, Longitude: 35.9},
+	}
+
+	report := Find(cities, DefaultOptions())
+
+	if len(report.Candidates) != 2 {
+		t.Fatalf("Expected 2 candidate pairs, got %+v", report.Candidates)
+	}
+	if len(report.Groups) != 1 || report.Dropped() != 1 {
+		t.Fatalf("Expected 1 group dropping 1 settlement, got %+v", report.Groups)
+	}
+	g := report.Groups[0]
+	if g.Keep.ID != 1 || g.Drop[0].ID != 2 || g.Population != 1500 {
+		t.Errorf("Expected 2 to be merged into 1 and 3 to be left alone, got %+v", g)
+	}
+}
@@ -0,0 +1,87 @@
+package dedup
+
+import (
+	"fmt"
+
+	"settlements/internal/dto"
+	"settlements/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Store reads the settlements of a dataset and applies merges to them.
+type Store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) *Store {
+	return &Store{db: db}
+}
+
+// Cities returns the settlements of the dataset with the given ID.
+// Longitudes are returned as stored.
+func (s *Store) Cities(datasetID uint) ([]dto.CityDTO, error) {
+	var rows []dto.CityDTO
+	err := s.db.Model(&models.City{}).
+		Select(`cities.id, cities.name, types.name AS type, districts.name AS district,
+			cities.population, cities.childrens, cities.latitude, cities.longitude`).
+		Joins("JOIN districts ON districts.id = cities.district_id").
+		Joins("JOIN types ON types.id = cities.type_id").
+		Where("cities.dataset_id = ?", datasetID).
+		Order("cities.id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dataset cities: %w", err)
+	}
+	return rows, nil
+}
+
+// mergeObservationsSQL sums the population observations of a group, year by
+// year, into the observations of the kept settlement.
+const mergeObservationsSQL = `
+INSERT INTO population_observations (city_id, year, population, childrens, source)
+SELECT ?, year, SUM(population), SUM(childrens), MIN(source)
+FROM population_observations
+WHERE city_id IN ?
+GROUP BY year
+ON CONFLICT (city_id, year) DO UPDATE
+SET population = EXCLUDED.population,
+	childrens = EXCLUDED.childrens`
+
+// Apply merges every group in one transaction: the kept settlement gets the
+// population and children of the group, summed per year for its population
+// observations too, and the others are deleted. It
+// returns the number of deleted settlements. A later load of the same source
+// brings the deleted rows back, so merges are best applied after every load.
+func (s *Store) Apply(groups []Group) (int64, error) {
+	var dropped int64
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, g := range groups {
+			ids := make([]uint, 0, len(g.Drop))
+			for _, c := range g.Drop {
+				ids = append(ids, c.ID)
+			}
+
+			err := tx.Exec(mergeObservationsSQL, g.Keep.ID, append([]uint{g.Keep.ID}, ids...)).Error
+			if err != nil {
+				return fmt.Errorf("failed to merge the population history of %q: %w", g.Keep.Name, err)
+			}
+
+			err = tx.Model(&models.City{}).Where("id = ?", g.Keep.ID).
+				UpdateColumns(map[string]any{"population": g.Population, "childrens": g.Childrens}).Error
+			if err != nil {
+				return fmt.Errorf("failed to update %q: %w", g.Keep.Name, err)
+			}
+
+			res := tx.Where("id IN ?", ids).Delete(&models.City{})
+			if res.Error != nil {
+				return fmt.Errorf("failed to delete duplicates of %q: %w", g.Keep.Name, res.Error)
+			}
+			dropped += res.RowsAffected
+		}
+		return nil
+	})
+
+	return dropped, err
+}
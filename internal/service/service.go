@@ -1,12 +1,14 @@
 package service
 
 import (
+	"context"
+	"settlements/internal/dto"
+	"settlements/internal/models"
 	"settlements/internal/repo"
-	"sort"
 )
 
 type Service struct {
-	cityRepo *repo.CityRepo
+	cityRepo repo.CityRepository
 }
 
 type SettlementTypeData struct {
@@ -22,134 +24,138 @@ type GraphData struct {
 	Y int `json:"y"`
 }
 
-func New(cityRepo *repo.CityRepo) *Service {
+func New(cityRepo repo.CityRepository) *Service {
 	return &Service{cityRepo: cityRepo}
 }
 
-func (s *Service) GetAllSettelmetTypeData() *[]SettlementTypeData {
-	data := s.cityRepo.All()
-
-	populationAcc := map[string]int{}
-	childrenAcc := map[string]int{}
-	minPopulation := map[string]int{}
-	maxPopulation := map[string]int{}
-	citiesCounter := map[string]int{}
-
-	for _, d := range *data {
-		_, ok := citiesCounter[d.Type]
-		if ok {
-			citiesCounter[d.Type]++
-		} else {
-			citiesCounter[d.Type] = 1
-		}
-
-		_, ok = populationAcc[d.Type]
-		if ok {
-			populationAcc[d.Type] += d.Population
-		} else {
-			populationAcc[d.Type] = d.Population
-		}
-
-		_, ok = childrenAcc[d.Type]
-		if ok {
-			childrenAcc[d.Type] += d.Childrens
-		} else {
-			childrenAcc[d.Type] = d.Childrens
-		}
-
-		_, ok = minPopulation[d.Type]
-		if ok {
-			if d.Population < minPopulation[d.Type] {
-				minPopulation[d.Type] = d.Population
-			}
-		} else {
-			minPopulation[d.Type] = d.Population
-		}
-
-		_, ok = maxPopulation[d.Type]
-		if ok {
-			if d.Population > maxPopulation[d.Type] {
-				maxPopulation[d.Type] = d.Population
-			}
-		} else {
-			maxPopulation[d.Type] = d.Population
-		}
-	}
+// WithDataset returns a service that reads the given dataset version.
+// Zero selects the most recently loaded dataset.
+func (s *Service) WithDataset(datasetID uint) *Service {
+	return &Service{cityRepo: s.cityRepo.WithDataset(datasetID)}
+}
 
-	res := []SettlementTypeData{}
+// WithYear returns a service that reports populations as observed in the
+// given census year. Zero reports the current populations.
+func (s *Service) WithYear(year int) *Service {
+	return &Service{cityRepo: s.cityRepo.WithYear(year)}
+}
 
-	for k, v := range citiesCounter {
-		typeData := SettlementTypeData{
-			Type:          k,
-			AvgPopulation: float32(populationAcc[k]) / float32(v),
-			AvgChildrens:  float32(childrenAcc[k]) / float32(v),
-			MinPopulation: minPopulation[k],
-			MaxPopulation: maxPopulation[k],
-		}
-		res = append(res, typeData)
-	}
+// GetDatasets returns the loaded dataset versions, the latest first.
+func (s *Service) GetDatasets(ctx context.Context) (*[]dto.DatasetDTO, error) {
+	return s.cityRepo.Datasets(ctx)
+}
 
-	sort.Slice(res, func(i, j int) bool {
-		return res[i].AvgPopulation > res[j].AvgPopulation
-	})
+// GetObservedYears returns the census years with population observations.
+func (s *Service) GetObservedYears(ctx context.Context) ([]int, error) {
+	return s.cityRepo.ObservedYears(ctx)
+}
 
-	return &res
+// GetPopulationHistory returns the population observations of a city, the earliest first.
+func (s *Service) GetPopulationHistory(ctx context.Context, cityID uint) (*[]dto.PopulationObservationDTO, error) {
+	return s.cityRepo.PopulationHistory(ctx, cityID)
+}
+
+// GetGrowthRates returns how the population of every city observed in both
+// years changed between them, the fastest growing first.
+func (s *Service) GetGrowthRates(ctx context.Context, fromYear, toYear int) (*[]dto.GrowthRateDTO, error) {
+	return s.cityRepo.GrowthRates(ctx, fromYear, toYear)
 }
 
-func (s *Service) GetLongitudePopulationData() *[]GraphData {
-	min := s.cityRepo.MinLongitude()
-	max := s.cityRepo.MaxLongitude()
-	step := (max - min) / 100
+// GetDistrictGrowthRates returns how the population of every district
+// changed between the two years, counting the cities observed in both.
+func (s *Service) GetDistrictGrowthRates(ctx context.Context, fromYear, toYear int) (*[]dto.DistrictGrowthRateDTO, error) {
+	return s.cityRepo.DistrictGrowthRates(ctx, fromYear, toYear)
+}
 
-	res := []GraphData{}
+// FindCities returns the page of cities selected by a query, with the
+// number of cities matching its filters.
+func (s *Service) FindCities(ctx context.Context, q repo.CityQuery) (*dto.CityPageDTO, error) {
+	return s.cityRepo.FindCities(ctx, q)
+}
 
-	for i := min; i <= max-step; i += step {
-		data := s.cityRepo.GetCitiesInLongitudeGap(i, i+step)
+// GetCitiesByCode returns the cities with the given OKTMO or OKATO code.
+func (s *Service) GetCitiesByCode(ctx context.Context, scheme models.CodeScheme, code string) (*[]dto.CityDTO, error) {
+	return s.cityRepo.CitiesByCode(ctx, scheme, code)
+}
 
-		sum := 0
-		for _, d := range *data {
-			sum += d.Population
-		}
+// GetCodeMismatches returns the cities whose codes do not belong to the
+// federal subject of their district.
+func (s *Service) GetCodeMismatches(ctx context.Context) (*[]dto.CodeMismatchDTO, error) {
+	return s.cityRepo.CodeMismatches(ctx)
+}
 
-		res = append(res, GraphData{
-			X: i,
-			Y: sum,
-		})
+// GetAllSettelmetTypeData returns the population statistics of every
+// settlement type, aggregated by the repository.
+func (s *Service) GetAllSettelmetTypeData(ctx context.Context) (*[]SettlementTypeData, error) {
+	stats, err := s.cityRepo.TypeStats(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	sort.Slice(res, func(i, j int) bool {
-		return res[i].X.(float64) < res[j].X.(float64)
-	})
+	return settlementTypeData(*stats), nil
+}
+
+// settlementTypeData converts type stats into the rows of the type table.
+func settlementTypeData(stats []dto.TypeStatsDTO) *[]SettlementTypeData {
+	res := []SettlementTypeData{}
+	for _, t := range stats {
+		res = append(res, SettlementTypeData{
+			Type:          t.Type,
+			AvgPopulation: float32(t.AvgPopulation),
+			AvgChildrens:  float32(t.AvgChildrens),
+			MinPopulation: t.MinPopulation,
+			MaxPopulation: t.MaxPopulation,
+		})
+	}
 
 	return &res
 }
 
-func (s *Service) GetDistrictPopulationData() *[]GraphData {
-	data := s.cityRepo.All()
+// GetLongitudePopulationData returns the population of 100 equally wide
+// longitude ranges, the westernmost first, from a single histogram query.
+func (s *Service) GetLongitudePopulationData(ctx context.Context) (*[]GraphData, error) {
+	buckets, err := s.cityRepo.Histogram(ctx, repo.HistogramLongitude, 100)
+	if err != nil {
+		return nil, err
+	}
+
+	return histogramGraphData(*buckets), nil
+}
+
+// histogramGraphData converts histogram buckets into chart points at the
+// start of each bucket.
+func histogramGraphData(buckets []dto.HistogramBucketDTO) *[]GraphData {
+	res := []GraphData{}
+	for _, b := range buckets {
+		res = append(res, GraphData{
+			X: b.Start,
+			Y: int(b.Population),
+		})
+	}
 
-	populationAcc := map[string]int{}
+	return &res
+}
 
-	for _, d := range *data {
-		_, ok := populationAcc[d.District]
-		if ok {
-			populationAcc[d.District] += d.Population
-		} else {
-			populationAcc[d.District] = d.Population
-		}
+// GetDistrictPopulationData returns the total population of every district,
+// aggregated by the repository, the most populous first.
+func (s *Service) GetDistrictPopulationData(ctx context.Context) (*[]GraphData, error) {
+	totals, err := s.cityRepo.DistrictTotals(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	res := []GraphData{}
+	return districtGraphData(*totals), nil
+}
 
-	for k, v := range populationAcc {
+// districtGraphData converts district totals into points of the district chart.
+func districtGraphData(totals []dto.DistrictTotalDTO) *[]GraphData {
+	res := []GraphData{}
+	for _, d := range totals {
 		res = append(res, GraphData{
-			X: k,
-			Y: v,
+			X: d.District,
+			Y: int(d.Population),
 		})
 	}
 
-	sort.Slice(res, func(i, j int) bool {
-		return res[i].Y > res[j].Y
-	})
-
 	return &res
 }
@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+
 	"settlements/internal/dto"
 	"settlements/internal/repo"
 )
@@ -15,54 +17,79 @@ type ServiceV2 struct {
 }
 
 // NewServiceV2 creates a new ServiceV2 with strategy aggregator
-func NewServiceV2(cityRepo *repo.CityRepo) *ServiceV2 {
+func NewServiceV2(cityRepo repo.CityRepository) *ServiceV2 {
 	return &ServiceV2{
 		aggregator: NewStrategyAggregator(cityRepo),
 	}
 }
 
+// WithDataset returns a ServiceV2 that aggregates the given dataset version
+// Zero selects the most recently loaded dataset
+func (s *ServiceV2) WithDataset(datasetID uint) *ServiceV2 {
+	return &ServiceV2{
+		aggregator: s.aggregator.WithDataset(datasetID),
+	}
+}
+
+// WithYear returns a ServiceV2 that aggregates the populations observed in the given census year
+// Zero aggregates the current populations
+func (s *ServiceV2) WithYear(year int) *ServiceV2 {
+	return &ServiceV2{
+		aggregator: s.aggregator.WithYear(year),
+	}
+}
+
 // GetSettlementTypeData returns aggregated settlement type statistics
 // Uses SettlementTypeAggregationStrategy internally
-func (s *ServiceV2) GetSettlementTypeData() *[]SettlementTypeData {
+func (s *ServiceV2) GetSettlementTypeData(ctx context.Context) (*[]SettlementTypeData, error) {
 	strategy := &SettlementTypeAggregationStrategy{}
-	result := s.aggregator.Aggregate(strategy)
-	return result.(*[]SettlementTypeData)
+	result, err := s.aggregator.Aggregate(ctx, strategy)
+	if err != nil {
+		return nil, err
+	}
+	return result.(*[]SettlementTypeData), nil
 }
 
 // GetDistrictPopulationData returns aggregated district population data
 // Uses DistrictAggregationStrategy internally
-func (s *ServiceV2) GetDistrictPopulationData() *[]GraphData {
+func (s *ServiceV2) GetDistrictPopulationData(ctx context.Context) (*[]GraphData, error) {
 	strategy := &DistrictAggregationStrategy{}
-	result := s.aggregator.Aggregate(strategy)
-	return result.(*[]GraphData)
+	return s.aggregateGraph(ctx, strategy)
 }
 
 // GetLongitudePopulationData returns aggregated longitude-based population data
 // Uses LongitudeAggregationStrategy internally with default 100 buckets
-func (s *ServiceV2) GetLongitudePopulationData() *[]GraphData {
-	strategy := NewLongitudeAggregationStrategy(100)
-	result := s.aggregator.Aggregate(strategy)
-	return result.(*[]GraphData)
+func (s *ServiceV2) GetLongitudePopulationData(ctx context.Context) (*[]GraphData, error) {
+	strategy := NewLongitudeAggregationStrategy(100).WithConvention(s.aggregator.LongitudeConvention())
+	return s.aggregateGraph(ctx, strategy)
 }
 
 // GetLongitudePopulationDataWithBuckets returns aggregated longitude data with custom bucket count
 // Allows customization of aggregation granularity
-func (s *ServiceV2) GetLongitudePopulationDataWithBuckets(bucketCount int) *[]GraphData {
-	strategy := NewLongitudeAggregationStrategy(bucketCount)
-	result := s.aggregator.Aggregate(strategy)
-	return result.(*[]GraphData)
+func (s *ServiceV2) GetLongitudePopulationDataWithBuckets(ctx context.Context, bucketCount int) (*[]GraphData, error) {
+	strategy := NewLongitudeAggregationStrategy(bucketCount).WithConvention(s.aggregator.LongitudeConvention())
+	return s.aggregateGraph(ctx, strategy)
+}
+
+// aggregateGraph runs a strategy that returns graph points
+func (s *ServiceV2) aggregateGraph(ctx context.Context, strategy AggregationStrategy) (*[]GraphData, error) {
+	result, err := s.aggregator.Aggregate(ctx, strategy)
+	if err != nil {
+		return nil, err
+	}
+	return result.(*[]GraphData), nil
 }
 
 // ExecuteCustomStrategy allows execution of custom aggregation strategies
 // Demonstrates the extensibility of the Strategy pattern
-func (s *ServiceV2) ExecuteCustomStrategy(strategy AggregationStrategy) interface{} {
-	return s.aggregator.Aggregate(strategy)
+func (s *ServiceV2) ExecuteCustomStrategy(ctx context.Context, strategy AggregationStrategy) (interface{}, error) {
+	return s.aggregator.Aggregate(ctx, strategy)
 }
 
 // ExecuteMultipleStrategies executes multiple strategies in sequence
 // Efficient for fetching multiple aggregations in one pass
-func (s *ServiceV2) ExecuteMultipleStrategies(strategies ...AggregationStrategy) []interface{} {
-	return s.aggregator.AggregateMultiple(strategies...)
+func (s *ServiceV2) ExecuteMultipleStrategies(ctx context.Context, strategies ...AggregationStrategy) ([]interface{}, error) {
+	return s.aggregator.AggregateMultiple(ctx, strategies...)
 }
 
 // Example of extending functionality: New strategy can be added without modifying Service
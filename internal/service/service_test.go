@@ -1,7 +1,12 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
+
+	"settlements/internal/repo/memory"
 )
 
 func TestGraphDataStructure(t *testing.T) {
@@ -108,3 +113,86 @@ func TestSettlementTypeDataMultipleTypes(t *testing.T) {
 		t.Errorf("Cities should have higher avg population than villages")
 	}
 }
+
+func newMemoryService(t *testing.T) (*Service, *memory.CityRepo) {
+	t.Helper()
+
+	r := memory.New()
+	datasetID := r.AddDataset("test", time.Now())
+	r.AddCity(datasetID, memory.City{Name: "Тверь", Type: "город", District: "Тверская область", Population: 424969, Longitude: 35.9})
+	r.AddCity(datasetID, memory.City{Name: "Эммаус", Type: "поселок", District: "Тверская область", Population: 2000, Longitude: 35.7})
+	r.AddCity(datasetID, memory.City{Name: "Москва", Type: "город", District: "Москва", Population: 13010112, Longitude: 37.62})
+	return New(r), r
+}
+
+func TestGetDistrictPopulationData(t *testing.T) {
+	svc, _ := newMemoryService(t)
+
+	data, err := svc.GetDistrictPopulationData(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(*data) != 2 || (*data)[1].X != "Тверская область" || (*data)[1].Y != 426969 {
+		t.Errorf("Expected district totals, got %+v", *data)
+	}
+}
+
+func TestServiceReturnsRepositoryErrors(t *testing.T) {
+	svc, r := newMemoryService(t)
+	outage := errors.New("connection refused")
+	r.Fail(outage)
+
+	ctx := context.Background()
+	if _, err := svc.GetAllSettelmetTypeData(ctx); !errors.Is(err, outage) {
+		t.Errorf("Expected the type data to fail, got %v", err)
+	}
+	if _, err := svc.GetLongitudePopulationData(ctx); !errors.Is(err, outage) {
+		t.Errorf("Expected the longitude data to fail, got %v", err)
+	}
+	if _, err := svc.GetDistrictPopulationData(ctx); !errors.Is(err, outage) {
+		t.Errorf("Expected the district data to fail, got %v", err)
+	}
+}
+
+func TestServiceV2ReturnsRepositoryErrors(t *testing.T) {
+	_, r := newMemoryService(t)
+	svc := NewServiceV2(r)
+
+	ctx := context.Background()
+	types, err := svc.GetSettlementTypeData(ctx)
+	if err != nil || len(*types) != 2 {
+		t.Fatalf("Expected 2 settlement types, got %v, %v", types, err)
+	}
+
+	outage := errors.New("connection refused")
+	r.Fail(outage)
+	if _, err := svc.GetSettlementTypeData(ctx); !errors.Is(err, outage) {
+		t.Errorf("Expected the type data to fail, got %v", err)
+	}
+	if _, err := svc.ExecuteMultipleStrategies(ctx, &DistrictAggregationStrategy{}); !errors.Is(err, outage) {
+		t.Errorf("Expected the strategies to fail, got %v", err)
+	}
+}
+
+func TestGetLongitudePopulationData(t *testing.T) {
+	svc, _ := newMemoryService(t)
+
+	data, err := svc.GetLongitudePopulationData(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(*data) != 100 {
+		t.Fatalf("Expected 100 buckets, got %d", len(*data))
+	}
+
+	total := 0
+	for _, d := range *data {
+		total += d.Y
+	}
+	if total != 424969+2000+13010112 {
+		t.Errorf("Expected every city in a bucket, got total %d", total)
+	}
+	if (*data)[0].X != 35.7 {
+		t.Errorf("Expected the first bucket at 35.7, got %v", (*data)[0].X)
+	}
+}
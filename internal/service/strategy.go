@@ -1,9 +1,11 @@
 package service
 
 import (
+	"context"
 	"sort"
 
 	"settlements/internal/dto"
+	"settlements/internal/geo"
 	"settlements/internal/repo"
 )
 
@@ -18,6 +20,27 @@ type AggregationStrategy interface {
 	Name() string
 }
 
+// PushdownStrategy is a strategy that can also be computed by the repository
+// Aggregating in the database returns only the aggregated rows instead of every city
+type PushdownStrategy interface {
+	AggregationStrategy
+
+	// SupportsPushdown reports whether AggregateInRepository can be used instead of Aggregate
+	SupportsPushdown() bool
+
+	// AggregateInRepository computes the same result as Aggregate with a repository query
+	AggregateInRepository(ctx context.Context, repository repo.CityRepository) (interface{}, error)
+}
+
+// pushdown returns the strategy as a PushdownStrategy when it can be computed by the repository
+func pushdown(strategy AggregationStrategy) (PushdownStrategy, bool) {
+	p, ok := strategy.(PushdownStrategy)
+	if !ok || !p.SupportsPushdown() {
+		return nil, false
+	}
+	return p, true
+}
+
 // SettlementTypeAggregationStrategy aggregates cities by settlement type
 // Computes statistics: average population, average children, min/max population
 type SettlementTypeAggregationStrategy struct{}
@@ -85,6 +108,20 @@ func (s *SettlementTypeAggregationStrategy) Name() string {
 	return "settlement_type_aggregation"
 }
 
+// SupportsPushdown reports that the type statistics can be computed by the repository
+func (s *SettlementTypeAggregationStrategy) SupportsPushdown() bool {
+	return true
+}
+
+// AggregateInRepository computes the type statistics with a GROUP BY query
+func (s *SettlementTypeAggregationStrategy) AggregateInRepository(ctx context.Context, repository repo.CityRepository) (interface{}, error) {
+	stats, err := repository.TypeStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return settlementTypeData(*stats), nil
+}
+
 // DistrictAggregationStrategy aggregates cities by district
 // Computes total population per district
 type DistrictAggregationStrategy struct{}
@@ -118,10 +155,25 @@ func (s *DistrictAggregationStrategy) Name() string {
 	return "district_aggregation"
 }
 
+// SupportsPushdown reports that the district totals can be computed by the repository
+func (s *DistrictAggregationStrategy) SupportsPushdown() bool {
+	return true
+}
+
+// AggregateInRepository computes the district totals with a GROUP BY query
+func (s *DistrictAggregationStrategy) AggregateInRepository(ctx context.Context, repository repo.CityRepository) (interface{}, error) {
+	totals, err := repository.DistrictTotals(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return districtGraphData(*totals), nil
+}
+
 // LongitudeAggregationStrategy distributes cities into longitude buckets
 // Calculates total population per longitude range
 type LongitudeAggregationStrategy struct {
 	bucketCount int
+	convention  geo.LongitudeConvention
 }
 
 // NewLongitudeAggregationStrategy creates a new longitude strategy with specified bucket count
@@ -132,52 +184,55 @@ func NewLongitudeAggregationStrategy(bucketCount int) *LongitudeAggregationStrat
 	return &LongitudeAggregationStrategy{bucketCount: bucketCount}
 }
 
+// WithConvention returns a copy of the strategy that bins longitudes in the given convention
+// Longitudes are normalized before binning, so cities across the antimeridian fall into adjacent buckets
+func (s *LongitudeAggregationStrategy) WithConvention(convention geo.LongitudeConvention) *LongitudeAggregationStrategy {
+	return &LongitudeAggregationStrategy{bucketCount: s.bucketCount, convention: convention}
+}
+
 // Aggregate distributes cities into longitude buckets and sums population
+// Buckets are numbered as the repository histogram numbers them, so both paths agree
 func (s *LongitudeAggregationStrategy) Aggregate(cities *[]dto.CityDTO) interface{} {
 	if len(*cities) == 0 {
 		return &[]GraphData{}
 	}
 
-	// Find min/max longitude
-	minLong := (*cities)[0].Longitude
-	maxLong := (*cities)[0].Longitude
-
-	for _, c := range *cities {
-		if c.Longitude < minLong {
-			minLong = c.Longitude
-		}
-		if c.Longitude > maxLong {
-			maxLong = c.Longitude
-		}
+	// Normalize longitudes into the strategy convention
+	longitudes := make([]float64, len(*cities))
+	for i, c := range *cities {
+		longitudes[i] = s.convention.Normalize(c.Longitude)
 	}
 
-	step := (maxLong - minLong) / float64(s.bucketCount)
+	// Find min/max longitude
+	minLong := longitudes[0]
+	maxLong := longitudes[0]
 
-	// Build buckets
-	result := []GraphData{}
-	for i := 0; i < s.bucketCount; i++ {
-		bucketStart := minLong + float64(i)*step
-		bucketEnd := bucketStart + step
-
-		sum := 0
-		for _, d := range *cities {
-			if d.Longitude >= bucketStart && d.Longitude < bucketEnd {
-				sum += d.Population
-			}
-		}
+	for _, l := range longitudes {
+		minLong = min(minLong, l)
+		maxLong = max(maxLong, l)
+	}
 
-		result = append(result, GraphData{
-			X: bucketStart,
-			Y: sum,
-		})
+	buckets := dto.HistogramBuckets(minLong, maxLong, s.bucketCount)
+	for i, d := range *cities {
+		buckets[dto.HistogramBucket(longitudes[i], minLong, maxLong, s.bucketCount)].Population += int64(d.Population)
 	}
 
-	// Sort by longitude ascending
-	sort.Slice(result, func(i, j int) bool {
-		return result[i].X.(float64) < result[j].X.(float64)
-	})
+	return histogramGraphData(buckets)
+}
 
-	return &result
+// SupportsPushdown reports that the buckets can be computed by the repository
+func (s *LongitudeAggregationStrategy) SupportsPushdown() bool {
+	return true
+}
+
+// AggregateInRepository computes the buckets with a single histogram query
+// in the convention of the strategy
+func (s *LongitudeAggregationStrategy) AggregateInRepository(ctx context.Context, repository repo.CityRepository) (interface{}, error) {
+	buckets, err := repository.WithLongitudeConvention(s.convention).Histogram(ctx, repo.HistogramLongitude, s.bucketCount)
+	if err != nil {
+		return nil, err
+	}
+	return histogramGraphData(*buckets), nil
 }
 
 // Name returns the strategy name
@@ -188,27 +243,77 @@ func (s *LongitudeAggregationStrategy) Name() string {
 // StrategyAggregator is a context class that uses aggregation strategies
 // Allows switching between different aggregation approaches at runtime
 type StrategyAggregator struct {
-	repo *repo.CityRepo
+	repo repo.CityRepository
 }
 
 // NewStrategyAggregator creates a new aggregator with a repository
-func NewStrategyAggregator(repository *repo.CityRepo) *StrategyAggregator {
+func NewStrategyAggregator(repository repo.CityRepository) *StrategyAggregator {
 	return &StrategyAggregator{
 		repo: repository,
 	}
 }
 
+// LongitudeConvention returns the convention the repository returns longitudes in
+func (sa *StrategyAggregator) LongitudeConvention() geo.LongitudeConvention {
+	return sa.repo.LongitudeConvention()
+}
+
+// WithDataset returns an aggregator reading the given dataset version
+// Zero selects the most recently loaded dataset
+func (sa *StrategyAggregator) WithDataset(datasetID uint) *StrategyAggregator {
+	return NewStrategyAggregator(sa.repo.WithDataset(datasetID))
+}
+
+// WithYear returns an aggregator whose strategies see the populations observed
+// in the given census year, a reference year for every aggregation
+// Cities without an observation of that year are left out; zero uses the current populations
+func (sa *StrategyAggregator) WithYear(year int) *StrategyAggregator {
+	return NewStrategyAggregator(sa.repo.WithYear(year))
+}
+
+// Year returns the reference year of the aggregations, zero meaning the current populations
+func (sa *StrategyAggregator) Year() int {
+	return sa.repo.Year()
+}
+
 // Aggregate executes the provided strategy with city data from the repository
-func (sa *StrategyAggregator) Aggregate(strategy AggregationStrategy) interface{} {
-	cities := sa.repo.All()
-	return strategy.Aggregate(cities)
+// Strategies that support pushdown are computed by the repository instead of loading every city
+// A repository error is returned as is and the strategy is not run
+func (sa *StrategyAggregator) Aggregate(ctx context.Context, strategy AggregationStrategy) (interface{}, error) {
+	if p, ok := pushdown(strategy); ok {
+		return p.AggregateInRepository(ctx, sa.repo)
+	}
+
+	cities, err := sa.repo.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return strategy.Aggregate(cities), nil
 }
 
 // AggregateMultiple executes multiple strategies and returns results in order
-func (sa *StrategyAggregator) AggregateMultiple(strategies ...AggregationStrategy) []interface{} {
+// Strategies that support pushdown run their own queries; the cities are read
+// once, and only when another strategy needs them
+func (sa *StrategyAggregator) AggregateMultiple(ctx context.Context, strategies ...AggregationStrategy) ([]interface{}, error) {
+	var cities *[]dto.CityDTO
 	results := make([]interface{}, len(strategies))
 	for i, strategy := range strategies {
-		results[i] = sa.Aggregate(strategy)
+		if p, ok := pushdown(strategy); ok {
+			result, err := p.AggregateInRepository(ctx, sa.repo)
+			if err != nil {
+				return nil, err
+			}
+			results[i] = result
+			continue
+		}
+
+		if cities == nil {
+			var err error
+			if cities, err = sa.repo.All(ctx); err != nil {
+				return nil, err
+			}
+		}
+		results[i] = strategy.Aggregate(cities)
 	}
-	return results
+	return results, nil
 }
@@ -1,9 +1,13 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"settlements/internal/dto"
+	"settlements/internal/geo"
+	"settlements/internal/repo"
 )
 
 func TestSettlementTypeAggregationStrategy(t *testing.T) {
@@ -95,6 +99,30 @@ func TestLongitudeAggregationStrategy(t *testing.T) {
 	}
 }
 
+func TestLongitudeAggregationStrategyConvention(t *testing.T) {
+	// Chukotka straddles the antimeridian
+	cities := []dto.CityDTO{
+		{Longitude: 177.0, Population: 1000},
+		{Longitude: -179.0, Population: 2000},
+		{Longitude: -173.0, Population: 500},
+	}
+
+	strategy := NewLongitudeAggregationStrategy(3).WithConvention(geo.Positive)
+	data := *strategy.Aggregate(&cities).(*[]GraphData)
+
+	if len(data) != 3 {
+		t.Fatalf("Expected 3 buckets, got %d", len(data))
+	}
+
+	if data[0].X.(float64) != 177.0 {
+		t.Errorf("Expected first bucket at 177, got %v", data[0].X)
+	}
+
+	if data[0].Y != 1000 || data[1].Y != 2000 {
+		t.Errorf("Expected adjacent buckets 1000 and 2000, got %d and %d", data[0].Y, data[1].Y)
+	}
+}
+
 func TestLongitudeAggregationStrategyName(t *testing.T) {
 	strategy := NewLongitudeAggregationStrategy(100)
 	if strategy.Name() != "longitude_aggregation" {
@@ -159,4 +187,111 @@ func TestAggregationStrategyInterface(t *testing.T) {
 	var _ AggregationStrategy = &LongitudeAggregationStrategy{}
 	var _ AggregationStrategy = &CustomAggregationStrategy{}
 	// All implement AggregationStrategy interface
+
+	var _ PushdownStrategy = &SettlementTypeAggregationStrategy{}
+	var _ PushdownStrategy = &DistrictAggregationStrategy{}
+}
+
+// noScanRepo fails every query that loads all cities, so only pushed down strategies succeed
+type noScanRepo struct {
+	repo.CityRepository
+}
+
+func (noScanRepo) All(context.Context) (*[]dto.CityDTO, error) {
+	return nil, errors.New("cities were loaded")
+}
+
+func TestAggregatorPushesDown(t *testing.T) {
+	_, r := newMemoryService(t)
+	aggregator := NewStrategyAggregator(noScanRepo{r})
+	ctx := context.Background()
+
+	result, err := aggregator.Aggregate(ctx, &SettlementTypeAggregationStrategy{})
+	if err != nil {
+		t.Fatalf("Expected the type stats to be pushed down, got %v", err)
+	}
+	if types := *result.(*[]SettlementTypeData); len(types) != 2 || types[0].Type != "город" {
+		t.Errorf("Expected 2 types, город first, got %+v", types)
+	}
+
+	if _, err := aggregator.Aggregate(ctx, &DistrictAggregationStrategy{}); err != nil {
+		t.Errorf("Expected the district totals to be pushed down, got %v", err)
+	}
+
+	if _, err := aggregator.Aggregate(ctx, NewLongitudeAggregationStrategy(10)); err != nil {
+		t.Errorf("Expected the longitude buckets to be pushed down, got %v", err)
+	}
+
+	custom := &CustomAggregationStrategy{filterFunc: func(*dto.CityDTO) bool { return true }}
+	if _, err := aggregator.Aggregate(ctx, custom); err == nil {
+		t.Errorf("Expected the custom strategy to load the cities")
+	}
+}
+
+func TestLongitudePushdownMatchesAggregate(t *testing.T) {
+	_, r := newMemoryService(t)
+	ctx := context.Background()
+	cities, err := r.All(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	strategy := NewLongitudeAggregationStrategy(4).WithConvention(geo.Positive)
+	pushed, err := strategy.AggregateInRepository(ctx, r)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	want, got := *strategy.Aggregate(cities).(*[]GraphData), *pushed.(*[]GraphData)
+	if len(got) != 4 || len(want) != 4 {
+		t.Fatalf("Expected 4 buckets, got %d and %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expected %+v at %d, got %+v", want[i], i, got[i])
+		}
+	}
+	if got[3].Y != 13010112 {
+		t.Errorf("Expected the easternmost city in the last bucket, got %d", got[3].Y)
+	}
+}
+
+func TestPushdownMatchesAggregate(t *testing.T) {
+	_, r := newMemoryService(t)
+	ctx := context.Background()
+	cities, err := r.All(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	strategy := &DistrictAggregationStrategy{}
+	pushed, err := strategy.AggregateInRepository(ctx, r)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	want, got := *strategy.Aggregate(cities).(*[]GraphData), *pushed.(*[]GraphData)
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d districts, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expected %+v at %d, got %+v", want[i], i, got[i])
+		}
+	}
+}
+
+func TestAggregateMultipleMixesPaths(t *testing.T) {
+	_, r := newMemoryService(t)
+	aggregator := NewStrategyAggregator(r)
+
+	results, err := aggregator.AggregateMultiple(context.Background(),
+		&SettlementTypeAggregationStrategy{}, NewLongitudeAggregationStrategy(5), &DistrictAggregationStrategy{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+	if buckets := *results[1].(*[]GraphData); len(buckets) != 5 {
+		t.Errorf("Expected 5 longitude buckets, got %d", len(buckets))
+	}
 }
@@ -1,10 +1,21 @@
 package controller
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 
+	"settlements/internal/dto"
+	"settlements/internal/models"
+	"settlements/internal/repo"
 	"settlements/internal/service"
 	"settlements/internal/transport/http/router"
 )
@@ -14,38 +25,275 @@ type MainController struct {
 }
 
 type tmplData struct {
-	Table  template.JS
-	Chart1 template.JS
-	Chart2 template.JS
+	Table    template.JS
+	Chart1   template.JS
+	Chart2   template.JS
+	Datasets []dto.DatasetDTO
+	Dataset  uint
+	Years    []int
+	Year     int
 }
 
-var tmpl = template.Must(
-	template.Must(
-		template.New("jsData").Parse(src),
-	).ParseFiles("web/templates/index.html"),
-)
+// loadTemplate parses the page template on first use, so that a missing
+// template fails the page with a 500 instead of the whole process at start.
+var loadTemplate = sync.OnceValues(func() (*template.Template, error) {
+	t, err := template.New("jsData").Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return t.ParseFiles("web/templates/index.html")
+})
 
 func New(service *service.Service) *MainController {
 	return &MainController{service: service}
 }
 
+// datasetID reads the dataset version selected by ?dataset=<id>.
+// Zero, the latest version, is returned when none is given.
+func datasetID(r *http.Request) uint {
+	id, err := strconv.ParseUint(r.URL.Query().Get("dataset"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return uint(id)
+}
+
+// queryInt reads an integer query parameter, zero when it is missing or invalid.
+func queryInt(r *http.Request, name string) int {
+	v, err := strconv.Atoi(r.URL.Query().Get(name))
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
 func (c *MainController) GetMainPage(w http.ResponseWriter, r *http.Request, params router.Params) {
-	settelmentType := c.service.GetAllSettelmetTypeData()
+	// ?dataset=<id> selects a dataset version, the latest one is shown by default
+	datasetID := datasetID(r)
+	// ?year=<year> shows the populations of a census year instead of the current ones
+	year := queryInt(r, "year")
+	svc := c.service.WithDataset(datasetID).WithYear(year)
+	ctx := r.Context()
+
+	tmpl, err := loadTemplate()
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
+	settelmentType, err := svc.GetAllSettelmetTypeData(ctx)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
 	settelmentTypeJ, _ := json.Marshal(settelmentType)
 
-	longitudePopulation := c.service.GetLongitudePopulationData()
+	longitudePopulation, err := svc.GetLongitudePopulationData(ctx)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
 	longitudePopulationJ, _ := json.Marshal(longitudePopulation)
 
-	districtPopulation := c.service.GetDistrictPopulationData()
+	districtPopulation, err := svc.GetDistrictPopulationData(ctx)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
 	districtPopulationJ, _ := json.Marshal(districtPopulation)
 
+	datasets, err := svc.GetDatasets(ctx)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	years, err := svc.GetObservedYears(ctx)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+
 	data := tmplData{
-		Table:  template.JS(settelmentTypeJ),
-		Chart1: template.JS(longitudePopulationJ),
-		Chart2: template.JS(districtPopulationJ),
+		Table:    template.JS(settelmentTypeJ),
+		Chart1:   template.JS(longitudePopulationJ),
+		Chart2:   template.JS(districtPopulationJ),
+		Datasets: *datasets,
+		Dataset:  datasetID,
+		Years:    years,
+		Year:     year,
+	}
+
+	// Render into a buffer so a failing template still gets a clean 500
+	var page bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&page, "index.html", data); err != nil {
+		serverError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	page.WriteTo(w)
+}
+
+// GetCities returns as JSON a page of cities filtered, sorted and paged by
+// the query parameters: district, type, name (a prefix), min_population,
+// max_population, min_childrens, max_childrens, bbox (min_lat,min_lon,max_lat,max_lon),
+// sort (fields, "-" for descending), limit and either offset or cursor.
+func (c *MainController) GetCities(w http.ResponseWriter, r *http.Request, params router.Params) {
+	q, err := cityQuery(r)
+	if err == nil {
+		err = q.Validate()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := c.service.WithDataset(datasetID(r)).WithYear(queryInt(r, "year")).FindCities(r.Context(), q)
+	writeResult(w, r, page, err)
+}
+
+// cityQuery reads the query of GetCities from the request.
+func cityQuery(r *http.Request) (repo.CityQuery, error) {
+	values := r.URL.Query()
+	q := repo.CityQuery{
+		District:   values.Get("district"),
+		Type:       values.Get("type"),
+		NamePrefix: values.Get("name"),
+		Cursor:     values.Get("cursor"),
+	}
+
+	ints := []struct {
+		name string
+		dst  **int
+	}{
+		{"min_population", &q.Population.Min},
+		{"max_population", &q.Population.Max},
+		{"min_childrens", &q.Childrens.Min},
+		{"max_childrens", &q.Childrens.Max},
+	}
+	for _, p := range ints {
+		if v := values.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s %q", p.name, v)
+			}
+			*p.dst = &n
+		}
+	}
+	for name, dst := range map[string]*int{"limit": &q.Limit, "offset": &q.Offset} {
+		if v := values.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = n
+		}
+	}
+
+	if v := values.Get("bbox"); v != "" {
+		parts := strings.Split(v, ",")
+		if len(parts) != 4 {
+			return q, fmt.Errorf("bbox must be min_lat,min_lon,max_lat,max_lon")
+		}
+		var box [4]float64
+		for i, p := range parts {
+			f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+			if err != nil {
+				return q, fmt.Errorf("invalid bbox %q", v)
+			}
+			box[i] = f
+		}
+		q.BBox = &repo.BoundingBox{MinLatitude: box[0], MinLongitude: box[1], MaxLatitude: box[2], MaxLongitude: box[3]}
+	}
+
+	order, err := repo.ParseCitySort(values.Get("sort"))
+	if err != nil {
+		return q, err
+	}
+	q.Sort = order
+	return q, nil
+}
+
+// GetCitiesByCode returns as JSON the cities whose OKTMO or OKATO code,
+// chosen by the :scheme parameter, equals :code.
+func (c *MainController) GetCitiesByCode(w http.ResponseWriter, r *http.Request, params router.Params) {
+	scheme, err := models.ParseCodeScheme(params["scheme"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cities, err := c.service.WithDataset(datasetID(r)).GetCitiesByCode(r.Context(), scheme, params["code"])
+	writeResult(w, r, cities, err)
+}
+
+// GetCodeMismatches returns as JSON the cities whose codes belong to another
+// federal subject than the codes of their districts.
+func (c *MainController) GetCodeMismatches(w http.ResponseWriter, r *http.Request, params router.Params) {
+	mismatches, err := c.service.WithDataset(datasetID(r)).GetCodeMismatches(r.Context())
+	writeResult(w, r, mismatches, err)
+}
+
+// GetPopulationHistory returns as JSON the population observations of the
+// city with the ID given by :id, the earliest year first.
+func (c *MainController) GetPopulationHistory(w http.ResponseWriter, r *http.Request, params router.Params) {
+	cityID, err := strconv.ParseUint(params["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid city id", http.StatusBadRequest)
+		return
 	}
 
-	tmpl.ExecuteTemplate(w, "index.html", data)
+	history, err := c.service.GetPopulationHistory(r.Context(), uint(cityID))
+	writeResult(w, r, history, err)
+}
+
+// GetGrowthRates returns as JSON how populations changed between the census
+// years ?from= and ?to=, per city or, with ?by=district, per district.
+func (c *MainController) GetGrowthRates(w http.ResponseWriter, r *http.Request, params router.Params) {
+	from, to := queryInt(r, "from"), queryInt(r, "to")
+	if from <= 0 || to <= 0 || from == to {
+		http.Error(w, "from and to must be two different census years", http.StatusBadRequest)
+		return
+	}
+
+	svc := c.service.WithDataset(datasetID(r))
+	switch r.URL.Query().Get("by") {
+	case "", "city":
+		rates, err := svc.GetGrowthRates(r.Context(), from, to)
+		writeResult(w, r, rates, err)
+	case "district":
+		rates, err := svc.GetDistrictGrowthRates(r.Context(), from, to)
+		writeResult(w, r, rates, err)
+	default:
+		http.Error(w, `by must be "city" or "district"`, http.StatusBadRequest)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeResult writes v as JSON, or the error of the query that produced it.
+func writeResult(w http.ResponseWriter, r *http.Request, v any, err error) {
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeJSON(w, v)
+}
+
+// serverError logs a failed request and answers it with a 5xx. A query that
+// ran out of time is answered with 503 and a Retry-After, so clients know
+// to try again; any other error is a 500. The error is not shown to clients.
+func serverError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
 const src = `
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"settlements/internal/repo/memory"
+	"settlements/internal/service"
+	"settlements/internal/transport/http/router"
+)
+
+func newTestController(t *testing.T) (*MainController, *memory.CityRepo) {
+	t.Helper()
+
+	r := memory.New()
+	datasetID := r.AddDataset("test", time.Now())
+	r.AddCity(datasetID, memory.City{Name: "Тверь", Type: "город", District: "Тверская область", Population: 424969, OKTMO: "28701000001"})
+	return New(service.New(r)), r
+}
+
+func TestGetCitiesByCode(t *testing.T) {
+	c, _ := newTestController(t)
+
+	w := httptest.NewRecorder()
+	c.GetCitiesByCode(w, httptest.NewRequest(http.MethodGet, "/api/cities/oktmo/28701000001", nil),
+		router.Params{"scheme": "oktmo", "code": "28701000001"})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "Тверь") {
+		t.Errorf("Expected Тверь in the response, got %s", w.Body.String())
+	}
+}
+
+func TestRepositoryErrorIsServerError(t *testing.T) {
+	c, r := newTestController(t)
+	r.Fail(errors.New("connection refused"))
+
+	w := httptest.NewRecorder()
+	c.GetCodeMismatches(w, httptest.NewRequest(http.MethodGet, "/api/code-mismatches", nil), router.Params{})
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "connection refused") {
+		t.Errorf("Expected the error to be hidden from clients, got %s", w.Body.String())
+	}
+}
+
+func TestTimeoutIsRetryable(t *testing.T) {
+	c, _ := newTestController(t)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/population/1", nil).WithContext(ctx)
+	c.GetPopulationHistory(w, req, router.Params{"id": "1"})
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected a Retry-After header")
+	}
+}
+
+func TestMissingTemplateIsServerError(t *testing.T) {
+	// Tests run in the package directory, where web/templates is not found
+	c, _ := newTestController(t)
+
+	w := httptest.NewRecorder()
+	c.GetMainPage(w, httptest.NewRequest(http.MethodGet, "/", nil), router.Params{})
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", w.Code)
+	}
+}
+
+func TestGetCities(t *testing.T) {
+	c, _ := newTestController(t)
+
+	w := httptest.NewRecorder()
+	c.GetCities(w, httptest.NewRequest(http.MethodGet, "/api/cities?name=тв&sort=-population&limit=10", nil), router.Params{})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"Total":1`) {
+		t.Errorf("Expected a total of 1, got %s", w.Body.String())
+	}
+}
+
+func TestGetCitiesBadQuery(t *testing.T) {
+	c, _ := newTestController(t)
+
+	for _, query := range []string{"min_population=many", "bbox=1,2,3", "sort=area", "limit=5000", "offset=1&cursor=abc"} {
+		w := httptest.NewRecorder()
+		c.GetCities(w, httptest.NewRequest(http.MethodGet, "/api/cities?"+query, nil), router.Params{})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}